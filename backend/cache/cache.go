@@ -1,30 +1,159 @@
 // Package cache provides a thread-safe in-memory cache for user data.
+// The Store interface allows swapping in a Redis backend at startup.
 package cache
 
 import (
+	"math/rand"
 	"sort"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 )
 
+// Entry is a cached user. Country is an ISO 3166-1 alpha-2 code, or empty
+// if the user has none. Seq numbers users in the order the store first saw
+// them and is assigned by the store: Set keeps a user's existing Seq, and a
+// new user gets the next one unless it arrives with its own.
 type Entry struct {
-	Username string
-	Score    int
+	Username  string
+	Score     int
+	Country   string
+	Seq       uint64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Store is the storage contract shared by the in-memory and Redis caches.
+type Store interface {
+	Set(id string, entry Entry)
+	CompareAndSet(id string, old, entry Entry) bool
+	Get(id string) (Entry, bool)
+	GetByUsername(username string) (string, Entry, bool)
+	Delete(id string)
+	Size() int
+	Clear()
+	SearchByPrefix(prefix string, offset, limit int) ([]SearchResult, int)
+	SearchFuzzy(query string, maxDistance, limit int) []SearchResult
+	GetAllWithIDs() map[string]Entry
+	// Range calls fn for every entry. An error means the iteration stopped
+	// early, having missed some entries.
+	Range(fn func(id string, e Entry)) error
+	GetRandomIDs(count int) []string
+	// Stage returns an empty store to load into off to the side, so a
+	// failed load leaves this one untouched.
+	Stage() Staging
+}
+
+// Staging is a store being loaded in place of another. Commit replaces the
+// other store's contents with its own, replaying on top any writes the other
+// store took while the load ran; Discard drops it. A store has at most one
+// Staging at a time.
+type Staging interface {
+	Store
+	Commit() error
+	Discard()
+}
+
+// stageJournal records the writes a live store takes while a replacement is
+// staged: the last entry written per user, nil for a delete. cleared means
+// the store was cleared, dropping everything staged before it.
+type stageJournal struct {
+	mu      sync.Mutex
+	cleared bool
+	writes  map[string]*Entry
+}
+
+func newStageJournal() *stageJournal {
+	return &stageJournal{writes: make(map[string]*Entry)}
+}
+
+func (j *stageJournal) set(id string, e Entry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.writes[id] = &e
+}
+
+func (j *stageJournal) delete(id string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.writes[id] = nil
+}
+
+func (j *stageJournal) clear() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.cleared = true
+	j.writes = make(map[string]*Entry)
 }
 
 type UserCache struct {
 	mu   sync.RWMutex
 	data map[string]Entry
+	// byName maps each username to its user ID. It is updated under mu
+	// alongside data, so the two never disagree.
+	byName map[string]string
+	// seq is the last Seq handed out. Clear leaves it, so numbers only grow.
+	seq uint64
+	// journal, set while a replacement is staged, records every write.
+	journal *stageJournal
 }
 
-var Global = &UserCache{
-	data: make(map[string]Entry),
+// Global is the active store. Defaults to the in-memory cache.
+var Global Store = NewUserCache()
+
+func NewUserCache() *UserCache {
+	return &UserCache{
+		data:   make(map[string]Entry),
+		byName: make(map[string]string),
+	}
 }
 
 func (c *UserCache) Set(id string, entry Entry) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.put(id, entry)
+}
+
+// put stores entry and keeps byName in step, dropping the old name on a
+// rename. Callers must hold the write lock.
+func (c *UserCache) put(id string, entry Entry) {
+	old, ok := c.data[id]
+	switch {
+	case ok:
+		entry.Seq = old.Seq
+		if old.Username != entry.Username {
+			c.unindex(id, old.Username)
+		}
+	case entry.Seq == 0:
+		c.seq++
+		entry.Seq = c.seq
+	default:
+		c.seq = max(c.seq, entry.Seq)
+	}
 	c.data[id] = entry
+	c.byName[entry.Username] = id
+	if c.journal != nil {
+		c.journal.set(id, entry)
+	}
+}
+
+// unindex removes name from byName if it still points at id.
+func (c *UserCache) unindex(id, name string) {
+	if c.byName[name] == id {
+		delete(c.byName, name)
+	}
+}
+
+// CompareAndSet stores entry only if the current value still equals old.
+func (c *UserCache) CompareAndSet(id string, old, entry Entry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cur, ok := c.data[id]; !ok || cur != old {
+		return false
+	}
+	c.put(id, entry)
+	return true
 }
 
 func (c *UserCache) Get(id string) (Entry, bool) {
@@ -34,10 +163,32 @@ func (c *UserCache) Get(id string) (Entry, bool) {
 	return e, ok
 }
 
+// GetByUsername returns the ID and entry of the user with an exact username match.
+func (c *UserCache) GetByUsername(username string) (string, Entry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	id, ok := c.byName[username]
+	if !ok {
+		return "", Entry{}, false
+	}
+	return id, c.data[id], true
+}
+
 func (c *UserCache) Delete(id string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	delete(c.data, id)
+	c.remove(id)
+	if c.journal != nil {
+		c.journal.delete(id)
+	}
+}
+
+// remove drops id and its name. Callers must hold the write lock.
+func (c *UserCache) remove(id string) {
+	if e, ok := c.data[id]; ok {
+		c.unindex(id, e.Username)
+		delete(c.data, id)
+	}
 }
 
 func (c *UserCache) Size() int {
@@ -50,33 +201,155 @@ func (c *UserCache) Clear() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.data = make(map[string]Entry)
+	c.byName = make(map[string]string)
+	if c.journal != nil {
+		c.journal.clear()
+	}
+}
+
+// Stage returns an empty cache that carries on c's Seq numbering. c records
+// its writes from here on, so Commit can keep them.
+func (c *UserCache) Stage() Staging {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	staged := NewUserCache()
+	staged.seq = c.seq
+	c.journal = newStageJournal()
+	return &stagedCache{UserCache: staged, live: c, journal: c.journal}
 }
 
+type stagedCache struct {
+	*UserCache
+	live    *UserCache
+	journal *stageJournal
+}
+
+// Commit replays the live cache's writes since Stage over the staged maps,
+// hands them to the live cache and leaves the stage empty. Both locks are
+// held throughout, so no write can land between the replay and the swap.
+func (s *stagedCache) Commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.live.mu.Lock()
+	defer s.live.mu.Unlock()
+
+	if s.journal.cleared {
+		s.data = make(map[string]Entry)
+		s.byName = make(map[string]string)
+	}
+	for id, e := range s.journal.writes {
+		if e == nil {
+			s.remove(id)
+			continue
+		}
+		// Both caches numbered new users from the same point, so a user
+		// the load didn't see takes a fresh Seq rather than the live one
+		entry := *e
+		entry.Seq = 0
+		s.put(id, entry)
+	}
+
+	s.live.data, s.live.byName = s.data, s.byName
+	s.live.seq = max(s.live.seq, s.seq)
+	s.live.journal = nil
+	s.data = make(map[string]Entry)
+	s.byName = make(map[string]string)
+	return nil
+}
+
+func (s *stagedCache) Discard() {
+	s.live.mu.Lock()
+	if s.live.journal == s.journal {
+		s.live.journal = nil
+	}
+	s.live.mu.Unlock()
+	s.Clear()
+}
+
+// SearchResult is a search match. Distance is only set by fuzzy search.
+// MatchStart and MatchEnd delimit the matched part of the username, in
+// characters (runes), so clients can highlight it.
 type SearchResult struct {
-	UserID   string
-	Username string
-	Score    int
+	UserID string
+	Entry
+	Distance   int
+	MatchStart int
+	MatchEnd   int
 }
 
-func (c *UserCache) SearchByPrefix(prefix string, limit int) []SearchResult {
+func (c *UserCache) SearchByPrefix(prefix string, offset, limit int) ([]SearchResult, int) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
+	return searchByPrefix(c.data, prefix, offset, limit)
+}
 
+// searchOrder sorts by score, breaking ties by username as the snapshot
+// does, then by ID. It is a total order, so repeated searches match exactly.
+func searchOrder(a, b SearchResult) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	if a.Username != b.Username {
+		return a.Username < b.Username
+	}
+	return a.UserID < b.UserID
+}
+
+// searchByPrefix returns up to limit case-insensitive prefix matches after
+// skipping offset, and the total number of matches, in searchOrder.
+func searchByPrefix(data map[string]Entry, prefix string, offset, limit int) ([]SearchResult, int) {
 	prefix = strings.ToLower(prefix)
+	// Lowercasing maps rune for rune, so the match spans as many runes of
+	// the username as the prefix has
+	matchEnd := utf8.RuneCountInString(prefix)
 	var results []SearchResult
 
-	for id, e := range c.data {
+	for id, e := range data {
 		if strings.HasPrefix(strings.ToLower(e.Username), prefix) {
-			results = append(results, SearchResult{
-				UserID:   id,
-				Username: e.Username,
-				Score:    e.Score,
-			})
+			results = append(results, SearchResult{UserID: id, Entry: e, MatchEnd: matchEnd})
 		}
 	}
 
 	sort.Slice(results, func(i, j int) bool {
-		return results[i].Score > results[j].Score
+		return searchOrder(results[i], results[j])
+	})
+
+	total := len(results)
+	if offset >= total {
+		return []SearchResult{}, total
+	}
+	results = results[offset:]
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, total
+}
+
+func (c *UserCache) SearchFuzzy(query string, maxDistance, limit int) []SearchResult {
+	return searchFuzzy(c.Range, query, maxDistance, limit)
+}
+
+// searchFuzzy returns usernames within maxDistance edits of the query, closest
+// first and then by score. Distance is measured against the best-matching
+// prefix of each username, so an exact prefix match scores 0 just like
+// SearchByPrefix. Costs O(users × query length × username length).
+func searchFuzzy(each func(func(id string, e Entry)) error, query string, maxDistance, limit int) []SearchResult {
+	q := []rune(strings.ToLower(query))
+	var results []SearchResult
+
+	// A failed Range is already logged; search what it did return
+	each(func(id string, e Entry) {
+		if d, end, ok := prefixDistance(q, []rune(strings.ToLower(e.Username)), maxDistance); ok {
+			results = append(results, SearchResult{UserID: id, Entry: e, Distance: d, MatchEnd: end})
+		}
+	})
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Distance != results[j].Distance {
+			return results[i].Distance < results[j].Distance
+		}
+		return searchOrder(results[i], results[j])
 	})
 
 	if len(results) > limit {
@@ -85,6 +358,45 @@ func (c *UserCache) SearchByPrefix(prefix string, limit int) []SearchResult {
 	return results
 }
 
+// prefixDistance is the smallest Levenshtein distance between q and any
+// prefix of name, along with that prefix's length. The shortest prefix wins
+// a tie. It gives up once every path exceeds maxDistance.
+func prefixDistance(q, name []rune, maxDistance int) (int, int, bool) {
+	// prev[j] is the distance between the query so far and name[:j]
+	prev := make([]int, len(name)+1)
+	curr := make([]int, len(name)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(q); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(name); j++ {
+			cost := 1
+			if q[i-1] == name[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin > maxDistance {
+			return 0, 0, false
+		}
+		prev, curr = curr, prev
+	}
+
+	best, end := prev[0], 0
+	for j, d := range prev[1:] {
+		if d < best {
+			best, end = d, j+1
+		}
+	}
+	return best, end, best <= maxDistance
+}
+
 func (c *UserCache) GetAllWithIDs() map[string]Entry {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -96,17 +408,41 @@ func (c *UserCache) GetAllWithIDs() map[string]Entry {
 	return result
 }
 
-func (c *UserCache) GetRandomIDs(count int) []string {
+// Range calls fn for every entry without copying the map. The cache is
+// read-locked throughout, so fn must not call back into it. It never fails.
+func (c *UserCache) Range(fn func(id string, e Entry)) error {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
+	for id, e := range c.data {
+		fn(id, e)
+	}
+	return nil
+}
 
+// GetRandomIDs returns a uniform random sample of up to count distinct IDs.
+func (c *UserCache) GetRandomIDs(count int) []string {
+	c.mu.RLock()
 	ids := make([]string, 0, len(c.data))
 	for id := range c.data {
 		ids = append(ids, id)
 	}
+	c.mu.RUnlock()
+
+	return sampleIDs(ids, count)
+}
 
+// sampleIDs shuffles only the first count positions (partial Fisher-Yates)
+// and returns them. ids is reordered in place.
+func sampleIDs(ids []string, count int) []string {
 	if count > len(ids) {
 		count = len(ids)
 	}
+	if count < 0 {
+		count = 0
+	}
+	for i := 0; i < count; i++ {
+		j := i + rand.Intn(len(ids)-i)
+		ids[i], ids[j] = ids[j], ids[i]
+	}
 	return ids[:count]
 }
@@ -0,0 +1,204 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestGetRandomIDsIsUniform(t *testing.T) {
+	const users, count, trials = 10, 3, 30000
+	c := NewUserCache()
+	for i := 0; i < users; i++ {
+		c.Set(strconv.Itoa(i), Entry{Username: "user" + strconv.Itoa(i)})
+	}
+
+	picked := make(map[string]int)
+	first := make(map[string]int)
+	for trial := 0; trial < trials; trial++ {
+		ids := c.GetRandomIDs(count)
+		if len(ids) != count {
+			t.Fatalf("GetRandomIDs(%d) returned %d IDs", count, len(ids))
+		}
+		seen := make(map[string]bool)
+		for _, id := range ids {
+			if seen[id] {
+				t.Fatalf("GetRandomIDs returned %s twice: %v", id, ids)
+			}
+			seen[id] = true
+			picked[id]++
+		}
+		first[ids[0]]++
+	}
+
+	// Each user should be picked count/users of the time, and lead a sample
+	// 1/users of the time. The margins are over five standard deviations.
+	wantPicked := trials * count / users
+	wantFirst := trials / users
+	for i := 0; i < users; i++ {
+		id := strconv.Itoa(i)
+		if got := picked[id]; got < wantPicked*95/100 || got > wantPicked*105/100 {
+			t.Errorf("user %s picked %d times, want about %d", id, got, wantPicked)
+		}
+		if got := first[id]; got < wantFirst*90/100 || got > wantFirst*110/100 {
+			t.Errorf("user %s picked first %d times, want about %d", id, got, wantFirst)
+		}
+	}
+}
+
+func TestGetRandomIDsClampsCount(t *testing.T) {
+	c := NewUserCache()
+	for i := 0; i < 3; i++ {
+		c.Set(strconv.Itoa(i), Entry{Username: "user" + strconv.Itoa(i)})
+	}
+	for count, want := range map[int]int{-1: 0, 0: 0, 2: 2, 3: 3, 10: 3} {
+		if got := len(c.GetRandomIDs(count)); got != want {
+			t.Errorf("GetRandomIDs(%d) returned %d IDs, want %d", count, got, want)
+		}
+	}
+}
+
+// checkNameIndex fails unless byName maps exactly the cached usernames to
+// their users.
+func checkNameIndex(t *testing.T, c *UserCache) {
+	t.Helper()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.byName) != len(c.data) {
+		t.Errorf("byName holds %d names for %d users", len(c.byName), len(c.data))
+	}
+	for id, e := range c.data {
+		if got := c.byName[e.Username]; got != id {
+			t.Errorf("byName[%q] = %q, want %q", e.Username, got, id)
+		}
+	}
+}
+
+func TestRenameUpdatesNameIndex(t *testing.T) {
+	c := NewUserCache()
+	c.Set("1", Entry{Username: "alice", Score: 300})
+	c.Set("1", Entry{Username: "alicia", Score: 300})
+
+	if _, _, ok := c.GetByUsername("alice"); ok {
+		t.Error("old name still resolves after a rename")
+	}
+	if id, _, ok := c.GetByUsername("alicia"); !ok || id != "1" {
+		t.Errorf("GetByUsername(alicia) = %q, %v, want 1", id, ok)
+	}
+	checkNameIndex(t, c)
+}
+
+func TestConcurrentRenamesKeepNameIndex(t *testing.T) {
+	const users, workers, rounds = 20, 8, 500
+	c := NewUserCache()
+	for i := 0; i < users; i++ {
+		c.Set(strconv.Itoa(i), Entry{Username: "user" + strconv.Itoa(i)})
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				id := strconv.Itoa((w*rounds + r) % users)
+				// Each rename is unique, so names never collide between users
+				name := "user" + id + "-" + strconv.Itoa(w) + "-" + strconv.Itoa(r)
+				switch r % 3 {
+				case 0:
+					c.Set(id, Entry{Username: name, Score: r})
+				case 1:
+					if old, ok := c.Get(id); ok {
+						c.CompareAndSet(id, old, Entry{Username: name, Score: r})
+					}
+				default:
+					c.Delete(id)
+					c.Set(id, Entry{Username: name, Score: r})
+				}
+				c.GetByUsername(name)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if c.Size() != users {
+		t.Errorf("cache holds %d users, want %d", c.Size(), users)
+	}
+	checkNameIndex(t, c)
+}
+
+func TestWritesDuringStageSurviveCommit(t *testing.T) {
+	c := NewUserCache()
+	c.Set("1", Entry{Username: "alice", Score: 300})
+	c.Set("2", Entry{Username: "bob", Score: 200})
+	c.Set("3", Entry{Username: "carol", Score: 100})
+
+	staged := c.Stage()
+	// The load reads the database as it was when the cursor passed
+	staged.Set("1", Entry{Username: "alice", Score: 300})
+	staged.Set("2", Entry{Username: "bob", Score: 200})
+	// Meanwhile the live cache takes an update, a delete, a rename and a
+	// new user
+	c.Set("1", Entry{Username: "alice", Score: 450})
+	c.Delete("2")
+	staged.Set("3", Entry{Username: "carol", Score: 100})
+	c.Set("3", Entry{Username: "caroline", Score: 100})
+	c.Set("4", Entry{Username: "dave", Score: 250})
+	if old, ok := c.Get("4"); !ok || !c.CompareAndSet("4", old, Entry{Username: "dave", Score: 260}) {
+		t.Fatal("CompareAndSet on the live cache failed")
+	}
+
+	if err := staged.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	want := map[string]Entry{
+		"1": {Username: "alice", Score: 450},
+		"3": {Username: "caroline", Score: 100},
+		"4": {Username: "dave", Score: 260},
+	}
+	if c.Size() != len(want) {
+		t.Errorf("cache holds %d users after Commit, want %d", c.Size(), len(want))
+	}
+	seqs := make(map[uint64]string)
+	for id, w := range want {
+		got, ok := c.Get(id)
+		if !ok || got.Username != w.Username || got.Score != w.Score {
+			t.Errorf("user %s = %+v, %v, want %+v", id, got, ok, w)
+		}
+		if other, dup := seqs[got.Seq]; dup {
+			t.Errorf("users %s and %s share Seq %d", id, other, got.Seq)
+		}
+		seqs[got.Seq] = id
+	}
+	checkNameIndex(t, c)
+
+	// Writes after Commit are no longer recorded
+	c.Set("5", Entry{Username: "erin", Score: 150})
+	if c.journal != nil {
+		t.Error("live cache still journals after Commit")
+	}
+}
+
+func TestClearDuringStageDropsEarlierLoad(t *testing.T) {
+	c := NewUserCache()
+	staged := c.Stage()
+	staged.Set("1", Entry{Username: "alice", Score: 300})
+	c.Clear()
+	c.Set("2", Entry{Username: "bob", Score: 200})
+	if err := staged.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if _, ok := c.Get("1"); ok || c.Size() != 1 {
+		t.Errorf("cache after Clear during stage holds %d users, want only bob", c.Size())
+	}
+}
+
+func TestDiscardStopsJournal(t *testing.T) {
+	c := NewUserCache()
+	c.Stage().Discard()
+	c.Set("1", Entry{Username: "alice", Score: 300})
+	if c.journal != nil {
+		t.Error("live cache still journals after Discard")
+	}
+}
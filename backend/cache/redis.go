@@ -0,0 +1,379 @@
+// Package cache provides a Redis-backed implementation of Store.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisOpTimeout bounds each Redis command. A var so tests can shorten it.
+var redisOpTimeout = 5 * time.Second
+
+const (
+	redisUsersKey = "leaderboard:users"
+	// redisSeqSuffix names the Seq counter beside a store's hash. Board IDs
+	// can't contain '#', so it never collides with a namespace.
+	redisSeqSuffix = "#seq"
+	// redisStagingSuffix names the hash a reload is staged into.
+	redisStagingSuffix = "#staging"
+)
+
+// RedisStore keeps every user in a single Redis hash: userID → JSON entry.
+// Errors are logged and treated as misses so it can stand in for UserCache.
+type RedisStore struct {
+	client *redis.Client
+	key    string
+	// seqKey is the Seq counter. A staged store shares the live one's, so
+	// users it numbers keep their place after the swap.
+	seqKey string
+	// journal, set while a replacement is staged, records every write.
+	// Writes hold commitMu for reading, so Commit can shut them out while
+	// it replays the journal and swaps the hashes.
+	journal  atomic.Pointer[stageJournal]
+	commitMu sync.RWMutex
+}
+
+type redisEntry struct {
+	Username  string    `json:"u"`
+	Score     int       `json:"s"`
+	Country   string    `json:"k,omitempty"`
+	Seq       uint64    `json:"q,omitempty"`
+	CreatedAt time.Time `json:"c"`
+	UpdatedAt time.Time `json:"t"`
+}
+
+// compareAndSetScript replaces a hash field only if it still holds the expected value.
+var compareAndSetScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then
+	redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
+	return 1
+end
+return 0
+`)
+
+// NewRedisStore connects to the Redis instance at uri and verifies it responds.
+func NewRedisStore(ctx context.Context, uri string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without this the client ignores context deadlines on reads, so
+	// redisOpTimeout wouldn't bound a stalled command
+	opts.ContextTimeoutEnabled = true
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &RedisStore{client: client, key: redisUsersKey, seqKey: redisUsersKey + redisSeqSuffix}, nil
+}
+
+// Namespace returns a store sharing this connection but keeping its users
+// under a separate hash, so independent leaderboards don't collide.
+func (r *RedisStore) Namespace(name string) *RedisStore {
+	key := redisUsersKey + ":" + name
+	return &RedisStore{client: r.client, key: key, seqKey: key + redisSeqSuffix}
+}
+
+// Set keeps the Seq the user already has, which costs an extra lookup. Two
+// racing first writes of one user may each draw a number; either is a valid
+// insertion order.
+func (r *RedisStore) Set(id string, entry Entry) {
+	r.commitMu.RLock()
+	defer r.commitMu.RUnlock()
+
+	if cur, ok := r.Get(id); ok && cur.Seq != 0 {
+		entry.Seq = cur.Seq
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	if entry.Seq == 0 {
+		seq, err := r.client.Incr(ctx, r.seqKey).Uint64()
+		if err != nil {
+			log.Printf("⚠️ Redis INCR failed: %v", err)
+		}
+		entry.Seq = seq
+	}
+	if err := r.client.HSet(ctx, r.key, id, encodeRedisEntry(entry)).Err(); err != nil {
+		log.Printf("⚠️ Redis HSET failed: %v", err)
+	}
+	if j := r.journal.Load(); j != nil {
+		j.set(id, entry)
+	}
+}
+
+func (r *RedisStore) CompareAndSet(id string, old, entry Entry) bool {
+	r.commitMu.RLock()
+	defer r.commitMu.RUnlock()
+
+	if entry.Seq == 0 {
+		entry.Seq = old.Seq
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	n, err := compareAndSetScript.Run(ctx, r.client, []string{r.key},
+		id, encodeRedisEntry(old), encodeRedisEntry(entry)).Int()
+	if err != nil {
+		log.Printf("⚠️ Redis compare-and-set failed: %v", err)
+		return false
+	}
+	if j := r.journal.Load(); j != nil && n == 1 {
+		j.set(id, entry)
+	}
+	return n == 1
+}
+
+func (r *RedisStore) Get(id string) (Entry, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	value, err := r.client.HGet(ctx, r.key, id).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("⚠️ Redis HGET failed: %v", err)
+		}
+		return Entry{}, false
+	}
+	return decodeRedisEntry(value)
+}
+
+// GetByUsername scans the hash; unlike UserCache there is no name index, as
+// keeping one consistent would need every write to become a transaction.
+func (r *RedisStore) GetByUsername(username string) (string, Entry, bool) {
+	for id, e := range r.GetAllWithIDs() {
+		if e.Username == username {
+			return id, e, true
+		}
+	}
+	return "", Entry{}, false
+}
+
+func (r *RedisStore) Delete(id string) {
+	r.commitMu.RLock()
+	defer r.commitMu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	if err := r.client.HDel(ctx, r.key, id).Err(); err != nil {
+		log.Printf("⚠️ Redis HDEL failed: %v", err)
+	}
+	if j := r.journal.Load(); j != nil {
+		j.delete(id)
+	}
+}
+
+func (r *RedisStore) Size() int {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	n, err := r.client.HLen(ctx, r.key).Result()
+	if err != nil {
+		log.Printf("⚠️ Redis HLEN failed: %v", err)
+		return 0
+	}
+	return int(n)
+}
+
+func (r *RedisStore) Clear() {
+	r.commitMu.RLock()
+	defer r.commitMu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	if err := r.client.Del(ctx, r.key).Err(); err != nil {
+		log.Printf("⚠️ Redis DEL failed: %v", err)
+	}
+	if j := r.journal.Load(); j != nil {
+		j.clear()
+	}
+}
+
+// Stage returns an empty store under a staging key. r records its writes
+// from here on; Commit replays them over the staged hash and renames it over
+// r's, which replaces r's contents in one step. Only writes made through r
+// are recorded, not those of other processes sharing the hash.
+func (r *RedisStore) Stage() Staging {
+	staged := &RedisStore{client: r.client, key: r.key + redisStagingSuffix, seqKey: r.seqKey}
+	staged.Clear()
+	j := newStageJournal()
+	r.journal.Store(j)
+	return &stagedRedis{RedisStore: staged, live: r, writes: j}
+}
+
+type stagedRedis struct {
+	*RedisStore
+	live   *RedisStore
+	writes *stageJournal
+}
+
+// Commit replays the live store's writes since Stage over the staged hash,
+// then renames it over the live one. Redis doesn't keep empty hashes, so an
+// empty stage commits by deleting the live hash. Live writes wait for it.
+func (s *stagedRedis) Commit() error {
+	s.live.commitMu.Lock()
+	defer s.live.commitMu.Unlock()
+	s.live.journal.CompareAndSwap(s.writes, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	if err := s.replay(ctx); err != nil {
+		return err
+	}
+	n, err := s.client.Exists(ctx, s.key).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return s.client.Del(ctx, s.live.key).Err()
+	}
+	return s.client.Rename(ctx, s.key, s.live.key).Err()
+}
+
+// replay applies the recorded writes to the staged hash in one pipeline.
+// The entries keep their Seq, as both hashes draw from the same counter.
+func (s *stagedRedis) replay(ctx context.Context) error {
+	s.writes.mu.Lock()
+	defer s.writes.mu.Unlock()
+
+	pipe := s.client.TxPipeline()
+	if s.writes.cleared {
+		pipe.Del(ctx, s.key)
+	}
+	for id, e := range s.writes.writes {
+		if e == nil {
+			pipe.HDel(ctx, s.key, id)
+		} else {
+			pipe.HSet(ctx, s.key, id, encodeRedisEntry(*e))
+		}
+	}
+	if pipe.Len() == 0 {
+		return nil
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *stagedRedis) Discard() {
+	s.live.journal.CompareAndSwap(s.writes, nil)
+	s.Clear()
+}
+
+func (r *RedisStore) SearchByPrefix(prefix string, offset, limit int) ([]SearchResult, int) {
+	return searchByPrefix(r.GetAllWithIDs(), prefix, offset, limit)
+}
+
+func (r *RedisStore) SearchFuzzy(query string, maxDistance, limit int) []SearchResult {
+	return searchFuzzy(r.Range, query, maxDistance, limit)
+}
+
+func (r *RedisStore) GetAllWithIDs() map[string]Entry {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	values, err := r.client.HGetAll(ctx, r.key).Result()
+	if err != nil {
+		log.Printf("⚠️ Redis HGETALL failed: %v", err)
+		return map[string]Entry{}
+	}
+
+	result := make(map[string]Entry, len(values))
+	for id, value := range values {
+		if e, ok := decodeRedisEntry(value); ok {
+			result[id] = e
+		}
+	}
+	return result
+}
+
+// rangeScanCount is the HSCAN batch size hint used by Range.
+const rangeScanCount = 1000
+
+// Range streams the hash with HSCAN, so the whole store is never held in
+// memory at once. Entries changed during the scan may be seen twice or not at all.
+// Each page gets its own timeout, so a large hash can take as long as it
+// needs while a stalled page still fails.
+func (r *RedisStore) Range(fn func(id string, e Entry)) error {
+	var cursor uint64
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+		page, next, err := r.client.HScan(ctx, r.key, cursor, "", rangeScanCount).Result()
+		cancel()
+		if err != nil {
+			log.Printf("⚠️ Redis HSCAN failed: %v", err)
+			return err
+		}
+
+		for i := 0; i+1 < len(page); i += 2 {
+			if e, ok := decodeRedisEntry(page[i+1]); ok {
+				fn(page[i], e)
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func (r *RedisStore) GetRandomIDs(count int) []string {
+	if count <= 0 {
+		return []string{}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	// A positive count makes HRANDFIELD return distinct fields
+	ids, err := r.client.HRandField(ctx, r.key, count).Result()
+	if err != nil {
+		log.Printf("⚠️ Redis HRANDFIELD failed: %v", err)
+		return []string{}
+	}
+	return ids
+}
+
+// Close releases the underlying Redis connection pool.
+func (r *RedisStore) Close() error {
+	return r.client.Close()
+}
+
+func encodeRedisEntry(entry Entry) string {
+	value, _ := json.Marshal(redisEntry{
+		Username:  entry.Username,
+		Score:     entry.Score,
+		Country:   entry.Country,
+		Seq:       entry.Seq,
+		CreatedAt: entry.CreatedAt.UTC(),
+		UpdatedAt: entry.UpdatedAt.UTC(),
+	})
+	return string(value)
+}
+
+func decodeRedisEntry(value string) (Entry, bool) {
+	var e redisEntry
+	if err := json.Unmarshal([]byte(value), &e); err != nil {
+		return Entry{}, false
+	}
+	return Entry{
+		Username:  e.Username,
+		Score:     e.Score,
+		Country:   e.Country,
+		Seq:       e.Seq,
+		CreatedAt: e.CreatedAt,
+		UpdatedAt: e.UpdatedAt,
+	}, true
+}
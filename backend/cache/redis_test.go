@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis answers HSCAN from pages, each a list of field, value pairs,
+// and fails every other command. With stall set, the scan never ends: the
+// last page points at one more that is never answered.
+func fakeRedis(t *testing.T, pages [][]string, stall bool) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			go serveFakeRedis(conn, pages, stall)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveFakeRedis(conn net.Conn, pages [][]string, stall bool) {
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if !strings.EqualFold(args[0], "hscan") {
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+			continue
+		}
+		page, _ := strconv.Atoi(args[2])
+		if page >= len(pages) {
+			continue
+		}
+		next := page + 1
+		if next == len(pages) && !stall {
+			next = 0
+		}
+		cursor := strconv.Itoa(next)
+		fmt.Fprintf(conn, "*2\r\n$%d\r\n%s\r\n*%d\r\n", len(cursor), cursor, len(pages[page]))
+		for _, v := range pages[page] {
+			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+		}
+	}
+}
+
+// readCommand reads one RESP array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+// newFakeRedisStore returns a store on a fakeRedis server.
+func newFakeRedisStore(t *testing.T, pages [][]string, stall bool) *RedisStore {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{
+		Addr:                  fakeRedis(t, pages, stall),
+		Protocol:              2,
+		DisableIndentity:      true,
+		ContextTimeoutEnabled: true,
+	})
+	t.Cleanup(func() { client.Close() })
+	return &RedisStore{client: client, key: redisUsersKey, seqKey: redisUsersKey + redisSeqSuffix}
+}
+
+func TestRedisRangeReadsEveryPage(t *testing.T) {
+	entry := encodeRedisEntry(Entry{Username: "alice", Score: 300})
+	store := newFakeRedisStore(t, [][]string{{"id1", entry}, {"id2", entry, "id3", entry}}, false)
+
+	seen := make(map[string]bool)
+	if err := store.Range(func(id string, e Entry) { seen[id] = true }); err != nil {
+		t.Fatalf("Range: %v", err)
+	}
+	if len(seen) != 3 {
+		t.Errorf("Range saw %v, want id1 to id3", seen)
+	}
+}
+
+func TestRedisRangeFailsOnStalledPage(t *testing.T) {
+	old := redisOpTimeout
+	redisOpTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { redisOpTimeout = old })
+
+	entry := encodeRedisEntry(Entry{Username: "alice", Score: 300})
+	store := newFakeRedisStore(t, [][]string{{"id1", entry}}, true)
+
+	seen := 0
+	start := time.Now()
+	err := store.Range(func(id string, e Entry) { seen++ })
+	if err == nil {
+		t.Fatal("Range succeeded with a stalled page")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Range took %v to give up, want about %v", elapsed, redisOpTimeout)
+	}
+	if seen != 1 {
+		t.Errorf("Range saw %d entries before failing, want 1", seen)
+	}
+}
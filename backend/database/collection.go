@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collections is the subset of *mongo.Collection the services use. Callers
+// go through it rather than the concrete type so a fake can stand in for
+// MongoDB; mongo.NewCursorFromDocuments and mongo.NewSingleResultFromDocument
+// build the cursors and results a fake returns.
+type Collections interface {
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult
+	CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error)
+	UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+}
+
+var _ Collections = (*mongo.Collection)(nil)
+
+var (
+	// overrideMu guards override, which tests swap in and out.
+	overrideMu sync.RWMutex
+	override   func(name string) Collections
+)
+
+// UseCollections routes Collection to open instead of MongoDB, typically to
+// an in-memory fake in tests. Pass nil to go back to the real database.
+// Index creation is skipped while an override is installed.
+func UseCollections(open func(name string) Collections) {
+	overrideMu.Lock()
+	override = open
+	overrideMu.Unlock()
+}
+
+// overridden returns the installed collection override, or nil.
+func overridden() func(name string) Collections {
+	overrideMu.RLock()
+	defer overrideMu.RUnlock()
+	return override
+}
+
+// EnsureIndex creates model on the named collection if it doesn't exist.
+// It is a no-op while UseCollections has an override installed.
+func EnsureIndex(ctx context.Context, name string, model mongo.IndexModel) error {
+	if overridden() != nil {
+		return nil
+	}
+	mu.RLock()
+	coll := database.Collection(name)
+	mu.RUnlock()
+	_, err := coll.Indexes().CreateOne(ctx, model)
+	return err
+}
@@ -0,0 +1,740 @@
+// Package dbtest provides in-memory collections that stand in for MongoDB in
+// tests. They understand the subset of queries and updates the services
+// send: equality and comparison filters, $set/$unset/$inc updates and the
+// $set/$unset update pipelines with $add/$min/$max. Anything else panics, so a test never
+// passes against a query the fake silently misread.
+package dbtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"matiks-leaderboard/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// duplicateKeyCode is the server's code for a unique index violation.
+const duplicateKeyCode = 11000
+
+// DB is a set of in-memory collections, created on first use.
+type DB struct {
+	mu          sync.Mutex
+	collections map[string]*Collection
+}
+
+// New returns an empty DB. Its users collection enforces the same unique
+// board and username index the real database has.
+func New() *DB {
+	db := &DB{collections: make(map[string]*Collection)}
+	db.Collection("users").unique = []string{"board", "username"}
+	return db
+}
+
+// Install routes database.Collection to a new DB until the test ends.
+func Install(tb testing.TB) *DB {
+	db := New()
+	database.UseCollections(func(name string) database.Collections {
+		return db.Collection(name)
+	})
+	tb.Cleanup(func() { database.UseCollections(nil) })
+	return db
+}
+
+// Collection returns the named collection, creating it if needed.
+func (db *DB) Collection(name string) *Collection {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, ok := db.collections[name]
+	if !ok {
+		c = &Collection{}
+		db.collections[name] = c
+	}
+	return c
+}
+
+// Collection is an in-memory database.Collections. Documents are kept in
+// insertion order, which is also _id order for generated IDs.
+type Collection struct {
+	mu     sync.Mutex
+	docs   []bson.M
+	unique []string
+	errs   map[string][]error
+
+	// OnCall, if set, runs at the start of every method with its name,
+	// before the collection is read, so a test can interleave other work.
+	OnCall func(method string)
+	// FailWrite, if set, is asked about each document an insert or bulk
+	// write is about to write. Returning true fails that write with a
+	// write error, as a document validation failure would.
+	FailWrite func(doc bson.M) bool
+}
+
+var _ database.Collections = (*Collection)(nil)
+
+// Insert stores documents as they are, bypassing unique checks and hooks.
+// Any value that marshals to a BSON document will do, so tests can plant
+// documents the services would never write.
+func (c *Collection) Insert(docs ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, d := range docs {
+		doc := toDoc(d)
+		if _, ok := doc["_id"]; !ok {
+			doc["_id"] = primitive.NewObjectID()
+		}
+		c.docs = append(c.docs, doc)
+	}
+}
+
+// Docs returns copies of the documents matching filter, in insertion order.
+func (c *Collection) Docs(filter interface{}) []bson.M {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result []bson.M
+	for _, doc := range c.docs {
+		if matches(doc, toDoc(filter)) {
+			result = append(result, copyDoc(doc))
+		}
+	}
+	return result
+}
+
+// Len returns the number of stored documents.
+func (c *Collection) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.docs)
+}
+
+// FailNext makes the next call to method return err without touching the
+// collection. Queued errors are returned in order, one per call.
+func (c *Collection) FailNext(method string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.errs == nil {
+		c.errs = make(map[string][]error)
+	}
+	c.errs[method] = append(c.errs[method], err)
+}
+
+// begin runs the OnCall hook and pops a queued error for method. The caller
+// holds c.mu afterwards unless an error is returned.
+func (c *Collection) begin(method string) error {
+	if c.OnCall != nil {
+		c.OnCall(method)
+	}
+	c.mu.Lock()
+	if queued := c.errs[method]; len(queued) > 0 {
+		c.errs[method] = queued[1:]
+		c.mu.Unlock()
+		return queued[0]
+	}
+	return nil
+}
+
+func (c *Collection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	if err := c.begin("Find"); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	o := options.MergeFindOptions(opts...)
+	found := c.find(toDoc(filter), o.Sort)
+	skip, limit := 0, len(found)
+	if o.Skip != nil {
+		skip = min(int(*o.Skip), len(found))
+	}
+	if o.Limit != nil && *o.Limit > 0 {
+		limit = int(*o.Limit)
+	}
+	found = found[skip:min(skip+limit, len(found))]
+
+	docs := make([]interface{}, len(found))
+	for i, doc := range found {
+		docs[i] = copyDoc(doc)
+	}
+	return mongo.NewCursorFromDocuments(docs, nil, nil)
+}
+
+func (c *Collection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	if err := c.begin("FindOne"); err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+	defer c.mu.Unlock()
+
+	found := c.find(toDoc(filter), nil)
+	if len(found) == 0 {
+		return mongo.NewSingleResultFromDocument(bson.M{}, mongo.ErrNoDocuments, nil)
+	}
+	return mongo.NewSingleResultFromDocument(copyDoc(found[0]), nil, nil)
+}
+
+func (c *Collection) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	if err := c.begin("FindOneAndUpdate"); err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, err, nil)
+	}
+	defer c.mu.Unlock()
+
+	found := c.find(toDoc(filter), nil)
+	if len(found) == 0 {
+		return mongo.NewSingleResultFromDocument(bson.M{}, mongo.ErrNoDocuments, nil)
+	}
+	before := copyDoc(found[0])
+	after := applyUpdate(before, update)
+	if err := c.checkUnique(after, found[0]); err != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, mongo.WriteException{WriteErrors: []mongo.WriteError{*err}}, nil)
+	}
+	replace(found[0], after)
+
+	o := options.MergeFindOneAndUpdateOptions(opts...)
+	if o.ReturnDocument != nil && *o.ReturnDocument == options.After {
+		return mongo.NewSingleResultFromDocument(copyDoc(after), nil, nil)
+	}
+	return mongo.NewSingleResultFromDocument(before, nil, nil)
+}
+
+func (c *Collection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	if err := c.begin("CountDocuments"); err != nil {
+		return 0, err
+	}
+	defer c.mu.Unlock()
+
+	n := int64(len(c.find(toDoc(filter), nil)))
+	o := options.MergeCountOptions(opts...)
+	if o.Limit != nil && *o.Limit > 0 && n > *o.Limit {
+		n = *o.Limit
+	}
+	return n, nil
+}
+
+func (c *Collection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	if err := c.begin("InsertOne"); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	doc, we := c.insert(document, 0)
+	if we != nil {
+		return nil, mongo.WriteException{WriteErrors: []mongo.WriteError{*we}}
+	}
+	return &mongo.InsertOneResult{InsertedID: doc["_id"]}, nil
+}
+
+func (c *Collection) InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	if err := c.begin("InsertMany"); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	o := options.MergeInsertManyOptions(opts...)
+	ordered := o.Ordered == nil || *o.Ordered
+
+	result := &mongo.InsertManyResult{}
+	var errs []mongo.BulkWriteError
+	for i, d := range documents {
+		doc, we := c.insert(d, i)
+		if we != nil {
+			errs = append(errs, mongo.BulkWriteError{WriteError: *we})
+			if ordered {
+				break
+			}
+			continue
+		}
+		result.InsertedIDs = append(result.InsertedIDs, doc["_id"])
+	}
+	if errs != nil {
+		return result, mongo.BulkWriteException{WriteErrors: errs}
+	}
+	return result, nil
+}
+
+func (c *Collection) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	if err := c.begin("UpdateMany"); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	result := &mongo.UpdateResult{}
+	for _, doc := range c.find(toDoc(filter), nil) {
+		result.MatchedCount++
+		after := applyUpdate(doc, update)
+		if !equalDocs(doc, after) {
+			result.ModifiedCount++
+		}
+		replace(doc, after)
+	}
+	return result, nil
+}
+
+func (c *Collection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	if err := c.begin("DeleteMany"); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	f := toDoc(filter)
+	kept := c.docs[:0]
+	deleted := int64(0)
+	for _, doc := range c.docs {
+		if matches(doc, f) {
+			deleted++
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	c.docs = kept
+	return &mongo.DeleteResult{DeletedCount: deleted}, nil
+}
+
+func (c *Collection) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	if err := c.begin("BulkWrite"); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	o := options.MergeBulkWriteOptions(opts...)
+	ordered := o.Ordered == nil || *o.Ordered
+
+	result := &mongo.BulkWriteResult{}
+	var errs []mongo.BulkWriteError
+	for i, model := range models {
+		var we *mongo.WriteError
+		switch m := model.(type) {
+		case *mongo.UpdateOneModel:
+			we = c.updateOne(m, i, result)
+		case *mongo.InsertOneModel:
+			if _, we = c.insert(m.Document, i); we == nil {
+				result.InsertedCount++
+			}
+		default:
+			panic(fmt.Sprintf("dbtest: unsupported write model %T", model))
+		}
+		if we != nil {
+			errs = append(errs, mongo.BulkWriteError{WriteError: *we, Request: model})
+			if ordered {
+				break
+			}
+		}
+	}
+	if errs != nil {
+		return result, mongo.BulkWriteException{WriteErrors: errs}
+	}
+	return result, nil
+}
+
+func (c *Collection) updateOne(m *mongo.UpdateOneModel, index int, result *mongo.BulkWriteResult) *mongo.WriteError {
+	found := c.find(toDoc(m.Filter), nil)
+	if len(found) == 0 {
+		return nil
+	}
+	after := applyUpdate(found[0], m.Update)
+	if c.FailWrite != nil && c.FailWrite(after) {
+		return &mongo.WriteError{Index: index, Code: 121, Message: "Document failed validation"}
+	}
+	if we := c.checkUnique(after, found[0]); we != nil {
+		we.Index = index
+		return we
+	}
+	result.MatchedCount++
+	if !equalDocs(found[0], after) {
+		result.ModifiedCount++
+	}
+	replace(found[0], after)
+	return nil
+}
+
+// insert stores one document, generating its _id if missing. c.mu is held.
+func (c *Collection) insert(document interface{}, index int) (bson.M, *mongo.WriteError) {
+	doc := toDoc(document)
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	if c.FailWrite != nil && c.FailWrite(doc) {
+		return nil, &mongo.WriteError{Index: index, Code: 121, Message: "Document failed validation"}
+	}
+	for _, existing := range c.docs {
+		if equalValues(existing["_id"], doc["_id"]) {
+			return nil, &mongo.WriteError{Index: index, Code: duplicateKeyCode, Message: "E11000 duplicate key error: _id"}
+		}
+	}
+	if we := c.checkUnique(doc, nil); we != nil {
+		we.Index = index
+		return nil, we
+	}
+	c.docs = append(c.docs, doc)
+	return doc, nil
+}
+
+// checkUnique reports a duplicate key error if doc collides with a document
+// other than self on the unique fields. c.mu is held.
+func (c *Collection) checkUnique(doc, self bson.M) *mongo.WriteError {
+	if c.unique == nil {
+		return nil
+	}
+	for _, existing := range c.docs {
+		if sameDoc(existing, self) {
+			continue
+		}
+		same := true
+		for _, field := range c.unique {
+			if !equalValues(existing[field], doc[field]) {
+				same = false
+				break
+			}
+		}
+		if same {
+			return &mongo.WriteError{Code: duplicateKeyCode, Message: "E11000 duplicate key error: " + strings.Join(c.unique, ", ")}
+		}
+	}
+	return nil
+}
+
+// find returns the stored documents matching filter, sorted if asked. The
+// documents are the stored ones, not copies. c.mu is held.
+func (c *Collection) find(filter bson.M, sortBy interface{}) []bson.M {
+	var found []bson.M
+	for _, doc := range c.docs {
+		if matches(doc, filter) {
+			found = append(found, doc)
+		}
+	}
+	if sortBy != nil {
+		keys := toOrderedDoc(sortBy)
+		sort.SliceStable(found, func(i, j int) bool {
+			for _, key := range keys {
+				cmp, _ := compare(found[i][key.Key], found[j][key.Key])
+				if cmp == 0 {
+					continue
+				}
+				if n, _ := number(key.Value); n < 0 {
+					return cmp > 0
+				}
+				return cmp < 0
+			}
+			return false
+		})
+	}
+	return found
+}
+
+// matches reports whether doc satisfies filter.
+func matches(doc, filter bson.M) bool {
+	for key, want := range filter {
+		if strings.HasPrefix(key, "$") {
+			panic("dbtest: unsupported top-level operator " + key)
+		}
+		got, present := doc[key]
+		if ops, ok := operators(want); ok {
+			for op, arg := range ops {
+				if !matchOp(op, got, present, arg) {
+					return false
+				}
+			}
+			continue
+		}
+		if !matchEqual(got, present, want) {
+			return false
+		}
+	}
+	return true
+}
+
+// operators returns v as a map of query operators, if it is one.
+func operators(v interface{}) (bson.M, bool) {
+	doc, ok := asDoc(v)
+	if !ok || len(doc) == 0 {
+		return nil, false
+	}
+	for key := range doc {
+		if !strings.HasPrefix(key, "$") {
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// matchEqual is MongoDB equality, where null also matches a missing field.
+func matchEqual(got interface{}, present bool, want interface{}) bool {
+	if want == nil {
+		return !present || got == nil
+	}
+	return present && equalValues(got, want)
+}
+
+func matchOp(op string, got interface{}, present bool, arg interface{}) bool {
+	switch op {
+	case "$ne":
+		return !matchEqual(got, present, arg)
+	case "$in":
+		for _, v := range asArray(arg) {
+			if matchEqual(got, present, v) {
+				return true
+			}
+		}
+		return false
+	case "$exists":
+		want, _ := arg.(bool)
+		return present == want
+	case "$lt", "$lte", "$gt", "$gte":
+		if !present {
+			return false
+		}
+		cmp, ok := compare(got, arg)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "$lt":
+			return cmp < 0
+		case "$lte":
+			return cmp <= 0
+		case "$gt":
+			return cmp > 0
+		default:
+			return cmp >= 0
+		}
+	}
+	panic("dbtest: unsupported query operator " + op)
+}
+
+// applyUpdate returns a copy of doc with update applied. update is either
+// an operator document or an aggregation pipeline of $set stages and
+// single-field $unset stages.
+func applyUpdate(doc bson.M, update interface{}) bson.M {
+	result := copyDoc(doc)
+	if stages, ok := update.(bson.A); ok {
+		for _, stage := range stages {
+			for op, fields := range toDoc(stage) {
+				if op == "$unset" {
+					delete(result, fields.(string))
+					continue
+				}
+				if op != "$set" {
+					panic("dbtest: unsupported pipeline stage " + op)
+				}
+				set := bson.M{}
+				for field, expr := range toDoc(fields) {
+					set[field] = eval(result, expr)
+				}
+				for field, v := range set {
+					result[field] = v
+				}
+			}
+		}
+		return result
+	}
+
+	for op, fields := range toDoc(update) {
+		for field, v := range toDoc(fields) {
+			switch op {
+			case "$set":
+				result[field] = v
+			case "$unset":
+				delete(result, field)
+			case "$inc":
+				cur, _ := number(result[field])
+				inc, _ := number(v)
+				result[field] = numberValue(cur + inc)
+			default:
+				panic("dbtest: unsupported update operator " + op)
+			}
+		}
+	}
+	return result
+}
+
+// eval evaluates a pipeline expression against doc.
+func eval(doc bson.M, expr interface{}) interface{} {
+	if s, ok := expr.(string); ok && strings.HasPrefix(s, "$") {
+		return doc[s[1:]]
+	}
+	ops, ok := operators(expr)
+	if !ok {
+		return expr
+	}
+	for op, arg := range ops {
+		var values []float64
+		for _, a := range asArray(arg) {
+			n, _ := number(eval(doc, a))
+			values = append(values, n)
+		}
+		if len(values) == 0 {
+			panic("dbtest: " + op + " needs arguments")
+		}
+		result := values[0]
+		for _, v := range values[1:] {
+			switch op {
+			case "$add":
+				result += v
+			case "$min":
+				result = min(result, v)
+			case "$max":
+				result = max(result, v)
+			default:
+				panic("dbtest: unsupported expression " + op)
+			}
+		}
+		return numberValue(result)
+	}
+	return nil
+}
+
+// toDoc round-trips v through BSON, giving a document whose values have the
+// types MongoDB would hand back: int32/int64, primitive.DateTime and so on.
+func toDoc(v interface{}) bson.M {
+	if v == nil {
+		return bson.M{}
+	}
+	data, err := bson.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("dbtest: cannot marshal %T: %v", v, err))
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		panic(fmt.Sprintf("dbtest: cannot unmarshal %T: %v", v, err))
+	}
+	return doc
+}
+
+func toOrderedDoc(v interface{}) bson.D {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("dbtest: cannot marshal %T: %v", v, err))
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		panic(fmt.Sprintf("dbtest: cannot unmarshal %T: %v", v, err))
+	}
+	return doc
+}
+
+func asDoc(v interface{}) (bson.M, bool) {
+	switch d := v.(type) {
+	case bson.M:
+		return d, true
+	case bson.D:
+		return d.Map(), true
+	}
+	return nil, false
+}
+
+func asArray(v interface{}) []interface{} {
+	switch a := v.(type) {
+	case bson.A:
+		return a
+	case []interface{}:
+		return a
+	}
+	panic(fmt.Sprintf("dbtest: expected an array, got %T", v))
+}
+
+func copyDoc(doc bson.M) bson.M {
+	return toDoc(doc)
+}
+
+// replace overwrites the stored doc in place with the fields of next.
+func replace(doc, next bson.M) {
+	for k := range doc {
+		delete(doc, k)
+	}
+	for k, v := range next {
+		doc[k] = v
+	}
+}
+
+// sameDoc reports whether a and b are the same stored document.
+func sameDoc(a, b bson.M) bool {
+	return b != nil && equalValues(a["_id"], b["_id"])
+}
+
+func equalDocs(a, b bson.M) bool {
+	x, _ := bson.Marshal(a)
+	y, _ := bson.Marshal(b)
+	return bytes.Equal(x, y)
+}
+
+func equalValues(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if cmp, ok := compare(a, b); ok {
+		return cmp == 0
+	}
+	return false
+}
+
+// compare orders two values of comparable BSON types.
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		return strings.Compare(x, y), ok
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		if x == y {
+			return 0, true
+		}
+		if !x {
+			return -1, true
+		}
+		return 1, true
+	case primitive.ObjectID:
+		y, ok := b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:]), ok
+	case primitive.DateTime:
+		y, ok := b.(primitive.DateTime)
+		if !ok {
+			return 0, false
+		}
+		return compare(int64(x), int64(y))
+	}
+	return 0, false
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// numberValue stores whole results as integers, as the server does for
+// integer inputs.
+func numberValue(f float64) interface{} {
+	if f == float64(int64(f)) {
+		return int64(f)
+	}
+	return f
+}
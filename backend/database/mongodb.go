@@ -5,71 +5,183 @@ package database
 import (
 	"context"
 	"log"
+	"sync"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const databaseName = "matiks-leaderboard"
+
+// reconnectAfterFailures is how many consecutive failed health pings trigger
+// a fresh client.
+const reconnectAfterFailures = 3
+
 var (
+	// mu guards client and database, which the health monitor may replace.
+	mu       sync.RWMutex
 	client   *mongo.Client
 	database *mongo.Database
+	mongoURI string
 )
 
 // Connect establishes a connection to MongoDB.
 // Uses the provided URI with sensible timeout defaults.
 // Returns an error if connection fails.
 func Connect(ctx context.Context, uri string) error {
-	var err error
-
-	clientOptions := options.Client().
-		ApplyURI(uri).
-		SetConnectTimeout(30 * time.Second).
-		SetServerSelectionTimeout(30 * time.Second)
-
-	client, err = mongo.Connect(ctx, clientOptions)
+	c, err := dial(ctx, uri)
 	if err != nil {
 		return err
 	}
 
-	if err = client.Ping(ctx, nil); err != nil {
-		return err
-	}
-
-	database = client.Database("matiks-leaderboard")
+	mu.Lock()
+	client, database, mongoURI = c, c.Database(databaseName), uri
+	mu.Unlock()
 	log.Println("✅ MongoDB connected successfully")
 
-	// Create unique index on username to prevent duplicates
-	usersCollection := database.Collection("users")
+	// Usernames are unique per board. The legacy global username index is
+	// dropped so the same player can join several boards.
+	usersCollection := c.Database(databaseName).Collection("users")
+	usersCollection.Indexes().DropOne(ctx, "username_1")
 	indexModel := mongo.IndexModel{
-		Keys:    map[string]int{"username": 1},
+		Keys:    bson.D{{Key: "board", Value: 1}, {Key: "username", Value: 1}},
 		Options: options.Index().SetUnique(true),
 	}
 	if _, err := usersCollection.Indexes().CreateOne(ctx, indexModel); err != nil {
 		log.Printf("⚠️ Index creation warning (may already exist): %v", err)
 	} else {
-		log.Println("✅ Username unique index created")
+		log.Println("✅ Board/username unique index created")
 	}
 
 	return nil
 }
 
+// dial connects a new client and verifies it with a ping.
+func dial(ctx context.Context, uri string) (*mongo.Client, error) {
+	clientOptions := options.Client().
+		ApplyURI(uri).
+		SetConnectTimeout(30 * time.Second).
+		SetServerSelectionTimeout(30 * time.Second)
+
+	c, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Ping(ctx, nil); err != nil {
+		c.Disconnect(context.Background())
+		return nil, err
+	}
+	return c, nil
+}
+
 // Disconnect closes the MongoDB connection.
 // Should be called when the application shuts down.
 func Disconnect(ctx context.Context) {
-	if client != nil {
-		if err := client.Disconnect(ctx); err != nil {
+	mu.RLock()
+	c := client
+	mu.RUnlock()
+
+	if c != nil {
+		if err := c.Disconnect(ctx); err != nil {
 			log.Println("Error disconnecting from MongoDB:", err)
 		}
 	}
 }
 
-// Collection returns a MongoDB collection by name.
-func Collection(name string) *mongo.Collection {
+// Collection returns a MongoDB collection by name, or the override's
+// collection when one is installed with UseCollections.
+func Collection(name string) Collections {
+	if open := overridden(); open != nil {
+		return open(name)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
 	return database.Collection(name)
 }
 
 // DB returns the database instance.
 func DB() *mongo.Database {
+	mu.RLock()
+	defer mu.RUnlock()
 	return database
 }
+
+// Ping checks that MongoDB is reachable within the given timeout.
+func Ping(ctx context.Context, timeout time.Duration) error {
+	mu.RLock()
+	c := client
+	mu.RUnlock()
+
+	if c == nil {
+		return mongo.ErrClientDisconnected
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return c.Ping(ctx, nil)
+}
+
+// StartHealthMonitor pings MongoDB every interval until ctx is cancelled.
+// After several consecutive failures it dials a fresh client and swaps it
+// in, so a connection the driver can't recover doesn't need a restart.
+func StartHealthMonitor(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		failures := 0
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := Ping(ctx, interval)
+			if err == nil {
+				if failures > 0 {
+					log.Println("✅ MongoDB reachable again")
+				}
+				failures = 0
+				continue
+			}
+
+			failures++
+			log.Printf("⚠️ MongoDB health ping failed (%d in a row): %v", failures, err)
+			if failures >= reconnectAfterFailures {
+				if err := reconnect(ctx); err != nil {
+					log.Printf("⚠️ MongoDB reconnect failed: %v", err)
+					continue
+				}
+				failures = 0
+			}
+		}
+	}()
+}
+
+// reconnect replaces the client with a newly dialled one. Operations already
+// holding the old client's collections finish before it is disconnected.
+func reconnect(ctx context.Context) error {
+	mu.RLock()
+	uri := mongoURI
+	mu.RUnlock()
+
+	c, err := dial(ctx, uri)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	old := client
+	client, database = c, c.Database(databaseName)
+	mu.Unlock()
+	log.Println("🔄 MongoDB client re-established")
+
+	go func() {
+		// Give in-flight operations time to finish on the old client
+		time.Sleep(30 * time.Second)
+		old.Disconnect(context.Background())
+	}()
+	return nil
+}
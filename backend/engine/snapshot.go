@@ -2,23 +2,116 @@
 package engine
 
 import (
+	"math"
 	"sort"
 	"sync"
+	"time"
 
 	"matiks-leaderboard/cache"
 )
 
+// RankMode selects how tied scores are ranked.
+type RankMode string
+
+const (
+	// RankStandard is competition ranking: ties share a rank and the next
+	// rank skips ahead (1, 1, 3).
+	RankStandard RankMode = "standard"
+	// RankDense keeps ranks consecutive after ties (1, 1, 2).
+	RankDense RankMode = "dense"
+)
+
+// ParseRankMode converts a query value into a RankMode.
+// An empty value selects RankStandard.
+func ParseRankMode(v string) (RankMode, bool) {
+	switch RankMode(v) {
+	case "", RankStandard:
+		return RankStandard, true
+	case RankDense:
+		return RankDense, true
+	}
+	return "", false
+}
+
+// TieBreak orders users that share a score. It only affects position within
+// a tie group; tied users still share a rank.
+type TieBreak string
+
+const (
+	// TieBreakUsername orders ties alphabetically.
+	TieBreakUsername TieBreak = "username"
+	// TieBreakTime ranks whoever reached the score first higher.
+	TieBreakTime TieBreak = "time"
+	// TieBreakInsertion keeps ties in the order the cache first saw the
+	// users, so setting many users to one score doesn't reshuffle them.
+	TieBreakInsertion TieBreak = "insertion"
+)
+
+// ParseTieBreak converts a config value into a TieBreak.
+// An empty value selects TieBreakUsername.
+func ParseTieBreak(v string) (TieBreak, bool) {
+	switch TieBreak(v) {
+	case "", TieBreakUsername:
+		return TieBreakUsername, true
+	case TieBreakTime:
+		return TieBreakTime, true
+	case TieBreakInsertion:
+		return TieBreakInsertion, true
+	}
+	return "", false
+}
+
+// RankedEntry is one row of the snapshot. UserID and Username are assigned
+// from the cache, and Go strings are immutable headers over shared bytes, so
+// the snapshot does not duplicate name storage; each entry costs its fixed
+// 112 bytes on 64-bit platforms plus a slot in the current and previous rank
+// index. Entries are never mutated after Rebuild publishes them, which is
+// what makes the copies handed to readers, and the shared views, safe.
 type RankedEntry struct {
-	UserID   string
-	Username string
-	Score    int
-	Rank     int
+	UserID    string
+	Username  string
+	Country   string
+	Seq       uint64
+	Score     int
+	Rank      int
+	DenseRank int
+	// TiedCount is how many users share this entry's score, itself included.
+	TiedCount int
+	UpdatedAt time.Time
+}
+
+// RankFor returns the entry's rank under the given mode.
+func (e RankedEntry) RankFor(mode RankMode) int {
+	if mode == RankDense {
+		return e.DenseRank
+	}
+	return e.Rank
 }
 
 type Snapshot struct {
 	mu        sync.RWMutex
 	entries   []RankedEntry
 	rankIndex map[string]int
+	// prevRankIndex is the rank index the last Rebuild replaced, kept so
+	// Movers can diff against it. Nil until the second build.
+	prevRankIndex map[string]int
+	ascending     bool
+	tieBreak      TieBreak
+	built         bool
+	// regions ranks the users of each country among themselves, in
+	// leaderboard order. Users without a country are in no region.
+	regions map[string][]regionRank
+	// generation counts rebuilds; it changes exactly when entries do
+	generation uint64
+}
+
+// regionRank places a user within their country: pos is their index in
+// entries and the ranks count only users of the same country.
+type regionRank struct {
+	pos       int
+	rank      int
+	denseRank int
+	tiedCount int
 }
 
 var Global = &Snapshot{
@@ -26,78 +119,854 @@ var Global = &Snapshot{
 	rankIndex: make(map[string]int),
 }
 
+// SetAscending switches the snapshot to lower-is-better ordering.
+// Takes effect on the next Rebuild.
+func (s *Snapshot) SetAscending(ascending bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ascending = ascending
+}
+
+func (s *Snapshot) Ascending() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ascending
+}
+
+// SetTieBreak selects how tied scores are ordered.
+// Takes effect on the next Rebuild.
+func (s *Snapshot) SetTieBreak(tieBreak TieBreak) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tieBreak = tieBreak
+}
+
+func (s *Snapshot) TieBreak() TieBreak {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tieBreak
+}
+
+// better reports whether score a ranks ahead of score b.
+func (s *Snapshot) better(a, b int) bool {
+	if s.ascending {
+		return a < b
+	}
+	return a > b
+}
+
 func (s *Snapshot) Rebuild(data map[string]cache.Entry) {
-	entries := make([]RankedEntry, 0, len(data))
-	for id, e := range data {
+	s.RebuildFrom(func(yield func(id string, e cache.Entry)) error {
+		for id, e := range data {
+			yield(id, e)
+		}
+		return nil
+	})
+}
+
+// RebuildFrom rebuilds from an iterator such as cache.Store.Range, avoiding
+// an intermediate copy of the data. The previous size pre-sizes the entries.
+// If each fails, the current snapshot is kept and the error returned.
+func (s *Snapshot) RebuildFrom(each func(yield func(id string, e cache.Entry)) error) error {
+	s.mu.RLock()
+	ascending, tieBreak := s.ascending, s.tieBreak
+	sizeHint := len(s.entries)
+	s.mu.RUnlock()
+
+	entries := make([]RankedEntry, 0, sizeHint)
+	err := each(func(id string, e cache.Entry) {
 		entries = append(entries, RankedEntry{
-			UserID:   id,
-			Username: e.Username,
-			Score:    e.Score,
+			UserID:    id,
+			Username:  e.Username,
+			Country:   e.Country,
+			Seq:       e.Seq,
+			Score:     e.Score,
+			UpdatedAt: e.UpdatedAt,
 		})
+	})
+	if err != nil {
+		return err
 	}
 
 	sort.Slice(entries, func(i, j int) bool {
 		if entries[i].Score == entries[j].Score {
+			if tieBreak == TieBreakTime && !entries[i].UpdatedAt.Equal(entries[j].UpdatedAt) {
+				return earlier(entries[i].UpdatedAt, entries[j].UpdatedAt)
+			}
+			if tieBreak == TieBreakInsertion && entries[i].Seq != entries[j].Seq {
+				return entries[i].Seq < entries[j].Seq
+			}
 			return entries[i].Username < entries[j].Username
 		}
+		if ascending {
+			return entries[i].Score < entries[j].Score
+		}
 		return entries[i].Score > entries[j].Score
 	})
 
 	rankIndex := make(map[string]int, len(entries))
-	currentRank := 1
+	currentRank, denseRank := 1, 1
 	for i := range entries {
 		if i > 0 && entries[i].Score != entries[i-1].Score {
 			currentRank = i + 1
+			denseRank++
 		}
 		entries[i].Rank = currentRank
+		entries[i].DenseRank = denseRank
 		rankIndex[entries[i].UserID] = currentRank
 	}
+	for start := 0; start < len(entries); {
+		end := start + 1
+		for end < len(entries) && entries[end].Score == entries[start].Score {
+			end++
+		}
+		for i := start; i < end; i++ {
+			entries[i].TiedCount = end - start
+		}
+		start = end
+	}
+
+	regions := make(map[string][]regionRank)
+	for i := range entries {
+		if c := entries[i].Country; c != "" {
+			regions[c] = append(regions[c], regionRank{pos: i})
+		}
+	}
+	for _, members := range regions {
+		rankRegion(entries, members)
+	}
 
 	s.mu.Lock()
+	if s.built {
+		s.prevRankIndex = s.rankIndex
+	}
 	s.entries = entries
 	s.rankIndex = rankIndex
+	s.regions = regions
+	s.built = true
+	s.generation++
 	s.mu.Unlock()
+	return nil
 }
 
-func (s *Snapshot) GetLeaderboard(page, limit int) ([]RankedEntry, int) {
+// rankRegion ranks members, already in leaderboard order, the same way
+// RebuildFrom ranks the whole board.
+func rankRegion(entries []RankedEntry, members []regionRank) {
+	score := func(i int) int { return entries[members[i].pos].Score }
+
+	rank, denseRank := 1, 1
+	for i := range members {
+		if i > 0 && score(i) != score(i-1) {
+			rank = i + 1
+			denseRank++
+		}
+		members[i].rank = rank
+		members[i].denseRank = denseRank
+	}
+	for start := 0; start < len(members); {
+		end := start + 1
+		for end < len(members) && score(end) == score(start) {
+			end++
+		}
+		for i := start; i < end; i++ {
+			members[i].tiedCount = end - start
+		}
+		start = end
+	}
+}
+
+// earlier orders known timestamps oldest first, with unknown (zero) times last.
+func earlier(a, b time.Time) bool {
+	if a.IsZero() != b.IsZero() {
+		return b.IsZero()
+	}
+	return a.Before(b)
+}
+
+// GetLeaderboard returns one page of entries, the total count and the
+// generation they were read from. Pages start at 1; an out-of-range page or
+// a non-positive limit yields no entries.
+func (s *Snapshot) GetLeaderboard(page, limit int) ([]RankedEntry, int, uint64) {
+	view, total, generation := s.ViewLeaderboard(page, limit)
+	result := make([]RankedEntry, len(view))
+	copy(result, view)
+	return result, total, generation
+}
+
+// ViewLeaderboard is GetLeaderboard without the copy: the page shares the
+// snapshot's backing array. Rebuild publishes a new array rather than
+// writing to the old one, so the view stays consistent after the lock is
+// released, but callers must not modify its entries. Its capacity ends with
+// the page, so appending to it copies instead of overwriting the next entry.
+func (s *Snapshot) ViewLeaderboard(page, limit int) ([]RankedEntry, int, uint64) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	total := len(s.entries)
 	start := (page - 1) * limit
-	if start >= total {
-		return []RankedEntry{}, total
+	if page < 1 || limit < 1 || start >= total {
+		return []RankedEntry{}, total, s.generation
 	}
 	end := start + limit
 	if end > total {
 		end = total
 	}
+	return s.entries[start:end:end], total, s.generation
+}
 
-	result := make([]RankedEntry, end-start)
-	copy(result, s.entries[start:end])
-	return result, total
+// RegionalEntry is a leaderboard row within one country. The embedded
+// entry's Rank, DenseRank and TiedCount count only that country's users;
+// GlobalRank and GlobalDenseRank place the user on the whole board.
+type RegionalEntry struct {
+	RankedEntry
+	GlobalRank      int
+	GlobalDenseRank int
+}
+
+// GetRegion returns one page of the users from country, the number of users
+// in that country and the generation they were read from. Paging works as
+// in GetLeaderboard; an unknown country has no users.
+func (s *Snapshot) GetRegion(country string, page, limit int) ([]RegionalEntry, int, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	members := s.regions[country]
+	total := len(members)
+	start := (page - 1) * limit
+	if page < 1 || limit < 1 || start >= total {
+		return []RegionalEntry{}, total, s.generation
+	}
+	end := min(start+limit, total)
+
+	result := make([]RegionalEntry, 0, end-start)
+	for _, m := range members[start:end] {
+		e := s.entries[m.pos]
+		result = append(result, RegionalEntry{
+			RankedEntry:     e,
+			GlobalRank:      e.Rank,
+			GlobalDenseRank: e.DenseRank,
+		})
+		r := &result[len(result)-1]
+		r.Rank, r.DenseRank, r.TiedCount = m.rank, m.denseRank, m.tiedCount
+	}
+	return result, total, s.generation
 }
 
 func (s *Snapshot) GetTop(n int) []RankedEntry {
+	view := s.ViewTop(n)
+	result := make([]RankedEntry, len(view))
+	copy(result, view)
+	return result
+}
+
+// ViewTop is GetTop without the copy, under the same contract as
+// ViewLeaderboard: the entries are shared and must not be modified.
+func (s *Snapshot) ViewTop(n int) []RankedEntry {
+	entries, _ := s.ViewTopGeneration(n)
+	return entries
+}
+
+// ViewTopGeneration is ViewTop that also returns the generation the entries
+// belong to, read under the same lock.
+func (s *Snapshot) ViewTopGeneration(n int) ([]RankedEntry, uint64) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	if n > len(s.entries) {
 		n = len(s.entries)
 	}
+	if n < 0 {
+		n = 0
+	}
+	return s.entries[:n:n], s.generation
+}
+
+// GetBottom returns the last n entries, last place first. Ranks stay global.
+func (s *Snapshot) GetBottom(n int) []RankedEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if n > len(s.entries) {
+		n = len(s.entries)
+	}
+	if n < 0 {
+		n = 0
+	}
 	result := make([]RankedEntry, n)
-	copy(result, s.entries[:n])
+	for i := range result {
+		result[i] = s.entries[len(s.entries)-1-i]
+	}
+	return result
+}
+
+// GetRange returns the entries at positions from through to, 1-based and
+// inclusive, clipped to the snapshot. Positions count ties separately, so
+// ranks inside the window may repeat.
+func (s *Snapshot) GetRange(from, to int) []RankedEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if from < 1 {
+		from = 1
+	}
+	if to > len(s.entries) {
+		to = len(s.entries)
+	}
+	if from > to {
+		return []RankedEntry{}
+	}
+
+	result := make([]RankedEntry, to-from+1)
+	copy(result, s.entries[from-1:to])
+	return result
+}
+
+// GetAtRank returns every entry holding the given standard rank, tied
+// entries included. It returns nil when nobody holds it: the rank is beyond
+// the population, or was skipped because the users above it are tied.
+func (s *Snapshot) GetAtRank(rank int) []RankedEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if rank < 1 || rank > len(s.entries) || s.entries[rank-1].Rank != rank {
+		return nil
+	}
+	// Under standard ranking a tie group starts at the position of its rank
+	group := s.entries[rank-1 : rank-1+s.entries[rank-1].TiedCount]
+	result := make([]RankedEntry, len(group))
+	copy(result, group)
 	return result
 }
 
+// ScoreBand is one page of the entries whose scores fall in a range. Total
+// counts the whole band. Subtracting RankOffset (or DenseRankOffset) from an
+// entry's global rank gives its rank within the band.
+type ScoreBand struct {
+	Entries         []RankedEntry
+	Total           int
+	RankOffset      int
+	DenseRankOffset int
+	Generation      uint64
+}
+
+// GetByScoreRange returns a page of the entries scoring between minScore and maxScore
+// inclusive. The band is contiguous in the sorted entries, so its ends are
+// found by binary search and paging costs no more than GetLeaderboard.
+func (s *Snapshot) GetByScoreRange(minScore, maxScore, page, limit int) ScoreBand {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// countAbove(x) is the index of the first entry not ranked ahead of x
+	var lo, hi int
+	if s.ascending {
+		lo = s.countAbove(minScore)
+		hi = len(s.entries)
+		if maxScore < math.MaxInt {
+			hi = s.countAbove(maxScore + 1)
+		}
+	} else {
+		lo = s.countAbove(maxScore)
+		hi = len(s.entries)
+		if minScore > math.MinInt {
+			hi = s.countAbove(minScore - 1)
+		}
+	}
+
+	band := ScoreBand{Entries: []RankedEntry{}, Generation: s.generation}
+	if lo >= hi {
+		return band
+	}
+	band.Total = hi - lo
+	band.RankOffset = s.entries[lo].Rank - 1
+	band.DenseRankOffset = s.entries[lo].DenseRank - 1
+
+	start := lo + (page-1)*limit
+	if page < 1 || limit < 1 || start >= hi {
+		return band
+	}
+	end := min(start+limit, hi)
+	band.Entries = make([]RankedEntry, end-start)
+	copy(band.Entries, s.entries[start:end])
+	return band
+}
+
+// ScoreAt returns the score at a 1-based position in the sorted entries.
+// Matching it is enough to reach that position's rank, since ties share one.
+func (s *Snapshot) ScoreAt(pos int) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if pos < 1 || pos > len(s.entries) {
+		return 0, false
+	}
+	return s.entries[pos-1].Score, true
+}
+
 func (s *Snapshot) GetRank(userID string) int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return s.rankIndex[userID]
 }
 
+// GetRanks resolves the ranks of many users under a single read lock.
+// Unknown IDs map to 0.
+func (s *Snapshot) GetRanks(userIDs []string) map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ranks := make(map[string]int, len(userIDs))
+	for _, id := range userIDs {
+		ranks[id] = s.rankIndex[id]
+	}
+	return ranks
+}
+
+// GetRanksWithTies is GetRanks plus, for each ranked user, how many users
+// share the rank, both read from the same rebuild.
+func (s *Snapshot) GetRanksWithTies(userIDs []string) (ranks, tiedCounts map[string]int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ranks = make(map[string]int, len(userIDs))
+	tiedCounts = make(map[string]int, len(userIDs))
+	for _, id := range userIDs {
+		rank := s.rankIndex[id]
+		ranks[id] = rank
+		if rank > 0 {
+			tiedCounts[id] = s.entries[rank-1].TiedCount
+		}
+	}
+	return ranks, tiedCounts
+}
+
+// GetRankWithTies returns userID's rank and how many users share it, or
+// zeros if the user isn't ranked.
+func (s *Snapshot) GetRankWithTies(userID string) (rank, tiedCount int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rank = s.rankIndex[userID]
+	if rank == 0 {
+		return 0, 0
+	}
+	// The first entry of a tie group sits at position rank
+	return rank, s.entries[rank-1].TiedCount
+}
+
+// Generation returns how many times the snapshot has been rebuilt. Clients
+// can compare it between requests to tell whether anything changed.
+func (s *Snapshot) Generation() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.generation
+}
+
+// Built reports whether Rebuild has completed at least once.
+func (s *Snapshot) Built() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.built
+}
+
 func (s *Snapshot) Size() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return len(s.entries)
 }
+
+func (s *Snapshot) RankIndex() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]int, len(s.rankIndex))
+	for k, v := range s.rankIndex {
+		result[k] = v
+	}
+	return result
+}
+
+// RankForScore returns the rank a user with the given score would hold.
+func (s *Snapshot) RankForScore(score int) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.countAbove(score) + 1
+}
+
+// Percentile returns the percentage of ranked users with a worse score.
+func (s *Snapshot) Percentile(score int) float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	total := len(s.entries)
+	if total == 0 {
+		return 100
+	}
+	atOrAbove := sort.Search(total, func(i int) bool {
+		return s.better(score, s.entries[i].Score)
+	})
+	return float64(total-atOrAbove) / float64(total) * 100
+}
+
+// NearScore returns up to n entries on each side of where the score would be placed.
+func (s *Snapshot) NearScore(score, n int) []RankedEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pos := s.countAbove(score)
+	start := pos - n
+	if start < 0 {
+		start = 0
+	}
+	end := pos + n
+	if end > len(s.entries) {
+		end = len(s.entries)
+	}
+
+	result := make([]RankedEntry, end-start)
+	copy(result, s.entries[start:end])
+	return result
+}
+
+// countAbove returns how many entries have a strictly better score.
+// Caller must hold s.mu.
+func (s *Snapshot) countAbove(score int) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return !s.better(s.entries[i].Score, score)
+	})
+}
+
+// Bucket counts users whose score falls in [Min, Max].
+type Bucket struct {
+	Min   int
+	Max   int
+	Count int
+}
+
+// Distribution summarizes the score spread of a snapshot.
+type Distribution struct {
+	Count   int
+	Min     int
+	Max     int
+	Mean    float64
+	Median  float64
+	P90     int
+	P99     int
+	Buckets []Bucket
+}
+
+// Distribution computes summary statistics and a histogram with buckets of
+// bucketSize points, ordered from the lowest score upwards. Entries are
+// already sorted, so min/max are the ends and buckets take a single pass.
+func (s *Snapshot) Distribution(bucketSize int) Distribution {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := len(s.entries)
+	if n == 0 || bucketSize < 1 {
+		return Distribution{Buckets: []Bucket{}}
+	}
+
+	// ascending returns the i-th lowest score regardless of sort direction
+	ascending := func(i int) int {
+		if s.ascending {
+			return s.entries[i].Score
+		}
+		return s.entries[n-1-i].Score
+	}
+	percentile := func(p float64) int {
+		return ascending(int(p * float64(n-1)))
+	}
+
+	d := Distribution{
+		Count: n,
+		Min:   ascending(0),
+		Max:   ascending(n - 1),
+		P90:   percentile(0.90),
+		P99:   percentile(0.99),
+	}
+	if n%2 == 1 {
+		d.Median = float64(ascending(n / 2))
+	} else {
+		d.Median = float64(ascending(n/2-1)+ascending(n/2)) / 2
+	}
+
+	first := floorDiv(d.Min, bucketSize)
+	last := floorDiv(d.Max, bucketSize)
+	d.Buckets = make([]Bucket, last-first+1)
+	for i := range d.Buckets {
+		d.Buckets[i].Min = (first + i) * bucketSize
+		d.Buckets[i].Max = (first+i+1)*bucketSize - 1
+	}
+
+	var sum int64
+	for i := 0; i < n; i++ {
+		score := ascending(i)
+		sum += int64(score)
+		d.Buckets[floorDiv(score, bucketSize)-first].Count++
+	}
+	d.Mean = float64(sum) / float64(n)
+	return d
+}
+
+// CountByScore counts the users in each bucketSize-point bucket from `from`
+// through `to`, lowest first; the last bucket is cut short at to. Bucket
+// boundaries are binary searched, so the cost depends on the number of
+// buckets rather than on how many users the range holds.
+func (s *Snapshot) CountByScore(from, to, bucketSize int) []Bucket {
+	if from > to || bucketSize < 1 {
+		return []Bucket{}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	buckets := make([]Bucket, 0, (to-from)/bucketSize+1)
+	for lo := from; ; lo += bucketSize {
+		hi := min(lo+bucketSize-1, to)
+		buckets = append(buckets, Bucket{Min: lo, Max: hi, Count: s.countBetween(lo, hi)})
+		// Stopping here rather than testing lo <= to keeps lo from overflowing
+		if hi == to {
+			return buckets
+		}
+	}
+}
+
+// countBetween returns how many entries score from lo through hi.
+// Caller must hold s.mu.
+func (s *Snapshot) countBetween(lo, hi int) int {
+	if s.ascending {
+		return s.countAbove(hi+1) - s.countAbove(lo)
+	}
+	return s.countAbove(lo-1) - s.countAbove(hi)
+}
+
+// Summary holds headline figures for a snapshot. MostCommon is the score the
+// most users share, the better-ranked one on a tie, and TiedAtTop how many
+// users hold rank 1.
+type Summary struct {
+	Count           int
+	Highest         int
+	Lowest          int
+	MostCommon      int
+	MostCommonCount int
+	TiedAtTop       int
+}
+
+// Summary computes the headline figures in one pass over the sorted entries.
+// An empty snapshot yields all zeros.
+func (s *Snapshot) Summary() Summary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := len(s.entries)
+	if n == 0 {
+		return Summary{}
+	}
+
+	sum := Summary{
+		Count:   n,
+		Highest: max(s.entries[0].Score, s.entries[n-1].Score),
+		Lowest:  min(s.entries[0].Score, s.entries[n-1].Score),
+	}
+	for start := 0; start < n; {
+		// Equal scores are adjacent, so each run is one score's users
+		end := start + 1
+		for end < n && s.entries[end].Score == s.entries[start].Score {
+			end++
+		}
+		if start == 0 {
+			sum.TiedAtTop = end
+		}
+		if end-start > sum.MostCommonCount {
+			sum.MostCommon, sum.MostCommonCount = s.entries[start].Score, end-start
+		}
+		start = end
+	}
+	return sum
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// ProjectedRank returns the rank userID would hold with the given score,
+// without waiting for a rebuild. The user's own current entry is excluded
+// so moving down never counts them as ahead of themselves.
+func (s *Snapshot) ProjectedRank(userID string, score int) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	above := s.countAbove(score)
+	if rank := s.rankIndex[userID]; rank > 0 {
+		// Tied entries share a rank, so the entry at rank-1 has the user's score
+		if s.better(s.entries[rank-1].Score, score) {
+			above--
+		}
+	}
+	return above + 1
+}
+
+// Position returns the zero-based index of userID in the sorted entries.
+// Tied users share a rank but not a position, so this can differ from rank-1.
+func (s *Snapshot) Position(userID string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rank, ok := s.rankIndex[userID]
+	if !ok {
+		return 0, false
+	}
+	for i := rank - 1; i < len(s.entries) && s.entries[i].Rank == rank; i++ {
+		if s.entries[i].UserID == userID {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Crossing is a user who moved into or out of the top Threshold ranks in the
+// last rebuild. OldRank or NewRank is 0 where the user wasn't ranked.
+type Crossing struct {
+	UserID    string
+	Threshold int
+	Entered   bool
+	OldRank   int
+	NewRank   int
+}
+
+// Crossings compares the last rebuild with the one before it and returns,
+// for each threshold, the users who entered the top threshold ranks (best
+// rank first) and those who left it. Before a second rebuild there is
+// nothing to compare against and it returns nil.
+func (s *Snapshot) Crossings(thresholds []int) []Crossing {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.prevRankIndex == nil || len(thresholds) == 0 {
+		return nil
+	}
+	widest := 0
+	for _, n := range thresholds {
+		widest = max(widest, n)
+	}
+
+	var crossings []Crossing
+	for _, n := range thresholds {
+		// Entries are in rank order, so only the top of the board can have
+		// entered
+		for _, e := range s.entries {
+			if e.Rank > n {
+				break
+			}
+			if old, ok := s.prevRankIndex[e.UserID]; !ok || old > n {
+				crossings = append(crossings, Crossing{UserID: e.UserID, Threshold: n, Entered: true, OldRank: old, NewRank: e.Rank})
+			}
+		}
+	}
+
+	var left []Crossing
+	for id, old := range s.prevRankIndex {
+		if old > widest {
+			continue
+		}
+		now := s.rankIndex[id]
+		for _, n := range thresholds {
+			if old <= n && (now == 0 || now > n) {
+				left = append(left, Crossing{UserID: id, Threshold: n, OldRank: old, NewRank: now})
+			}
+		}
+	}
+	// Map order is random; best former rank first keeps the output stable
+	sort.Slice(left, func(i, j int) bool {
+		if left[i].Threshold != left[j].Threshold {
+			return left[i].Threshold < left[j].Threshold
+		}
+		if left[i].OldRank != left[j].OldRank {
+			return left[i].OldRank < left[j].OldRank
+		}
+		return left[i].UserID < left[j].UserID
+	})
+	return append(crossings, left...)
+}
+
+// Mover is a user whose rank changed in the last rebuild. Delta is positive
+// for a climb. OldRank is 0 for a user who was not ranked before.
+type Mover struct {
+	UserID   string
+	Username string
+	OldRank  int
+	NewRank  int
+	Delta    int
+}
+
+// Movers is the outcome of the last rebuild relative to the one before it.
+type Movers struct {
+	Climbers []Mover
+	Fallers  []Mover
+	// Entered lists users ranked for the first time, best rank first.
+	Entered []Mover
+	// Removed counts users who were ranked before and no longer are.
+	Removed    int
+	Generation uint64
+}
+
+// Movers returns up to limit of the biggest climbers, fallers and newcomers
+// since the previous rebuild, considering only users now ranked within
+// window (0 for everyone). Before a second rebuild there is nothing to
+// compare against and every list is empty.
+func (s *Snapshot) Movers(window, limit int) Movers {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := Movers{
+		Climbers:   []Mover{},
+		Fallers:    []Mover{},
+		Entered:    []Mover{},
+		Generation: s.generation,
+	}
+	if s.prevRankIndex == nil || limit < 1 {
+		return m
+	}
+
+	kept := 0
+	for _, e := range s.entries {
+		old, ok := s.prevRankIndex[e.UserID]
+		if ok {
+			kept++
+		}
+		if window > 0 && e.Rank > window {
+			continue
+		}
+
+		mover := Mover{UserID: e.UserID, Username: e.Username, OldRank: old, NewRank: e.Rank}
+		switch {
+		case !ok:
+			// Entries are in rank order, so the first few are the best
+			if len(m.Entered) < limit {
+				m.Entered = append(m.Entered, mover)
+			}
+		case old > e.Rank:
+			mover.Delta = old - e.Rank
+			m.Climbers = append(m.Climbers, mover)
+		case old < e.Rank:
+			mover.Delta = old - e.Rank
+			m.Fallers = append(m.Fallers, mover)
+		}
+	}
+	m.Removed = len(s.prevRankIndex) - kept
+
+	// Biggest moves first; ties keep the better current rank first
+	sort.SliceStable(m.Climbers, func(i, j int) bool { return m.Climbers[i].Delta > m.Climbers[j].Delta })
+	sort.SliceStable(m.Fallers, func(i, j int) bool { return m.Fallers[i].Delta < m.Fallers[j].Delta })
+	if len(m.Climbers) > limit {
+		m.Climbers = m.Climbers[:limit]
+	}
+	if len(m.Fallers) > limit {
+		m.Fallers = m.Fallers[:limit]
+	}
+	return m
+}
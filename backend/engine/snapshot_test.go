@@ -0,0 +1,313 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"matiks-leaderboard/cache"
+)
+
+// benchSnapshot returns a snapshot of n users with distinct scores.
+func benchSnapshot(b *testing.B, n int) *Snapshot {
+	b.Helper()
+	data := make(map[string]cache.Entry, n)
+	for i := 0; i < n; i++ {
+		id := strconv.Itoa(i)
+		data[id] = cache.Entry{Username: "user" + id, Score: i, Seq: uint64(i + 1)}
+	}
+	s := &Snapshot{}
+	s.Rebuild(data)
+	return s
+}
+
+func BenchmarkGetTop(b *testing.B) {
+	s := benchSnapshot(b, 100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.GetTop(1000)
+	}
+}
+
+func BenchmarkViewTop(b *testing.B) {
+	s := benchSnapshot(b, 100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.ViewTop(1000)
+	}
+}
+
+func BenchmarkGetLeaderboard(b *testing.B) {
+	s := benchSnapshot(b, 100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.GetLeaderboard(5, 100)
+	}
+}
+
+func BenchmarkViewLeaderboard(b *testing.B) {
+	s := benchSnapshot(b, 100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.ViewLeaderboard(5, 100)
+	}
+}
+
+// rankLookupIDs returns 1000 IDs spread over a snapshot of n users.
+func rankLookupIDs(n int) []string {
+	ids := make([]string, 1000)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i * n / len(ids))
+	}
+	return ids
+}
+
+func BenchmarkGetRanks(b *testing.B) {
+	s := benchSnapshot(b, 100000)
+	ids := rankLookupIDs(100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.GetRanks(ids)
+	}
+}
+
+func BenchmarkGetRankLoop(b *testing.B) {
+	s := benchSnapshot(b, 100000)
+	ids := rankLookupIDs(100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ranks := make(map[string]int, len(ids))
+		for _, id := range ids {
+			ranks[id] = s.GetRank(id)
+		}
+	}
+}
+
+// rows summarises entries as username:rank/denseRank/tiedCount.
+func rows(entries []RankedEntry) []string {
+	out := make([]string, len(entries))
+	for i, e := range entries {
+		out[i] = fmt.Sprintf("%s:%d/%d/%d", e.Username, e.Rank, e.DenseRank, e.TiedCount)
+	}
+	return out
+}
+
+func TestThreeWayTie(t *testing.T) {
+	s := &Snapshot{}
+	s.Rebuild(map[string]cache.Entry{
+		"1": {Username: "dave", Score: 500},
+		"2": {Username: "carol", Score: 400},
+		"3": {Username: "alice", Score: 400},
+		"4": {Username: "bob", Score: 400},
+		"5": {Username: "erin", Score: 300},
+	})
+
+	want := []string{"dave:1/1/1", "alice:2/2/3", "bob:2/2/3", "carol:2/2/3", "erin:5/3/1"}
+	if got := rows(s.GetTop(10)); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetTop = %v, want %v", got, want)
+	}
+	for _, id := range []string{"2", "3", "4"} {
+		if rank, tied := s.GetRankWithTies(id); rank != 2 || tied != 3 {
+			t.Errorf("GetRankWithTies(%s) = %d, %d, want 2, 3", id, rank, tied)
+		}
+	}
+	if got := rows(s.GetAtRank(2)); len(got) != 3 {
+		t.Errorf("GetAtRank(2) = %v, want the three tied users", got)
+	}
+	if got := s.GetAtRank(3); len(got) != 0 {
+		t.Errorf("GetAtRank(3) = %v, want none: the tie skips it", rows(got))
+	}
+}
+
+func TestSortOrderWithTies(t *testing.T) {
+	data := map[string]cache.Entry{
+		"1": {Username: "alice", Score: 300},
+		"2": {Username: "bob", Score: 500},
+		"3": {Username: "carol", Score: 300},
+		"4": {Username: "dave", Score: 100},
+	}
+	for _, tc := range []struct {
+		ascending   bool
+		top, bottom []string
+	}{
+		{false,
+			[]string{"bob:1/1/1", "alice:2/2/2", "carol:2/2/2", "dave:4/3/1"},
+			[]string{"dave:4/3/1", "carol:2/2/2", "alice:2/2/2", "bob:1/1/1"}},
+		{true,
+			[]string{"dave:1/1/1", "alice:2/2/2", "carol:2/2/2", "bob:4/3/1"},
+			[]string{"bob:4/3/1", "carol:2/2/2", "alice:2/2/2", "dave:1/1/1"}},
+	} {
+		s := &Snapshot{}
+		s.SetAscending(tc.ascending)
+		s.Rebuild(data)
+		if got := rows(s.GetTop(10)); !reflect.DeepEqual(got, tc.top) {
+			t.Errorf("ascending=%v: GetTop = %v, want %v", tc.ascending, got, tc.top)
+		}
+		if got := rows(s.GetBottom(10)); !reflect.DeepEqual(got, tc.bottom) {
+			t.Errorf("ascending=%v: GetBottom = %v, want %v", tc.ascending, got, tc.bottom)
+		}
+	}
+}
+
+func TestTieBreaks(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	// carol reached 400 first and joined first; alice last on both counts
+	data := map[string]cache.Entry{
+		"1": {Username: "alice", Score: 400, Seq: 3, UpdatedAt: start.Add(2 * time.Hour)},
+		"2": {Username: "bob", Score: 400, Seq: 2, UpdatedAt: start.Add(time.Hour)},
+		"3": {Username: "carol", Score: 400, Seq: 1, UpdatedAt: start},
+		"4": {Username: "dave", Score: 500, Seq: 4, UpdatedAt: start.Add(3 * time.Hour)},
+	}
+	for _, tc := range []struct {
+		tieBreak TieBreak
+		want     []string
+	}{
+		{TieBreakUsername, []string{"dave:1/1/1", "alice:2/2/3", "bob:2/2/3", "carol:2/2/3"}},
+		{TieBreakTime, []string{"dave:1/1/1", "carol:2/2/3", "bob:2/2/3", "alice:2/2/3"}},
+		{TieBreakInsertion, []string{"dave:1/1/1", "carol:2/2/3", "bob:2/2/3", "alice:2/2/3"}},
+	} {
+		s := &Snapshot{}
+		s.SetTieBreak(tc.tieBreak)
+		s.Rebuild(data)
+		if got := rows(s.GetTop(10)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: GetTop = %v, want %v", tc.tieBreak, got, tc.want)
+		}
+	}
+}
+
+func TestTimeTieBreakFallsBackToUsername(t *testing.T) {
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &Snapshot{}
+	s.SetTieBreak(TieBreakTime)
+	s.Rebuild(map[string]cache.Entry{
+		"1": {Username: "bob", Score: 400, UpdatedAt: at},
+		"2": {Username: "alice", Score: 400, UpdatedAt: at},
+		// A user without a timestamp sorts after those with one
+		"3": {Username: "aaron", Score: 400},
+	})
+	want := []string{"alice:1/1/3", "bob:1/1/3", "aaron:1/1/3"}
+	if got := rows(s.GetTop(10)); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetTop = %v, want %v", got, want)
+	}
+}
+
+func TestEmptySnapshotReads(t *testing.T) {
+	for name, s := range map[string]*Snapshot{
+		"never built": {},
+		"built empty": func() *Snapshot {
+			s := &Snapshot{}
+			s.Rebuild(map[string]cache.Entry{})
+			return s
+		}(),
+	} {
+		if entries, total, _ := s.GetLeaderboard(1, 10); len(entries) != 0 || total != 0 {
+			t.Errorf("%s: GetLeaderboard = %d entries of %d", name, len(entries), total)
+		}
+		if entries, total, _ := s.ViewLeaderboard(1, 10); len(entries) != 0 || total != 0 {
+			t.Errorf("%s: ViewLeaderboard = %d entries of %d", name, len(entries), total)
+		}
+		if got := s.GetTop(10); len(got) != 0 {
+			t.Errorf("%s: GetTop = %v", name, rows(got))
+		}
+		if got := s.GetBottom(10); len(got) != 0 {
+			t.Errorf("%s: GetBottom = %v", name, rows(got))
+		}
+		if got := s.GetRange(1, 10); len(got) != 0 {
+			t.Errorf("%s: GetRange = %v", name, rows(got))
+		}
+		if got := s.GetAtRank(1); len(got) != 0 {
+			t.Errorf("%s: GetAtRank = %v", name, rows(got))
+		}
+		if got := s.GetRank("1"); got != 0 {
+			t.Errorf("%s: GetRank = %d, want 0", name, got)
+		}
+		if got := s.RankForScore(500); got != 1 {
+			t.Errorf("%s: RankForScore = %d, want 1", name, got)
+		}
+		if got := s.Size(); got != 0 {
+			t.Errorf("%s: Size = %d", name, got)
+		}
+		if band := s.GetByScoreRange(100, 5000, 1, 10); len(band.Entries) != 0 {
+			t.Errorf("%s: GetByScoreRange = %v", name, rows(band.Entries))
+		}
+		if d := s.Distribution(100); d.Count != 0 || len(d.Buckets) != 0 {
+			t.Errorf("%s: Distribution = %+v", name, d)
+		}
+		if got := s.Summary(); got != (Summary{}) {
+			t.Errorf("%s: Summary = %+v, want zeros", name, got)
+		}
+		if got := s.Percentile(500); got != 100 {
+			t.Errorf("%s: Percentile = %v, want 100", name, got)
+		}
+		if got := s.NearScore(500, 3); len(got) != 0 {
+			t.Errorf("%s: NearScore = %v", name, rows(got))
+		}
+	}
+}
+
+func TestGetRanksMatchesGetRank(t *testing.T) {
+	s := &Snapshot{}
+	s.Rebuild(map[string]cache.Entry{
+		"1": {Username: "alice", Score: 300, Seq: 1},
+		"2": {Username: "bob", Score: 200, Seq: 2},
+		"3": {Username: "carol", Score: 300, Seq: 3},
+	})
+
+	ids := []string{"1", "2", "3", "missing"}
+	ranks := s.GetRanks(ids)
+	if len(ranks) != len(ids) {
+		t.Errorf("GetRanks returned %d ranks for %d IDs", len(ranks), len(ids))
+	}
+	for _, id := range ids {
+		if got, want := ranks[id], s.GetRank(id); got != want {
+			t.Errorf("GetRanks[%s] = %d, GetRank = %d", id, got, want)
+		}
+	}
+	if got := ranks["missing"]; got != 0 {
+		t.Errorf("unknown ID ranked %d, want 0", got)
+	}
+}
+
+func TestRankedEntrySharesNames(t *testing.T) {
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		if size := unsafe.Sizeof(RankedEntry{}); size != 112 {
+			t.Errorf("RankedEntry is %d bytes, the doc says 112", size)
+		}
+	}
+
+	// Names far longer than an entry, so copying them would dominate the
+	// heap a rebuild allocates
+	const users, nameLen = 2000, 4096
+	data := make(map[string]cache.Entry, users)
+	for i := 0; i < users; i++ {
+		id := strconv.Itoa(i)
+		data[id] = cache.Entry{Username: strings.Repeat("x", nameLen-len(id)) + id, Score: i, Seq: uint64(i + 1)}
+	}
+
+	s := &Snapshot{}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	s.Rebuild(data)
+	runtime.ReadMemStats(&after)
+
+	perUser := (after.TotalAlloc - before.TotalAlloc) / users
+	if perUser >= nameLen {
+		t.Errorf("rebuild allocated %d bytes per user, at least the %d-byte name", perUser, nameLen)
+	}
+	for _, e := range s.GetTop(users) {
+		if unsafe.StringData(e.Username) != unsafe.StringData(data[e.UserID].Username) {
+			t.Fatalf("entry %s holds a copy of its username", e.UserID)
+		}
+	}
+}
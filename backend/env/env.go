@@ -0,0 +1,40 @@
+// Package env reads numeric settings from environment variables. Every
+// helper falls back to its default when the variable is unset, malformed
+// or not positive, so a bad value can't zero out a limit.
+package env
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// PositiveInt returns the integer value of the named env var, or fallback
+// if it is unset, not an integer, or not greater than zero.
+func PositiveInt(name string, fallback int) int {
+	v, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || v <= 0 {
+		return fallback
+	}
+	return v
+}
+
+// PositiveFloat returns the positive numeric value of the named env var, or
+// fallback.
+func PositiveFloat(name string, fallback float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil || v <= 0 {
+		return fallback
+	}
+	return v
+}
+
+// PositiveDuration parses the named env var as a Go duration (e.g. "1h30m"),
+// returning fallback if it is unset, malformed, or not positive.
+func PositiveDuration(name string, fallback time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || v <= 0 {
+		return fallback
+	}
+	return v
+}
@@ -0,0 +1,35 @@
+package env
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFallbacks(t *testing.T) {
+	for _, tc := range []struct {
+		value        string
+		wantInt      int
+		wantFloat    float64
+		wantDuration time.Duration
+	}{
+		{"", 7, 7, 7 * time.Second},
+		{"0", 7, 7, 7 * time.Second},
+		{"-3", 7, 7, 7 * time.Second},
+		{"abc", 7, 7, 7 * time.Second},
+		{"12", 12, 12, 7 * time.Second},
+		{"2.5", 7, 2.5, 7 * time.Second},
+		{"90s", 7, 7, 90 * time.Second},
+		{"-1m", 7, 7, 7 * time.Second},
+	} {
+		t.Setenv("ENV_TEST_VALUE", tc.value)
+		if got := PositiveInt("ENV_TEST_VALUE", 7); got != tc.wantInt {
+			t.Errorf("PositiveInt(%q) = %d, want %d", tc.value, got, tc.wantInt)
+		}
+		if got := PositiveFloat("ENV_TEST_VALUE", 7); got != tc.wantFloat {
+			t.Errorf("PositiveFloat(%q) = %v, want %v", tc.value, got, tc.wantFloat)
+		}
+		if got := PositiveDuration("ENV_TEST_VALUE", 7*time.Second); got != tc.wantDuration {
+			t.Errorf("PositiveDuration(%q) = %v, want %v", tc.value, got, tc.wantDuration)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.3
+// source: proto/leaderboard.proto
+
+package leaderboardpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Leaderboard_GetRank_FullMethodName        = "/matiks.leaderboard.v1.Leaderboard/GetRank"
+	Leaderboard_GetLeaderboard_FullMethodName = "/matiks.leaderboard.v1.Leaderboard/GetLeaderboard"
+	Leaderboard_GetTop_FullMethodName         = "/matiks.leaderboard.v1.Leaderboard/GetTop"
+	Leaderboard_UpdateScore_FullMethodName    = "/matiks.leaderboard.v1.Leaderboard/UpdateScore"
+)
+
+// LeaderboardClient is the client API for Leaderboard service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LeaderboardClient interface {
+	// GetRank returns one user with their current rank.
+	GetRank(ctx context.Context, in *GetRankRequest, opts ...grpc.CallOption) (*User, error)
+	// GetLeaderboard returns one page of the leaderboard.
+	GetLeaderboard(ctx context.Context, in *GetLeaderboardRequest, opts ...grpc.CallOption) (*GetLeaderboardResponse, error)
+	// GetTop returns the best n users.
+	GetTop(ctx context.Context, in *GetTopRequest, opts ...grpc.CallOption) (*GetTopResponse, error)
+	// UpdateScore sets a user's score. Requires an x-api-key metadata entry
+	// when the server has API keys configured.
+	UpdateScore(ctx context.Context, in *UpdateScoreRequest, opts ...grpc.CallOption) (*UpdateScoreResponse, error)
+}
+
+type leaderboardClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLeaderboardClient(cc grpc.ClientConnInterface) LeaderboardClient {
+	return &leaderboardClient{cc}
+}
+
+func (c *leaderboardClient) GetRank(ctx context.Context, in *GetRankRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, Leaderboard_GetRank_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *leaderboardClient) GetLeaderboard(ctx context.Context, in *GetLeaderboardRequest, opts ...grpc.CallOption) (*GetLeaderboardResponse, error) {
+	out := new(GetLeaderboardResponse)
+	err := c.cc.Invoke(ctx, Leaderboard_GetLeaderboard_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *leaderboardClient) GetTop(ctx context.Context, in *GetTopRequest, opts ...grpc.CallOption) (*GetTopResponse, error) {
+	out := new(GetTopResponse)
+	err := c.cc.Invoke(ctx, Leaderboard_GetTop_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *leaderboardClient) UpdateScore(ctx context.Context, in *UpdateScoreRequest, opts ...grpc.CallOption) (*UpdateScoreResponse, error) {
+	out := new(UpdateScoreResponse)
+	err := c.cc.Invoke(ctx, Leaderboard_UpdateScore_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LeaderboardServer is the server API for Leaderboard service.
+// All implementations must embed UnimplementedLeaderboardServer
+// for forward compatibility
+type LeaderboardServer interface {
+	// GetRank returns one user with their current rank.
+	GetRank(context.Context, *GetRankRequest) (*User, error)
+	// GetLeaderboard returns one page of the leaderboard.
+	GetLeaderboard(context.Context, *GetLeaderboardRequest) (*GetLeaderboardResponse, error)
+	// GetTop returns the best n users.
+	GetTop(context.Context, *GetTopRequest) (*GetTopResponse, error)
+	// UpdateScore sets a user's score. Requires an x-api-key metadata entry
+	// when the server has API keys configured.
+	UpdateScore(context.Context, *UpdateScoreRequest) (*UpdateScoreResponse, error)
+	mustEmbedUnimplementedLeaderboardServer()
+}
+
+// UnimplementedLeaderboardServer must be embedded to have forward compatible implementations.
+type UnimplementedLeaderboardServer struct {
+}
+
+func (UnimplementedLeaderboardServer) GetRank(context.Context, *GetRankRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRank not implemented")
+}
+func (UnimplementedLeaderboardServer) GetLeaderboard(context.Context, *GetLeaderboardRequest) (*GetLeaderboardResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLeaderboard not implemented")
+}
+func (UnimplementedLeaderboardServer) GetTop(context.Context, *GetTopRequest) (*GetTopResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTop not implemented")
+}
+func (UnimplementedLeaderboardServer) UpdateScore(context.Context, *UpdateScoreRequest) (*UpdateScoreResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateScore not implemented")
+}
+func (UnimplementedLeaderboardServer) mustEmbedUnimplementedLeaderboardServer() {}
+
+// UnsafeLeaderboardServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LeaderboardServer will
+// result in compilation errors.
+type UnsafeLeaderboardServer interface {
+	mustEmbedUnimplementedLeaderboardServer()
+}
+
+func RegisterLeaderboardServer(s grpc.ServiceRegistrar, srv LeaderboardServer) {
+	s.RegisterService(&Leaderboard_ServiceDesc, srv)
+}
+
+func _Leaderboard_GetRank_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRankRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LeaderboardServer).GetRank(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Leaderboard_GetRank_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LeaderboardServer).GetRank(ctx, req.(*GetRankRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Leaderboard_GetLeaderboard_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLeaderboardRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LeaderboardServer).GetLeaderboard(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Leaderboard_GetLeaderboard_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LeaderboardServer).GetLeaderboard(ctx, req.(*GetLeaderboardRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Leaderboard_GetTop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTopRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LeaderboardServer).GetTop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Leaderboard_GetTop_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LeaderboardServer).GetTop(ctx, req.(*GetTopRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Leaderboard_UpdateScore_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateScoreRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LeaderboardServer).UpdateScore(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Leaderboard_UpdateScore_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LeaderboardServer).UpdateScore(ctx, req.(*UpdateScoreRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Leaderboard_ServiceDesc is the grpc.ServiceDesc for Leaderboard service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Leaderboard_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "matiks.leaderboard.v1.Leaderboard",
+	HandlerType: (*LeaderboardServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetRank",
+			Handler:    _Leaderboard_GetRank_Handler,
+		},
+		{
+			MethodName: "GetLeaderboard",
+			Handler:    _Leaderboard_GetLeaderboard_Handler,
+		},
+		{
+			MethodName: "GetTop",
+			Handler:    _Leaderboard_GetTop_Handler,
+		},
+		{
+			MethodName: "UpdateScore",
+			Handler:    _Leaderboard_UpdateScore_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/leaderboard.proto",
+}
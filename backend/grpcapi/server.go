@@ -0,0 +1,186 @@
+// Package grpcapi serves the ranking engine over gRPC for internal callers.
+// It wraps the same services.Board methods as the REST handlers, so both
+// transports always agree. A client uses the generated stubs:
+//
+//	conn, err := grpc.Dial("localhost:50051", grpc.WithTransportCredentials(insecure.NewCredentials()))
+//	client := leaderboardpb.NewLeaderboardClient(conn)
+//	user, err := client.GetRank(ctx, &leaderboardpb.GetRankRequest{UserId: id})
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"matiks-leaderboard/engine"
+	"matiks-leaderboard/grpcapi/leaderboardpb"
+	"matiks-leaderboard/middleware"
+	"matiks-leaderboard/models"
+	"matiks-leaderboard/services"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// APIKeyMetadata carries the caller's key on UpdateScore, like the
+// X-API-Key header does for REST writes.
+const APIKeyMetadata = "x-api-key"
+
+// Server implements leaderboardpb.LeaderboardServer.
+type Server struct {
+	leaderboardpb.UnimplementedLeaderboardServer
+
+	apiKeys      []string
+	maxPageLimit int
+}
+
+// NewServer returns a gRPC server with the Leaderboard service registered.
+// With apiKeys set, UpdateScore requires one of them; reads stay open.
+// Page sizes and top-N requests are capped at maxPageLimit.
+func NewServer(apiKeys []string, maxPageLimit int) *grpc.Server {
+	srv := grpc.NewServer()
+	leaderboardpb.RegisterLeaderboardServer(srv, &Server{apiKeys: apiKeys, maxPageLimit: maxPageLimit})
+	return srv
+}
+
+func (s *Server) GetRank(ctx context.Context, req *leaderboardpb.GetRankRequest) (*leaderboardpb.User, error) {
+	board, err := boardFor(req.Board)
+	if err != nil {
+		return nil, err
+	}
+
+	user := board.GetUserByID(req.UserId)
+	if user == nil {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	return toUser(*user), nil
+}
+
+func (s *Server) GetLeaderboard(ctx context.Context, req *leaderboardpb.GetLeaderboardRequest) (*leaderboardpb.GetLeaderboardResponse, error) {
+	board, err := boardFor(req.Board)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, ok := engine.ParseRankMode(req.RankMode)
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "rank_mode must be dense or standard")
+	}
+	page := max(int(req.Page), 1)
+	limit := int(req.Limit)
+	if limit < 1 || limit > s.maxPageLimit {
+		limit = min(50, s.maxPageLimit)
+	}
+
+	lb := board.GetLeaderboard(page, limit, mode)
+	resp := &leaderboardpb.GetLeaderboardResponse{
+		Entries:    make([]*leaderboardpb.User, len(lb.Entries)),
+		TotalUsers: int32(lb.TotalUsers),
+		TotalPages: int32(lb.TotalPages),
+		Page:       int32(lb.Page),
+		HasNext:    lb.HasNext,
+		Generation: lb.Generation,
+	}
+	for i, e := range lb.Entries {
+		resp.Entries[i] = toEntry(e)
+	}
+	return resp, nil
+}
+
+func (s *Server) GetTop(ctx context.Context, req *leaderboardpb.GetTopRequest) (*leaderboardpb.GetTopResponse, error) {
+	board, err := boardFor(req.Board)
+	if err != nil {
+		return nil, err
+	}
+
+	n := int(req.N)
+	if n < 1 {
+		n = 10
+	}
+	n = min(n, s.maxPageLimit)
+
+	entries := board.GetTopN(n)
+	resp := &leaderboardpb.GetTopResponse{Entries: make([]*leaderboardpb.User, len(entries))}
+	for i, e := range entries {
+		resp.Entries[i] = toEntry(e)
+	}
+	return resp, nil
+}
+
+func (s *Server) UpdateScore(ctx context.Context, req *leaderboardpb.UpdateScoreRequest) (*leaderboardpb.UpdateScoreResponse, error) {
+	if len(s.apiKeys) > 0 {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if keys := md.Get(APIKeyMetadata); len(keys) == 0 || !middleware.ValidAPIKey(keys[0], s.apiKeys) {
+			return nil, status.Error(codes.Unauthenticated, "missing or invalid API key")
+		}
+	}
+	if !services.Loaded() {
+		return nil, status.Error(codes.Unavailable, "serving cached data while the database loads, writes are unavailable")
+	}
+	if services.InMaintenance() {
+		return nil, status.Error(codes.Unavailable, "writes are paused for maintenance, reads are still available")
+	}
+
+	board, err := boardFor(req.Board)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := board.UpdateScore(ctx, req.UserId, int(req.Score))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &leaderboardpb.UpdateScoreResponse{
+		User:         toUser(result.UserResponse),
+		PreviousRank: int32(result.PreviousRank),
+		RankDelta:    int32(result.RankDelta),
+	}, nil
+}
+
+// boardFor resolves a board ID, with empty meaning the default board.
+func boardFor(id string) (*services.Board, error) {
+	if id == "" {
+		return services.DefaultBoard(), nil
+	}
+	board, ok := services.GetBoard(id)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "board not found")
+	}
+	return board, nil
+}
+
+// toStatus maps a service error to its gRPC code, mirroring the REST
+// handlers' status mapping.
+func toStatus(err error) error {
+	var validationErr *services.ValidationError
+	switch {
+	case errors.Is(err, services.ErrUsernameTaken), errors.Is(err, services.ErrSeasonExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.As(err, &validationErr):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, services.ErrUserNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case services.IsTimeout(err):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func toUser(u models.UserResponse) *leaderboardpb.User {
+	return &leaderboardpb.User{
+		UserId:   u.UserID,
+		Username: u.Username,
+		Rating:   int32(u.Rating),
+		Rank:     int32(u.Rank),
+	}
+}
+
+func toEntry(e models.LeaderboardEntry) *leaderboardpb.User {
+	return &leaderboardpb.User{
+		UserId:   e.UserID,
+		Username: e.Username,
+		Rating:   int32(e.Rating),
+		Rank:     int32(e.Rank),
+	}
+}
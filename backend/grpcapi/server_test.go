@@ -0,0 +1,145 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"matiks-leaderboard/database/dbtest"
+	"matiks-leaderboard/grpcapi/leaderboardpb"
+	"matiks-leaderboard/models"
+	"matiks-leaderboard/services"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// loadUser installs an in-memory database holding one user and loads it.
+func loadUser(t *testing.T, username string, score int) models.User {
+	t.Helper()
+	return loadUsers(t, map[string]int{username: score})[username]
+}
+
+// loadUsers installs an in-memory database holding a user per username,
+// with the given scores, and loads it.
+func loadUsers(t *testing.T, scores map[string]int) map[string]models.User {
+	t.Helper()
+	coll := dbtest.Install(t).Collection("users")
+	users := make(map[string]models.User, len(scores))
+	for name, score := range scores {
+		users[name] = models.User{ID: primitive.NewObjectID(), Username: name, Score: score}
+		coll.Insert(users[name])
+	}
+	if err := services.Initialize(context.Background()); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	return users
+}
+
+// dial serves NewServer over an in-memory listener and returns a client
+// connected to it.
+func dial(t *testing.T, apiKeys []string) leaderboardpb.LeaderboardClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := NewServer(apiKeys, 100)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return leaderboardpb.NewLeaderboardClient(conn)
+}
+
+func TestUpdateScoreDuringMaintenance(t *testing.T) {
+	user := loadUser(t, "alice", 300)
+	services.SetMaintenance(true)
+	t.Cleanup(func() { services.SetMaintenance(false) })
+
+	s := &Server{maxPageLimit: 100}
+	_, err := s.UpdateScore(context.Background(), &leaderboardpb.UpdateScoreRequest{UserId: user.ID.Hex(), Score: 400})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("UpdateScore in maintenance: err = %v, want Unavailable", err)
+	}
+	if got := services.DefaultBoard().GetUserByID(user.ID.Hex()); got == nil || got.Rating != 300 {
+		t.Errorf("user after rejected update = %+v, want rating 300", got)
+	}
+
+	services.SetMaintenance(false)
+	if _, err := s.UpdateScore(context.Background(), &leaderboardpb.UpdateScoreRequest{UserId: user.ID.Hex(), Score: 400}); err != nil {
+		t.Errorf("UpdateScore after maintenance: %v", err)
+	}
+}
+
+func TestGetRank(t *testing.T) {
+	users := loadUsers(t, map[string]int{"alice": 300, "bob": 400})
+	services.DefaultBoard().ForceRebuild()
+	client := dial(t, nil)
+	ctx := context.Background()
+
+	user, err := client.GetRank(ctx, &leaderboardpb.GetRankRequest{UserId: users["alice"].ID.Hex()})
+	if err != nil {
+		t.Fatalf("GetRank: %v", err)
+	}
+	if user.Username != "alice" || user.Rating != 300 || user.Rank != 2 {
+		t.Errorf("GetRank = %+v, want alice rated 300 at rank 2", user)
+	}
+
+	for _, tc := range []struct {
+		name string
+		req  *leaderboardpb.GetRankRequest
+		code codes.Code
+	}{
+		{"missing user", &leaderboardpb.GetRankRequest{UserId: primitive.NewObjectID().Hex()}, codes.NotFound},
+		{"unknown board", &leaderboardpb.GetRankRequest{UserId: users["alice"].ID.Hex(), Board: "nope"}, codes.NotFound},
+	} {
+		if _, err := client.GetRank(ctx, tc.req); status.Code(err) != tc.code {
+			t.Errorf("%s: err = %v, want %s", tc.name, err, tc.code)
+		}
+	}
+}
+
+func TestUpdateScore(t *testing.T) {
+	users := loadUsers(t, map[string]int{"alice": 300, "bob": 400})
+	services.DefaultBoard().ForceRebuild()
+	client := dial(t, []string{"secret"})
+	ctx := context.Background()
+	alice := users["alice"].ID.Hex()
+
+	if _, err := client.UpdateScore(ctx, &leaderboardpb.UpdateScoreRequest{UserId: alice, Score: 500}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("without a key: err = %v, want Unauthenticated", err)
+	}
+
+	authed := metadata.AppendToOutgoingContext(ctx, APIKeyMetadata, "secret")
+	resp, err := client.UpdateScore(authed, &leaderboardpb.UpdateScoreRequest{UserId: alice, Score: 500})
+	if err != nil {
+		t.Fatalf("UpdateScore: %v", err)
+	}
+	if resp.User.Rating != 500 || resp.User.Rank != 1 || resp.PreviousRank != 2 || resp.RankDelta != 1 {
+		t.Errorf("UpdateScore = %+v, want alice rated 500 moving from rank 2 to 1", resp)
+	}
+
+	for _, tc := range []struct {
+		name string
+		req  *leaderboardpb.UpdateScoreRequest
+		code codes.Code
+	}{
+		{"malformed ID", &leaderboardpb.UpdateScoreRequest{UserId: "not-an-id", Score: 500}, codes.InvalidArgument},
+		{"out-of-range score", &leaderboardpb.UpdateScoreRequest{UserId: alice, Score: 99}, codes.InvalidArgument},
+		{"missing user", &leaderboardpb.UpdateScoreRequest{UserId: primitive.NewObjectID().Hex(), Score: 500}, codes.NotFound},
+	} {
+		if _, err := client.UpdateScore(authed, tc.req); status.Code(err) != tc.code {
+			t.Errorf("%s: err = %v, want %s", tc.name, err, tc.code)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"matiks-leaderboard/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// Report fields by their JSON names, which is what clients send
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// bindJSON decodes the request body into obj. On failure it writes a 413 if
+// the body was over the size limit, or a 400 naming what was wrong, and
+// returns false.
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return true
+	}
+
+	status, code := http.StatusBadRequest, models.CodeValidation
+	if tooLarge(err) {
+		status, code = http.StatusRequestEntityTooLarge, models.CodeBodyTooLarge
+	}
+	fail(c, status, code, bindErrorMessage(err))
+	return false
+}
+
+// tooLarge reports whether err came from reading past the body size limit.
+func tooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// bindErrorMessage turns a binding error into a message a client can act on.
+func bindErrorMessage(err error) string {
+	var fieldErrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+
+	switch {
+	case tooLarge(err):
+		return "Request body too large"
+	case errors.Is(err, io.EOF):
+		return "Request body is empty"
+	case errors.As(err, &fieldErrs):
+		return fieldErrorMessage(fieldErrs[0])
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return "Request body must be " + jsonKind(typeErr.Type)
+		}
+		return typeErr.Field + " must be " + jsonKind(typeErr.Type)
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return "Invalid JSON: " + err.Error()
+	}
+	return "Invalid request body"
+}
+
+func fieldErrorMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return fe.Field() + " is required"
+	case "min", "gte":
+		return fe.Field() + " must be at least " + fe.Param()
+	case "max", "lte":
+		return fe.Field() + " must be at most " + fe.Param()
+	}
+	return fe.Field() + " is invalid"
+}
+
+// jsonKind names, with its article, the JSON type that decodes into t.
+func jsonKind(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"matiks-leaderboard/env"
+)
+
+const (
+	DefaultMaxPageLimit   = 100
+	DefaultMaxSearchLimit = 500
+	// DefaultPageLimit is the page size used when ?limit= is omitted.
+	DefaultPageLimit = 50
+)
+
+var (
+	// maxPageLimit caps leaderboard page sizes and top-N requests.
+	maxPageLimit = DefaultMaxPageLimit
+	// maxSearchLimit caps the number of search results per request.
+	maxSearchLimit = DefaultMaxSearchLimit
+)
+
+// LoadLimitConfig reads MAX_PAGE_LIMIT and MAX_SEARCH_LIMIT. Missing or
+// invalid values keep the defaults. Must be called before serving requests.
+func LoadLimitConfig() {
+	maxPageLimit = env.PositiveInt("MAX_PAGE_LIMIT", DefaultMaxPageLimit)
+	maxSearchLimit = env.PositiveInt("MAX_SEARCH_LIMIT", DefaultMaxSearchLimit)
+	slog.Info("request limits configured",
+		"max_page_limit", maxPageLimit,
+		"max_search_limit", maxSearchLimit,
+	)
+}
+
+// MaxPageLimit returns the configured page size ceiling, so other transports
+// can enforce the same cap.
+func MaxPageLimit() int {
+	return maxPageLimit
+}
+
+// defaultPageLimit is the page size for an omitted ?limit=: 50, or the
+// ceiling if lower.
+func defaultPageLimit() int {
+	return min(DefaultPageLimit, maxPageLimit)
+}
+
+// pageLimit validates a ?limit= value against the configured ceiling,
+// falling back to defaultPageLimit when out of range.
+func pageLimit(raw string) int {
+	limit, _ := strconv.Atoi(raw)
+	if limit < 1 || limit > maxPageLimit {
+		return defaultPageLimit()
+	}
+	return limit
+}
+
+// strictPagination reads ?page= and ?limit= without clamping, for clients
+// that ask to be told about bad values. An omitted value takes its default;
+// anything else out of range is an error.
+func strictPagination(pageRaw, limitRaw string) (page, limit int, err error) {
+	page, limit = 1, defaultPageLimit()
+	if pageRaw != "" {
+		if page, err = strconv.Atoi(pageRaw); err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("page must be an integer of at least 1, got %q", pageRaw)
+		}
+	}
+	if limitRaw != "" {
+		if limit, err = strconv.Atoi(limitRaw); err != nil || limit < 1 || limit > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit must be an integer between 1 and %d, got %q", maxPageLimit, limitRaw)
+		}
+	}
+	return page, limit, nil
+}
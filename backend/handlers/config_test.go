@@ -0,0 +1,84 @@
+package handlers
+
+import "testing"
+
+// setMaxPageLimit changes the page size ceiling for the rest of the test.
+func setMaxPageLimit(t *testing.T, limit int) {
+	t.Helper()
+	old := maxPageLimit
+	maxPageLimit = limit
+	t.Cleanup(func() { maxPageLimit = old })
+}
+
+func TestPageLimit(t *testing.T) {
+	for _, tc := range []struct {
+		max  int
+		raw  string
+		want int
+	}{
+		{100, "", 50},
+		{100, "20", 20},
+		{100, "100", 100},
+		{100, "101", 50},
+		{100, "0", 50},
+		{100, "-5", 50},
+		{100, "ten", 50},
+		{20, "", 20},
+		{20, "30", 20},
+		{20, "10", 10},
+	} {
+		setMaxPageLimit(t, tc.max)
+		if got := pageLimit(tc.raw); got != tc.want {
+			t.Errorf("pageLimit(%q) with ceiling %d = %d, want %d", tc.raw, tc.max, got, tc.want)
+		}
+	}
+}
+
+func TestStrictPagination(t *testing.T) {
+	for _, tc := range []struct {
+		max                 int
+		pageRaw, limitRaw   string
+		wantPage, wantLimit int
+		wantErr             bool
+	}{
+		{100, "", "", 1, 50, false},
+		{20, "", "", 1, 20, false},
+		{100, "3", "25", 3, 25, false},
+		{100, "1", "100", 1, 100, false},
+		{100, "0", "", 0, 0, true},
+		{100, "-1", "", 0, 0, true},
+		{100, "two", "", 0, 0, true},
+		{100, "", "0", 0, 0, true},
+		{100, "", "101", 0, 0, true},
+		{20, "", "30", 0, 0, true},
+		{100, "", "ten", 0, 0, true},
+	} {
+		setMaxPageLimit(t, tc.max)
+		page, limit, err := strictPagination(tc.pageRaw, tc.limitRaw)
+		if (err != nil) != tc.wantErr || page != tc.wantPage || limit != tc.wantLimit {
+			t.Errorf("strictPagination(%q, %q) with ceiling %d = %d, %d, %v; want %d, %d, error %v",
+				tc.pageRaw, tc.limitRaw, tc.max, page, limit, err, tc.wantPage, tc.wantLimit, tc.wantErr)
+		}
+	}
+}
+
+func TestLoadLimitConfig(t *testing.T) {
+	t.Cleanup(LoadLimitConfig)
+	for _, tc := range []struct {
+		page, search         string
+		wantPage, wantSearch int
+	}{
+		{"", "", DefaultMaxPageLimit, DefaultMaxSearchLimit},
+		{"20", "40", 20, 40},
+		{"0", "-1", DefaultMaxPageLimit, DefaultMaxSearchLimit},
+		{"lots", "1.5", DefaultMaxPageLimit, DefaultMaxSearchLimit},
+	} {
+		t.Setenv("MAX_PAGE_LIMIT", tc.page)
+		t.Setenv("MAX_SEARCH_LIMIT", tc.search)
+		LoadLimitConfig()
+		if maxPageLimit != tc.wantPage || maxSearchLimit != tc.wantSearch {
+			t.Errorf("MAX_PAGE_LIMIT=%q MAX_SEARCH_LIMIT=%q: limits %d, %d, want %d, %d",
+				tc.page, tc.search, maxPageLimit, maxSearchLimit, tc.wantPage, tc.wantSearch)
+		}
+	}
+}
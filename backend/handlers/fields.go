@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"matiks-leaderboard/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fieldSet holds the JSON field names a client asked for with ?fields=,
+// e.g. ?fields=username,rank. A nil set keeps every field.
+type fieldSet map[string]bool
+
+// parseFields reads ?fields=. Names are matched against the JSON keys of
+// the projected structs, so unknown ones simply never match.
+func parseFields(c *gin.Context) fieldSet {
+	raw := c.Query("fields")
+	if raw == "" {
+		return nil
+	}
+	fs := make(fieldSet)
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			fs[name] = true
+		}
+	}
+	if len(fs) == 0 {
+		return nil
+	}
+	return fs
+}
+
+// project returns v as a JSON object holding only the requested fields.
+// Dropped fields are absent from the output rather than zero-valued.
+func (fs fieldSet) project(v interface{}) interface{} {
+	if fs == nil {
+		return v
+	}
+	if obj, ok := structObject(v, func(name string) bool { return fs[name] }); ok {
+		return obj
+	}
+	return v
+}
+
+// projectEach projects every item of a list.
+func projectEach[T interface{}](fs fieldSet, items []T) interface{} {
+	if fs == nil {
+		return items
+	}
+	result := make([]interface{}, len(items))
+	for i, item := range items {
+		result[i] = fs.project(item)
+	}
+	return result
+}
+
+// projectPage projects the entries of a leaderboard page, keeping its
+// pagination metadata whole.
+func (fs fieldSet) projectPage(page *models.LeaderboardResponse) interface{} {
+	return projectList(fs, page, "entries", page.Entries)
+}
+
+// projectList projects the items v holds under key, keeping the rest of v
+// whole.
+func projectList[T interface{}](fs fieldSet, v interface{}, key string, items []T) interface{} {
+	if fs == nil {
+		return v
+	}
+	obj, ok := structObject(v, func(string) bool { return true })
+	if !ok {
+		return v
+	}
+	obj[key] = projectEach(fs, items)
+	return obj
+}
+
+// jsonField is a struct field as encoding/json names it. index locates it
+// through any embedded structs.
+type jsonField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// jsonFieldCache maps each projected struct type to its []jsonField.
+var jsonFieldCache sync.Map
+
+// structObject returns the JSON fields of the struct v, or a pointer to one,
+// for which keep is true, keyed by name. Each value still marshals itself,
+// so the object encodes as v would with the other fields left out. ok is
+// false if v is not a struct.
+func structObject(v interface{}, keep func(name string) bool) (obj map[string]interface{}, ok bool) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, false
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	obj = make(map[string]interface{})
+	for _, f := range jsonFields(rv.Type()) {
+		if !keep(f.name) {
+			continue
+		}
+		fv := rv.FieldByIndex(f.index)
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		obj[f.name] = fv.Interface()
+	}
+	return obj, true
+}
+
+// jsonFields lists the fields encoding/json would write for t, caching the
+// result. Fields of embedded structs without a tag are promoted unless a
+// shallower field already has their name.
+func jsonFields(t reflect.Type) []jsonField {
+	if cached, ok := jsonFieldCache.Load(t); ok {
+		return cached.([]jsonField)
+	}
+
+	var fields []jsonField
+	seen := make(map[string]bool)
+	var embedded [][]int
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			embedded = append(embedded, sf.Index)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		seen[name] = true
+		fields = append(fields, jsonField{name: name, index: sf.Index, omitEmpty: hasOption(opts, "omitempty")})
+	}
+	for _, index := range embedded {
+		for _, f := range jsonFields(t.FieldByIndex(index).Type) {
+			if seen[f.name] {
+				continue
+			}
+			seen[f.name] = true
+			f.index = append(append([]int{}, index...), f.index...)
+			fields = append(fields, f)
+		}
+	}
+
+	jsonFieldCache.Store(t, fields)
+	return fields
+}
+
+// isEmptyValue reports whether omitempty drops v, by encoding/json's rules.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+func hasOption(opts, option string) bool {
+	for _, o := range strings.Split(opts, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"matiks-leaderboard/models"
+)
+
+// roundTrip is the projection by way of JSON that structObject replaces:
+// encode v, decode it into a map and drop the unrequested keys.
+func roundTrip(t *testing.T, fs fieldSet, v interface{}) map[string]interface{} {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		t.Fatal(err)
+	}
+	for key := range obj {
+		if !fs[key] {
+			delete(obj, key)
+		}
+	}
+	return obj
+}
+
+// decoded is v encoded and decoded again, for comparing with roundTrip.
+func decoded(t *testing.T, v interface{}) map[string]interface{} {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestProjectOmitsUnrequestedFields(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	user := models.UserResponse{UserID: "1", Username: "alice", Rating: 300, CreatedAt: now, UpdatedAt: now}
+	fs := fieldSet{"username": true, "rank": true, "country": true, "unknown": true}
+
+	got := decoded(t, fs.project(user))
+	// rank and country are omitempty and zero, so only username is left
+	if want := map[string]interface{}{"username": "alice"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("project = %v, want %v", got, want)
+	}
+}
+
+func TestProjectMatchesJSON(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	user := models.UserResponse{
+		UserID: "1", Username: "alice", Rating: 300, Country: "US", Rank: 2, TiedCount: 1,
+		DisplayRank: "2", Match: &models.MatchRange{Start: 0, End: 3}, CreatedAt: now, UpdatedAt: now,
+	}
+	every := fieldSet{}
+	for key := range decoded(t, models.ScoreUpdateResponse{UserResponse: user}) {
+		every[key] = true
+	}
+
+	for _, tc := range []struct {
+		name string
+		fs   fieldSet
+		v    interface{}
+	}{
+		{"user", fieldSet{"userId": true, "match": true, "createdAt": true}, user},
+		{"user pointer", fieldSet{"rating": true, "displayRank": true}, &user},
+		{"empty user", every, models.UserResponse{}},
+		{"embedded", fieldSet{"username": true, "previousRank": true, "changed": true}, models.ScoreUpdateResponse{UserResponse: user, PreviousRank: 4, RankDelta: 2}},
+		{"every embedded field", every, &models.ScoreUpdateResponse{UserResponse: user, Changed: true}},
+		{"fuzzy match", fieldSet{"username": true, "distance": true}, models.FuzzyMatch{UserResponse: user, Distance: 1}},
+	} {
+		got := decoded(t, tc.fs.project(tc.v))
+		if want := roundTrip(t, tc.fs, tc.v); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: project = %v, want %v", tc.name, got, want)
+		}
+	}
+}
+
+func TestProjectListKeepsTheWrapper(t *testing.T) {
+	entries := []models.LeaderboardEntry{{UserID: "1", Username: "alice", Rating: 300, Rank: 1}}
+	page := &models.LeaderboardResponse{Entries: entries, Count: 1, TotalUsers: 1, TotalPages: 1, Page: 1, RankMode: "standard"}
+	fs := fieldSet{"username": true}
+
+	got := decoded(t, fs.projectPage(page))
+	want := decoded(t, page)
+	want["entries"] = []interface{}{map[string]interface{}{"username": "alice"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("projectPage = %v, want %v", got, want)
+	}
+}
@@ -2,175 +2,1229 @@
 package handlers
 
 import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"path"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"matiks-leaderboard/database"
+	"matiks-leaderboard/engine"
+	"matiks-leaderboard/models"
 	"matiks-leaderboard/services"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// serviceError maps a service error to its HTTP status and error code.
+// Anything unrecognised is a 500.
+func serviceError(err error) (int, models.ErrorCode) {
+	switch {
+	case err == services.ErrUsernameTaken:
+		return http.StatusConflict, models.CodeUsernameTaken
+	case err == services.ErrSeasonExists:
+		return http.StatusConflict, models.CodeSeasonExists
+	case err == services.ErrUserNotFound:
+		return http.StatusNotFound, models.CodeUserNotFound
+	case services.IsTimeout(err):
+		return http.StatusGatewayTimeout, models.CodeTimeout
+	case tooLarge(err):
+		return http.StatusRequestEntityTooLarge, models.CodeBodyTooLarge
+	}
+	if _, ok := err.(*services.ValidationError); ok {
+		return http.StatusBadRequest, models.CodeValidation
+	}
+	return http.StatusInternalServerError, models.CodeInternal
+}
+
+// fail writes the error envelope with the given status and code.
+func fail(c *gin.Context, status int, code models.ErrorCode, message string) {
+	c.JSON(status, models.ErrorBody(code, message))
+}
+
+// badRequest rejects invalid input with a 400.
+func badRequest(c *gin.Context, message string) {
+	fail(c, http.StatusBadRequest, models.CodeValidation, message)
+}
+
+// failErr writes the error envelope for a service error.
+func failErr(c *gin.Context, err error) {
+	status, code := serviceError(err)
+	fail(c, status, code, err.Error())
+}
+
+// boardFrom resolves the :board path param, falling back to the default
+// board for un-prefixed routes. Writes a 404 and returns nil if unknown.
+func boardFrom(c *gin.Context) *services.Board {
+	id := c.Param("board")
+	if id == "" {
+		return services.DefaultBoard()
+	}
+
+	board, ok := services.GetBoard(id)
+	if !ok {
+		fail(c, http.StatusNotFound, models.CodeBoardNotFound, "Board not found")
+		return nil
+	}
+	return board
+}
+
+// userIDFrom returns the :id path param. Writes a 400 and returns false if
+// it isn't an ObjectID hex, so a malformed ID never reaches the service.
+func userIDFrom(c *gin.Context) (string, bool) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		badRequest(c, "id must be a 24-character hex user ID")
+		return "", false
+	}
+	return id, true
+}
+
+// countFrom parses the :n path param. Writes a 400 and returns false if it
+// isn't an integer; range checks are left to the caller.
+func countFrom(c *gin.Context) (int, bool) {
+	n, err := strconv.Atoi(c.Param("n"))
+	if err != nil {
+		badRequest(c, "n must be an integer")
+		return 0, false
+	}
+	return n, true
+}
+
+// healthPingTimeout keeps /health cheap enough for frequent load balancer polls.
+const healthPingTimeout = 2 * time.Second
+
+func Health(c *gin.Context) {
+	status, code, dbStatus := "ok", http.StatusOK, "up"
+	if err := database.Ping(c.Request.Context(), healthPingTimeout); err != nil {
+		status, code, dbStatus = "degraded", http.StatusServiceUnavailable, "down"
+	}
+
+	c.JSON(code, models.HealthStatus{
+		Status:    status,
+		Database:  dbStatus,
+		Timestamp: time.Now().Format(time.RFC3339),
+	})
+}
+
+func Ready(c *gin.Context) {
+	dbStatus := "up"
+	if err := database.Ping(c.Request.Context(), healthPingTimeout); err != nil {
+		dbStatus = "down"
+	}
+	snapshotBuilt := services.Ready()
+
+	ready := dbStatus == "up" && snapshotBuilt
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+
+	c.JSON(code, models.Readiness{
+		Ready:         ready,
+		Database:      dbStatus,
+		SnapshotBuilt: snapshotBuilt,
+	})
+}
+
+// GetLeaderboard returns one page of the leaderboard. ?minScore= and
+// ?maxScore= narrow it to a score band, either end optional; ranks stay
+// global unless ?bandRanks=true. ?country= instead ranks only that country's
+// users, each entry keeping its globalRank. ?fields= trims the entries to
+// the named fields. Out-of-range page and limit values are clamped, or
+// rejected with a 400 under ?strict=true.
 func GetLeaderboard(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	var page, limit int
+	if c.Query("strict") == "true" {
+		var err error
+		if page, limit, err = strictPagination(c.Query("page"), c.Query("limit")); err != nil {
+			badRequest(c, err.Error())
+			return
+		}
+	} else {
+		page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+		limit = pageLimit(c.Query("limit"))
+		if page < 1 {
+			page = 1
+		}
+	}
+
+	mode, ok := engine.ParseRankMode(c.Query("rankMode"))
+	if !ok {
+		badRequest(c, "rankMode must be dense or standard")
+		return
+	}
+
+	fields := parseFields(c)
+
+	if country := c.Query("country"); country != "" {
+		response, err := board.GetRegionLeaderboard(country, page, limit, mode)
+		if err != nil {
+			failErr(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data":    fields.projectPage(response),
+		})
+		return
+	}
+
+	if c.Query("minScore") != "" || c.Query("maxScore") != "" {
+		minScore, minErr := strconv.Atoi(c.DefaultQuery("minScore", strconv.Itoa(math.MinInt)))
+		maxScore, maxErr := strconv.Atoi(c.DefaultQuery("maxScore", strconv.Itoa(math.MaxInt)))
+		if minErr != nil || maxErr != nil || minScore > maxScore {
+			badRequest(c, "minScore and maxScore must be integers with minScore <= maxScore")
+			return
+		}
+
+		response := board.GetLeaderboardBand(page, limit, mode, minScore, maxScore, c.Query("bandRanks") == "true")
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data":    fields.projectPage(response),
+		})
+		return
+	}
+
+	response := board.GetLeaderboard(page, limit, mode)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    fields.projectPage(response),
+	})
+}
+
+// exportChunkSize is the number of entries converted and written per
+// streamed chunk, so an export never holds the whole board's rows at once.
+const exportChunkSize = 1000
+
+func ExportLeaderboard(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		badRequest(c, "format must be csv or json")
+		return
+	}
+
+	filename := fmt.Sprintf("leaderboard-%s-%s.%s", board.ID, time.Now().Format("20060102-150405"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv")
+	} else {
+		c.Header("Content-Type", "application/json")
+	}
+
+	// Every chunk comes from the same snapshot, so a rebuild mid-export
+	// can't repeat or skip rows
+	export := board.Export()
+	first := true
+	c.Stream(func(w io.Writer) bool {
+		chunk, more := export.Next(exportChunkSize)
+
+		if format == "csv" {
+			cw := csv.NewWriter(w)
+			if first {
+				cw.Write([]string{"rank", "userId", "username", "score"})
+			}
+			for _, e := range chunk {
+				cw.Write([]string{strconv.Itoa(e.Rank), e.UserID, e.Username, strconv.Itoa(e.Rating)})
+			}
+			cw.Flush()
+		} else {
+			if first {
+				io.WriteString(w, "[")
+			}
+			for i, e := range chunk {
+				if !first || i > 0 {
+					io.WriteString(w, ",")
+				}
+				data, _ := json.Marshal(e)
+				w.Write(data)
+			}
+			if !more {
+				io.WriteString(w, "]")
+			}
+		}
+
+		first = false
+		return more
+	})
+}
+
+func GetTopN(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	n, ok := countFrom(c)
+	if !ok {
+		return
+	}
+	if n < 1 {
+		n = 10
+	}
+	if n > maxPageLimit {
+		n = maxPageLimit
+	}
+
+	fields := parseFields(c)
+	if fields == nil {
+		if rows, count, ok := board.CachedTopN(n); ok {
+			writeCachedTopN(c, rows, count)
+			return
+		}
+	}
+
+	entries := board.GetTopN(n)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    projectList(fields, models.EntryList{Entries: entries, Count: len(entries)}, "entries", entries),
+	})
+}
+
+// writeCachedTopN writes the same body c.JSON would for GetTopN, straight
+// from the board's encoded top entries.
+func writeCachedTopN(c *gin.Context, rows []byte, count int) {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+	c.Writer.WriteString(`{"data":{"entries":[`)
+	c.Writer.Write(rows)
+	c.Writer.WriteString(`],"count":` + strconv.Itoa(count) + `},"success":true}`)
+}
+
+// GetBottomN returns the n lowest-ranked users, last place first.
+func GetBottomN(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	n, ok := countFrom(c)
+	if !ok {
+		return
+	}
+	if n < 1 {
+		n = 10
+	}
+	if n > maxPageLimit {
+		n = maxPageLimit
+	}
+
+	entries := board.GetBottomN(n)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    projectList(parseFields(c), models.EntryList{Entries: entries, Count: len(entries)}, "entries", entries),
+	})
+}
+
+// GetAtRank returns the users holding rank :rank, more than one when tied.
+// A rank nobody holds, past the last user or skipped by a tie, is a 404.
+func GetAtRank(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	rank, err := strconv.Atoi(c.Param("rank"))
+	if err != nil || rank < 1 {
+		badRequest(c, "rank must be a positive integer")
+		return
+	}
+
+	entries := board.GetAtRank(rank)
+	if entries == nil {
+		fail(c, http.StatusNotFound, models.CodeRankNotFound, "No user holds rank "+strconv.Itoa(rank))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    projectList(parseFields(c), models.RankEntries{Entries: entries, Count: len(entries), Rank: rank}, "entries", entries),
+	})
+}
+
+// GetMovers returns who climbed and fell the most in the last rebuild.
+// ?window=top10 restricts it to users now in the top 10; all (the default)
+// covers everyone.
+func GetMovers(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	window, ok := parseMoversWindow(c.Query("window"))
+	if !ok {
+		badRequest(c, "window must be all or topN, e.g. top10")
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit < 1 || limit > maxPageLimit {
+		limit = min(10, maxPageLimit)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    board.GetMovers(window, limit),
+	})
+}
+
+// parseMoversWindow converts all or topN into a rank cutoff, 0 meaning none.
+func parseMoversWindow(v string) (int, bool) {
+	if v == "" || v == "all" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(v, "top"))
+	if !strings.HasPrefix(v, "top") || err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+// maxRangeWindow caps how many rows a single range request may return.
+const maxRangeWindow = 1000
+
+// GetRange returns leaderboard positions ?from= through ?to=, inclusive.
+func GetRange(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	from, fromErr := strconv.Atoi(c.Query("from"))
+	to, toErr := strconv.Atoi(c.Query("to"))
+	if fromErr != nil || toErr != nil || from < 1 || from > to {
+		badRequest(c, "from and to must be positive integers with from <= to")
+		return
+	}
+	if to-from+1 > maxRangeWindow {
+		badRequest(c, "range may span at most "+strconv.Itoa(maxRangeWindow)+" positions")
+		return
+	}
+
+	mode, ok := engine.ParseRankMode(c.Query("rankMode"))
+	if !ok {
+		badRequest(c, "rankMode must be dense or standard")
+		return
+	}
+
+	entries, total := board.GetRange(from, to, mode)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": projectList(parseFields(c), models.RankRange{
+			Entries:    entries,
+			Count:      len(entries),
+			From:       from,
+			To:         to,
+			TotalUsers: total,
+			RankMode:   string(mode),
+		}, "entries", entries),
+	})
+}
+
+// GetRankThreshold answers "how many points to reach rank N?" via
+// ?rank=N and an optional ?userId= to compare against.
+func GetRankThreshold(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	rank, err := strconv.Atoi(c.Query("rank"))
+	if err != nil {
+		badRequest(c, "rank must be a positive integer")
+		return
+	}
+
+	threshold, err := board.RankThreshold(rank, c.Query("userId"))
+	if err != nil {
+		failErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    threshold,
+	})
+}
+
+func PreviewRank(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	score, err := strconv.Atoi(c.Query("score"))
+	if err != nil {
+		badRequest(c, "score must be an integer")
+		return
+	}
+
+	neighbors, _ := strconv.Atoi(c.DefaultQuery("neighbors", "5"))
+	if neighbors < 0 {
+		neighbors = 5
+	}
+	if neighbors > 25 {
+		neighbors = 25
+	}
+
+	preview, err := board.PreviewRank(score, neighbors)
+	if err != nil {
+		failErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    preview,
+	})
+}
+
+func SearchUsers(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	prefix := c.Query("prefix")
+	if prefix == "" {
+		prefix = c.Query("username")
+	}
+	if prefix == "" {
+		badRequest(c, "prefix is required")
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit < 1 {
+		limit = min(100, maxSearchLimit)
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	if c.Query("fuzzy") == "true" {
+		searchFuzzy(c, board, prefix, limit)
+		return
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		badRequest(c, "offset must be a non-negative integer")
+		return
+	}
+
+	users, total := board.SearchByPrefix(prefix, offset, limit)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": projectList(parseFields(c), models.UserSearchResult{
+			Users:   users,
+			Count:   len(users),
+			Total:   total,
+			Offset:  offset,
+			HasNext: offset+len(users) < total,
+		}, "users", users),
+	})
+}
+
+const (
+	defaultFuzzyDistance = 2
+	maxFuzzyDistance     = 3
+	maxFuzzyQueryLength  = 32
+)
+
+// searchFuzzy handles /users/search?fuzzy=true. Every username is compared
+// against the query, so the cost grows with users × query length × name
+// length; the query length and edit distance are capped to keep it bounded.
+func searchFuzzy(c *gin.Context, board *services.Board, query string, limit int) {
+	if utf8.RuneCountInString(query) > maxFuzzyQueryLength {
+		badRequest(c, "fuzzy query may be at most "+strconv.Itoa(maxFuzzyQueryLength)+" characters")
+		return
+	}
+
+	distance, err := strconv.Atoi(c.DefaultQuery("distance", strconv.Itoa(defaultFuzzyDistance)))
+	if err != nil || distance < 0 || distance > maxFuzzyDistance {
+		badRequest(c, "distance must be between 0 and "+strconv.Itoa(maxFuzzyDistance))
+		return
+	}
+
+	users := board.SearchFuzzy(query, distance, limit)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    projectList(parseFields(c), models.FuzzySearchResult{Users: users, Count: len(users)}, "users", users),
+	})
+}
+
+func GetUserByID(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	userID, ok := userIDFrom(c)
+	if !ok {
+		return
+	}
+
+	user := board.GetUserByID(userID)
+	if user == nil {
+		fail(c, http.StatusNotFound, models.CodeUserNotFound, "User not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    parseFields(c).project(user),
+	})
+}
+
+func GetUserByUsername(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	user := board.GetUserByUsername(c.Param("username"))
+	if user == nil {
+		fail(c, http.StatusNotFound, models.CodeUserNotFound, "User not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    parseFields(c).project(user),
+	})
+}
+
+func GetUserPage(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	limit := pageLimit(c.Query("limit"))
+
+	userID, ok := userIDFrom(c)
+	if !ok {
+		return
+	}
+	page := board.GetUserPage(userID, limit)
+	if page == nil {
+		fail(c, http.StatusNotFound, models.CodeUserNotRanked, "User not ranked")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    parseFields(c).projectPage(page),
+	})
+}
+
+// CompareUsers returns the user and ?with= side by side, with the score
+// and rank gaps between them.
+func CompareUsers(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	userID, ok := userIDFrom(c)
+	if !ok {
+		return
+	}
+
+	otherID := c.Query("with")
+	if otherID == "" {
+		badRequest(c, "with is required")
+		return
+	}
+	if !primitive.IsValidObjectID(otherID) {
+		badRequest(c, "with must be a 24-character hex user ID")
+		return
+	}
+
+	comparison := board.CompareUsers(userID, otherID)
+	if comparison == nil {
+		fail(c, http.StatusNotFound, models.CodeUserNotFound, "User not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    comparison,
+	})
+}
+
+// GetUserTier returns the user's percentile tier and "Top N%" label.
+func GetUserTier(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	userID, ok := userIDFrom(c)
+	if !ok {
+		return
+	}
+
+	tier := board.GetUserTier(userID)
+	if tier == nil {
+		fail(c, http.StatusNotFound, models.CodeUserNotRanked, "User not ranked")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    tier,
+	})
+}
+
+func GetUserHistory(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+	userID, ok := userIDFrom(c)
+	if !ok {
+		return
+	}
+
+	var from, to time.Time
+	var err error
+	if v := c.Query("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			badRequest(c, "from must be an RFC3339 timestamp")
+			return
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			badRequest(c, "to must be an RFC3339 timestamp")
+			return
+		}
+	}
+
+	history, err := board.GetRankHistory(c.Request.Context(), userID, from, to)
+	if err != nil {
+		failErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    models.RankHistory{History: history, Count: len(history)},
+	})
+}
+
+// maxRankLookup caps the number of IDs accepted by GetRanks.
+const maxRankLookup = 500
+
+type GetRanksRequest struct {
+	IDs []string `json:"ids" binding:"required"`
+}
+
+func GetRanks(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	var req GetRanksRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if len(req.IDs) > maxRankLookup {
+		badRequest(c, "Too many ids (max "+strconv.Itoa(maxRankLookup)+")")
+		return
+	}
+
+	users, notFound := board.GetRanks(req.IDs)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    models.UserRanks{Users: users, NotFound: notFound},
+	})
+}
+
+type CreateUserRequest struct {
+	Username   string             `json:"username" binding:"required"`
+	Rating     int                `json:"rating"`
+	Score      int                `json:"score"`
+	Country    string             `json:"country"`
+	Components map[string]float64 `json:"components"`
+}
+
+func CreateUser(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	var req CreateUserRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	score := req.Rating
+	if score == 0 {
+		score = req.Score
+	}
+
+	user, err := board.CreateUser(c.Request.Context(), req.Username, score, req.Country, req.Components)
+	if err != nil {
+		failErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    models.UserResult{User: user},
+	})
+}
+
+// maxBatchCreate caps the number of users accepted by a single batch request.
+const maxBatchCreate = 1000
+
+type BatchCreateItemRequest struct {
+	Username string `json:"username"`
+	Rating   int    `json:"rating"`
+	Score    int    `json:"score"`
+	Country  string `json:"country"`
+}
+
+// CreateUsersBatch creates up to maxBatchCreate users. Any invalid user fails
+// the whole request with 422 listing them all, unless ?partial=true, which
+// creates the valid ones and reports the rest per item.
+func CreateUsersBatch(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	var req []BatchCreateItemRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if len(req) == 0 {
+		badRequest(c, "Request body must be a non-empty array of users")
+		return
+	}
+	if len(req) > maxBatchCreate {
+		badRequest(c, "Too many users in batch (max "+strconv.Itoa(maxBatchCreate)+")")
+		return
+	}
+
+	users := make([]models.NewUser, len(req))
+	for i, item := range req {
+		score := item.Rating
+		if score == 0 {
+			score = item.Score
+		}
+		users[i] = models.NewUser{Username: item.Username, Score: score, Country: item.Country}
+	}
+
+	results, err := board.CreateUsersBatch(c.Request.Context(), users, c.Query("partial") == "true")
+	var invalid services.ValidationErrors
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"success": false,
+			"error":   models.APIError{Code: models.CodeValidation, Message: err.Error()},
+			"errors":  invalid,
+		})
+		return
+	}
+
+	created := 0
+	for _, r := range results {
+		if r.Success {
+			created++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": models.BatchCreateResult{
+			Results: results,
+			Created: created,
+			Failed:  len(results) - created,
+		},
+	})
+}
+
+// ImportUsers bulk-creates users from a CSV or JSON upload, either as the
+// "file" field of a multipart form or as the raw request body. The body is
+// streamed, so file size is bounded by the rate of inserts, not memory.
+func ImportUsers(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	body, format, err := importSource(c)
+	if err != nil {
+		if tooLarge(err) {
+			failErr(c, err)
+			return
+		}
+		badRequest(c, err.Error())
+		return
+	}
+
+	result, err := board.ImportUsers(c.Request.Context(), body, format)
+	if err != nil {
+		status, code := serviceError(err)
+		// Rows before the error were imported, so report them too
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   models.APIError{Code: code, Message: err.Error()},
+			"data":    result,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+// importSource returns the upload stream and its format. The format comes
+// from ?format=, then the file extension, then the content type.
+func importSource(c *gin.Context) (io.Reader, string, error) {
+	format := c.Query("format")
+	explicit := format != ""
+
+	var body io.Reader = c.Request.Body
+	contentType := c.ContentType()
+	if contentType == "multipart/form-data" {
+		mr, err := c.Request.MultipartReader()
+		if err != nil {
+			return nil, "", err
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil, "", errors.New("multipart form must include a file field")
+			}
+			if err != nil {
+				return nil, "", err
+			}
+			if part.FormName() == "file" {
+				body = part
+				contentType = part.Header.Get("Content-Type")
+				if format == "" {
+					format = strings.TrimPrefix(strings.ToLower(path.Ext(part.FileName())), ".")
+				}
+				break
+			}
+		}
+	}
+
+	if !explicit && format != services.ImportCSV && format != services.ImportJSON {
+		switch {
+		case strings.Contains(contentType, "csv"):
+			format = services.ImportCSV
+		case strings.Contains(contentType, "json"):
+			format = services.ImportJSON
+		}
+	}
+	if format != services.ImportCSV && format != services.ImportJSON {
+		return nil, "", errors.New("format must be csv or json")
+	}
+	return body, format, nil
+}
+
+const (
+	defaultDeleteByPrefix = 100
+	maxDeleteByPrefix     = 1000
+)
+
+// DeleteUsers removes users in bulk, either every user scoring ?maxScore=
+// or less, or those whose username starts with ?prefix=. One of the two is
+// required so a bare request can't wipe the board.
+func DeleteUsers(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	if raw, ok := c.GetQuery("maxScore"); ok {
+		maxScore, err := strconv.Atoi(raw)
+		if err != nil {
+			badRequest(c, "maxScore must be an integer")
+			return
+		}
+		deleteUsersByScore(c, board, maxScore)
+		return
+	}
+	if c.Query("prefix") == "" {
+		badRequest(c, "maxScore or prefix is required")
+		return
+	}
+	deleteUsersByPrefix(c, board)
+}
+
+func deleteUsersByScore(c *gin.Context, board *services.Board, maxScore int) {
+	count, err := board.DeleteByMaxScore(c.Request.Context(), maxScore)
+	if err != nil {
+		failErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    models.DeletedUsers{Count: count},
+	})
+}
 
-	if page < 1 {
-		page = 1
+// deleteUsersByPrefix removes users whose username starts with ?prefix=, up
+// to ?limit= per call. "more" tells the caller to repeat if matches remain.
+func deleteUsersByPrefix(c *gin.Context, board *services.Board) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultDeleteByPrefix)))
+	if err != nil || limit < 1 || limit > maxDeleteByPrefix {
+		badRequest(c, "limit must be between 1 and "+strconv.Itoa(maxDeleteByPrefix))
+		return
 	}
-	if limit < 1 || limit > 100 {
-		limit = 50
+
+	deleted, more, err := board.DeleteByPrefix(c.Request.Context(), c.Query("prefix"), limit)
+	if err != nil {
+		failErr(c, err)
+		return
 	}
 
-	response := services.GetLeaderboard(page, limit)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data":    response,
+		"data":    models.DeletedUsers{Deleted: deleted, Count: len(deleted), More: more},
 	})
 }
 
-func GetTopN(c *gin.Context) {
-	n, _ := strconv.Atoi(c.Param("n"))
-	if n < 1 {
-		n = 10
+type UpdateScoreRequest struct {
+	Score  int `json:"score"`
+	Rating int `json:"rating"`
+}
+
+func UpdateScore(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	userID, ok := userIDFrom(c)
+	if !ok {
+		return
+	}
+
+	mode, ok := services.ParseScoreMode(c.Query("mode"))
+	if !ok {
+		badRequest(c, "mode must be set, max or min")
+		return
+	}
+
+	var req UpdateScoreRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	score := req.Score
+	if score == 0 {
+		score = req.Rating
 	}
-	if n > 100 {
-		n = 100
+
+	user, err := board.UpdateScoreIf(c.Request.Context(), userID, score, mode)
+	if err != nil {
+		failErr(c, err)
+		return
 	}
 
-	entries := services.GetTopN(n)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data":    gin.H{"entries": entries, "count": len(entries)},
+		"data":    models.ScoreResult{User: user},
 	})
 }
 
-func SearchUsers(c *gin.Context) {
-	prefix := c.Query("prefix")
-	if prefix == "" {
-		prefix = c.Query("username")
+type IncrementScoreRequest struct {
+	Delta *int `json:"delta" binding:"required"`
+}
+
+func IncrementScore(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
 	}
-	if prefix == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "prefix is required",
-		})
+
+	userID, ok := userIDFrom(c)
+	if !ok {
 		return
 	}
 
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
-	if limit < 1 {
-		limit = 100
+	var req IncrementScoreRequest
+	if !bindJSON(c, &req) {
+		return
 	}
-	if limit > 500 {
-		limit = 500
+
+	user, err := board.IncrementScore(c.Request.Context(), userID, *req.Delta)
+	if err != nil {
+		failErr(c, err)
+		return
 	}
 
-	users := services.SearchByPrefix(prefix, limit)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data":    gin.H{"users": users, "count": len(users)},
+		"data":    models.ScoreResult{User: user},
 	})
 }
 
-func GetUserByID(c *gin.Context) {
-	userID := c.Param("id")
+type UpdateComponentsRequest struct {
+	Components map[string]float64 `json:"components" binding:"required"`
+}
 
-	user := services.GetUserByID(userID)
-	if user == nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "User not found",
-		})
+// UpdateComponents replaces a user's score components and rescores them
+// with the configured weights.
+func UpdateComponents(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	userID, ok := userIDFrom(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateComponentsRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	user, err := board.UpdateComponents(c.Request.Context(), userID, req.Components)
+	if err != nil {
+		failErr(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data":    user,
+		"data":    models.ScoreResult{User: user},
 	})
 }
 
-type CreateUserRequest struct {
+// DeactivateUser hides a user from the leaderboard, keeping their record.
+func DeactivateUser(c *gin.Context) {
+	setUserActive(c, false)
+}
+
+// ReactivateUser puts a deactivated user back on the leaderboard.
+func ReactivateUser(c *gin.Context) {
+	setUserActive(c, true)
+}
+
+func setUserActive(c *gin.Context, active bool) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	userID, ok := userIDFrom(c)
+	if !ok {
+		return
+	}
+
+	var user *models.UserResponse
+	var err error
+	if active {
+		user, err = board.Reactivate(c.Request.Context(), userID)
+	} else {
+		user, err = board.Deactivate(c.Request.Context(), userID)
+	}
+	if err != nil {
+		failErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    models.UserResult{User: user},
+	})
+}
+
+type UpdateUsernameRequest struct {
 	Username string `json:"username" binding:"required"`
-	Rating   int    `json:"rating"`
-	Score    int    `json:"score"`
 }
 
-func CreateUser(c *gin.Context) {
-	var req CreateUserRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid request body",
-		})
+func UpdateUsername(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
 		return
 	}
 
-	score := req.Rating
-	if score == 0 {
-		score = req.Score
+	userID, ok := userIDFrom(c)
+	if !ok {
+		return
 	}
-	if score == 0 {
-		score = 100
+
+	var req UpdateUsernameRequest
+	if !bindJSON(c, &req) {
+		return
 	}
 
-	user, err := services.CreateUser(c.Request.Context(), req.Username, score)
+	user, err := board.UpdateUsername(c.Request.Context(), userID, req.Username)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if _, ok := err.(*services.ValidationError); ok {
-			status = http.StatusBadRequest
-		}
-		c.JSON(status, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		failErr(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
+	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data":    gin.H{"user": user},
+		"data":    models.UserResult{User: user},
 	})
 }
 
-type UpdateScoreRequest struct {
-	Score  int `json:"score"`
-	Rating int `json:"rating"`
+type UpdateCountryRequest struct {
+	Country string `json:"country"`
 }
 
-func UpdateScore(c *gin.Context) {
-	userID := c.Param("id")
+// UpdateCountry sets a user's country; an empty code clears it.
+func UpdateCountry(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
 
-	var req UpdateScoreRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid request body",
-		})
+	userID, ok := userIDFrom(c)
+	if !ok {
 		return
 	}
 
-	score := req.Score
-	if score == 0 {
-		score = req.Rating
+	var req UpdateCountryRequest
+	if !bindJSON(c, &req) {
+		return
 	}
 
-	user, err := services.UpdateScore(c.Request.Context(), userID, score)
+	user, err := board.UpdateCountry(c.Request.Context(), userID, req.Country)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if _, ok := err.(*services.ValidationError); ok {
-			status = http.StatusBadRequest
-		}
-		c.JSON(status, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		failErr(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data":    gin.H{"user": user},
+		"data":    models.UserResult{User: user},
 	})
 }
 
@@ -179,27 +1233,18 @@ type BulkUpdateRandomRequest struct {
 }
 
 func BulkUpdateRandom(c *gin.Context) {
-	var req BulkUpdateRandomRequest
-	if err := c.ShouldBindJSON(&req); err != nil || req.Count < 1 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "count is required (min 1)",
-		})
+	board := boardFrom(c)
+	if board == nil {
 		return
 	}
 
-	result, err := services.BulkUpdateRandom(c.Request.Context(), req.Count)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+	var req BulkUpdateRandomRequest
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"data":    result,
+	runBulkUpdate(c, func(progress services.BulkProgress) (*models.BulkUpdateResult, error) {
+		return board.BulkUpdateRandom(c.Request.Context(), req.Count, c.Query("dryRun") == "true", progress)
 	})
 }
 
@@ -209,25 +1254,166 @@ type BulkUpdateToValueRequest struct {
 }
 
 func BulkUpdateToValue(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
 	var req BulkUpdateToValueRequest
-	if err := c.ShouldBindJSON(&req); err != nil || req.Count < 1 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "count and rating are required",
-		})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	result, err := services.BulkUpdateToValue(c.Request.Context(), req.Count, req.Rating)
-	if err != nil {
-		status := http.StatusInternalServerError
-		if _, ok := err.(*services.ValidationError); ok {
-			status = http.StatusBadRequest
+	runBulkUpdate(c, func(progress services.BulkProgress) (*models.BulkUpdateResult, error) {
+		return board.BulkUpdateToValue(c.Request.Context(), req.Count, req.Rating, c.Query("dryRun") == "true", progress)
+	})
+}
+
+// runBulkUpdate runs a bulk update and writes its result. Clients that accept
+// text/event-stream instead get "progress" events ({updated, total}) after
+// every write batch, then a "done" event with the result envelope or an
+// "error" event with the error object.
+func runBulkUpdate(c *gin.Context, update func(services.BulkProgress) (*models.BulkUpdateResult, error)) {
+	if !strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		result, err := update(nil)
+		if err != nil {
+			failErr(c, err)
+			return
 		}
-		c.JSON(status, gin.H{
-			"success": false,
-			"error":   err.Error(),
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data":    result,
+		})
+		return
+	}
+
+	// Only the latest progress is kept, so a slow client never holds up the
+	// update; it just sees fewer events
+	progress := make(chan models.BulkProgress, 1)
+	type outcome struct {
+		result *models.BulkUpdateResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := update(func(updated, total int) {
+			select {
+			case <-progress:
+			default:
+			}
+			progress <- models.BulkProgress{Updated: updated, Total: total}
 		})
+		done <- outcome{result, err}
+	}()
+
+	// The update runs on the request context, so a client that disconnects
+	// cancels it and the goroutine above finishes soon after
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case p := <-progress:
+			c.SSEvent("progress", p)
+			return true
+		case o := <-done:
+			if o.err != nil {
+				_, code := serviceError(o.err)
+				c.SSEvent("error", models.APIError{Code: code, Message: o.err.Error()})
+			} else {
+				c.SSEvent("done", gin.H{"success": true, "data": o.result})
+			}
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+type RolloverRequest struct {
+	Label    string `json:"label" binding:"required"`
+	Baseline int    `json:"baseline"`
+}
+
+func RolloverSeason(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	var req RolloverRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if req.Baseline == 0 {
+		req.Baseline = services.DefaultSeasonBaseline()
+	}
+
+	result, err := board.RolloverSeason(c.Request.Context(), req.Label, req.Baseline)
+	if err != nil {
+		failErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+func GetSeasonLeaderboard(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit := pageLimit(c.Query("limit"))
+
+	if page < 1 {
+		page = 1
+	}
+
+	label := c.Param("label")
+	response, err := board.GetSeasonLeaderboard(c.Request.Context(), label, page, limit)
+	if err != nil {
+		failErr(c, err)
+		return
+	}
+	if response == nil {
+		fail(c, http.StatusNotFound, models.CodeSeasonNotFound, "Season not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    models.SeasonLeaderboard{Season: label, Leaderboard: response},
+	})
+}
+
+func ListBoards(c *gin.Context) {
+	boards := services.Boards()
+
+	ids := make([]string, len(boards))
+	for i, b := range boards {
+		ids[i] = b.ID
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    models.BoardList{Boards: ids, Count: len(ids)},
+	})
+}
+
+// reloadTimeout bounds an admin reload. It is detached from the request so a
+// client hanging up can't abort the load halfway through.
+const reloadTimeout = 2 * time.Minute
+
+// ReloadFromDB re-reads every user from MongoDB and rebuilds all boards.
+func ReloadFromDB(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), reloadTimeout)
+	defer cancel()
+
+	result, err := services.Reload(ctx)
+	if err != nil {
+		failErr(c, err)
 		return
 	}
 
@@ -237,9 +1423,98 @@ func BulkUpdateToValue(c *gin.Context) {
 	})
 }
 
+type MaintenanceRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+// SetMaintenance freezes or unfreezes writes on every board.
+func SetMaintenance(c *gin.Context) {
+	var req MaintenanceRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	services.SetMaintenance(*req.Enabled)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    models.MaintenanceState{Maintenance: services.InMaintenance()},
+	})
+}
+
 func GetStats(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    board.GetStats(),
+	})
+}
+
+func GetSummary(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    board.GetSummary(),
+	})
+}
+
+func GetDistribution(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	bucket, err := strconv.Atoi(c.DefaultQuery("bucket", "100"))
+	if err != nil || bucket < 1 || bucket > 5000 {
+		badRequest(c, "bucket must be between 1 and 5000")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    board.GetDistribution(bucket),
+	})
+}
+
+// maxScoreCountBuckets caps how many buckets one count-by-score request
+// may ask for.
+const maxScoreCountBuckets = 1000
+
+// GetScoreCounts counts users per ?bucket= points (default 100) between
+// ?from= and ?to=, both inclusive and required.
+func GetScoreCounts(c *gin.Context) {
+	board := boardFrom(c)
+	if board == nil {
+		return
+	}
+
+	// 32-bit bounds keep the bucket arithmetic clear of overflow
+	from, fromErr := strconv.ParseInt(c.Query("from"), 10, 32)
+	to, toErr := strconv.ParseInt(c.Query("to"), 10, 32)
+	if fromErr != nil || toErr != nil || from > to {
+		badRequest(c, "from and to must be integers with from <= to")
+		return
+	}
+
+	bucket, err := strconv.ParseInt(c.DefaultQuery("bucket", "100"), 10, 32)
+	if err != nil || bucket < 1 {
+		badRequest(c, "bucket must be a positive integer")
+		return
+	}
+	if (to-from)/bucket+1 > maxScoreCountBuckets {
+		badRequest(c, "range may span at most "+strconv.Itoa(maxScoreCountBuckets)+" buckets")
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data":    services.GetStats(),
+		"data":    board.GetScoreCounts(int(from), int(to), int(bucket)),
 	})
 }
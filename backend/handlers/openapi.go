@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"matiks-leaderboard/models"
+	"matiks-leaderboard/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Reusable parameter annotations.
+var (
+	pageParam   = openapi.Param{Name: "page", Type: "integer", Description: "1-based page number"}
+	limitParam  = openapi.Param{Name: "limit", Type: "integer", Description: "Page size, capped by MAX_PAGE_LIMIT"}
+	fieldsParam = openapi.Param{Name: "fields", Description: "Comma-separated fields to keep on each entry"}
+	rankParam   = openapi.Param{Name: "rankMode", Description: "standard (default) or dense"}
+	dryRunParam = openapi.Param{Name: "dryRun", Type: "boolean", Description: "Preview the update without writing it"}
+	userIDParam = openapi.Param{Name: "id", Description: "User ID"}
+)
+
+// operations annotates each handler for the OpenAPI document, keyed by
+// function name. Every route's last handler needs an entry here, or it is
+// reported as undocumented at startup.
+var operations = map[string]openapi.Operation{
+	"Index": {
+		Summary:   "Describe the API",
+		Response:  models.APIInfo{},
+		Unwrapped: true,
+	},
+	"Health": {
+		Summary:   "Report liveness and database reachability",
+		Response:  models.HealthStatus{},
+		Unwrapped: true,
+	},
+	"Ready": {
+		Summary:   "Report whether the service can take traffic",
+		Response:  models.Readiness{},
+		Unwrapped: true,
+	},
+	"OpenAPI": {
+		Summary:   "This document",
+		Response:  map[string]interface{}{},
+		Unwrapped: true,
+	},
+
+	"ReloadFromDB": {
+		Summary:  "Reload every board from MongoDB",
+		Response: models.ReloadResult{},
+	},
+	"SetMaintenance": {
+		Summary:  "Freeze or unfreeze writes on every board",
+		Request:  MaintenanceRequest{},
+		Response: models.MaintenanceState{},
+	},
+	"ListBoards": {
+		Summary:  "List the boards",
+		Response: models.BoardList{},
+	},
+
+	"GetLeaderboard": {
+		Summary: "Get a page of the leaderboard",
+		Query: []openapi.Param{
+			pageParam, limitParam, rankParam, fieldsParam,
+			{Name: "minScore", Type: "integer", Description: "Lowest score in the band"},
+			{Name: "maxScore", Type: "integer", Description: "Highest score in the band"},
+			{Name: "bandRanks", Type: "boolean", Description: "Rank within the band rather than globally"},
+			{Name: "country", Description: "ISO 3166-1 alpha-2 code to rank within"},
+			{Name: "strict", Type: "boolean", Description: "Reject an out-of-range page or limit with 400 instead of clamping it"},
+		},
+		Response: models.LeaderboardResponse{},
+	},
+	"GetTopN": {
+		Summary:  "Get the top N users",
+		Path:     []openapi.Param{{Name: "n", Type: "integer"}},
+		Query:    []openapi.Param{fieldsParam},
+		Response: models.EntryList{},
+	},
+	"GetBottomN": {
+		Summary:  "Get the bottom N users, last place first",
+		Path:     []openapi.Param{{Name: "n", Type: "integer"}},
+		Query:    []openapi.Param{fieldsParam},
+		Response: models.EntryList{},
+	},
+	"GetMovers": {
+		Summary: "Get the biggest rank changes",
+		Query: []openapi.Param{
+			limitParam,
+			{Name: "window", Description: "all (default) or topN, e.g. top10"},
+		},
+		Response: models.MoversResponse{},
+	},
+	"GetAtRank": {
+		Summary:  "Get the users holding a rank",
+		Path:     []openapi.Param{{Name: "rank", Type: "integer", Description: "Standard (competition) rank"}},
+		Query:    []openapi.Param{fieldsParam},
+		Response: models.RankEntries{},
+	},
+	"GetRange": {
+		Summary: "Get the entries between two ranks",
+		Query: []openapi.Param{
+			{Name: "from", Type: "integer", Required: true},
+			{Name: "to", Type: "integer", Required: true},
+			rankParam, fieldsParam,
+		},
+		Response: models.RankRange{},
+	},
+	"GetRankThreshold": {
+		Summary: "Get the score needed to reach a rank",
+		Query: []openapi.Param{
+			{Name: "rank", Type: "integer", Required: true},
+			{Name: "userId", Description: "Also report how far this user is from it"},
+		},
+		Response: models.RankThreshold{},
+	},
+	"ExportLeaderboard": {
+		Summary:      "Download the whole leaderboard",
+		Query:        []openapi.Param{{Name: "format", Description: "csv (default) or json"}},
+		ResponseType: "text/csv",
+	},
+	"PreviewRank": {
+		Summary: "Preview the rank a score would get",
+		Query: []openapi.Param{
+			{Name: "score", Type: "integer", Required: true},
+			{Name: "neighbors", Type: "integer"},
+		},
+		Response: models.RankPreview{},
+	},
+
+	"SearchUsers": {
+		Summary: "Search users by username prefix, or fuzzily",
+		Query: []openapi.Param{
+			{Name: "prefix", Description: "Username prefix; username is an alias"},
+			{Name: "username"},
+			{Name: "limit", Type: "integer"},
+			{Name: "offset", Type: "integer"},
+			{Name: "fuzzy", Type: "boolean"},
+			{Name: "distance", Type: "integer", Description: "Maximum edit distance for fuzzy search"},
+			fieldsParam,
+		},
+		Response: models.UserSearchResult{},
+	},
+	"GetUserByUsername": {
+		Summary:  "Get a user by username",
+		Query:    []openapi.Param{fieldsParam},
+		Response: models.UserResponse{},
+	},
+	"GetUserByID": {
+		Summary:  "Get a user",
+		Path:     []openapi.Param{userIDParam},
+		Query:    []openapi.Param{fieldsParam},
+		Response: models.UserResponse{},
+	},
+	"GetUserHistory": {
+		Summary: "Get a user's rank history",
+		Path:    []openapi.Param{userIDParam},
+		Query: []openapi.Param{
+			{Name: "from", Description: "RFC3339 timestamp"},
+			{Name: "to", Description: "RFC3339 timestamp"},
+		},
+		Response: models.RankHistory{},
+	},
+	"GetUserPage": {
+		Summary:  "Get the leaderboard page a user is on",
+		Path:     []openapi.Param{userIDParam},
+		Query:    []openapi.Param{limitParam, fieldsParam},
+		Response: models.LeaderboardResponse{},
+	},
+	"GetUserTier": {
+		Summary:  "Get a user's percentile tier",
+		Path:     []openapi.Param{userIDParam},
+		Response: models.UserTier{},
+	},
+	"CompareUsers": {
+		Summary:  "Compare two users' scores and ranks",
+		Path:     []openapi.Param{userIDParam},
+		Query:    []openapi.Param{{Name: "with", Required: true, Description: "ID of the user to compare against"}},
+		Response: models.UserComparison{},
+	},
+	"CreateUser": {
+		Summary:  "Create a user",
+		Request:  CreateUserRequest{},
+		Response: models.UserResult{},
+		Status:   http.StatusCreated,
+	},
+	"CreateUsersBatch": {
+		Summary:  "Create several users",
+		Query:    []openapi.Param{{Name: "partial", Type: "boolean", Description: "Keep the valid users when others fail"}},
+		Request:  []BatchCreateItemRequest{},
+		Response: models.BatchCreateResult{},
+	},
+	"ImportUsers": {
+		Summary:     "Import users from a CSV or JSON upload",
+		Query:       []openapi.Param{{Name: "format", Description: "csv or json; inferred when omitted"}},
+		RequestType: "text/csv",
+		Response:    models.ImportResult{},
+	},
+	"GetRanks": {
+		Summary:  "Get several users' ranks",
+		Request:  GetRanksRequest{},
+		Response: models.UserRanks{},
+	},
+	"UpdateScore": {
+		Summary:  "Set a user's score",
+		Path:     []openapi.Param{userIDParam},
+		Query:    []openapi.Param{{Name: "mode", Description: "set (default), or max or min to keep the higher or lower score"}},
+		Request:  UpdateScoreRequest{},
+		Response: models.ScoreResult{},
+	},
+	"IncrementScore": {
+		Summary:  "Add to a user's score",
+		Path:     []openapi.Param{userIDParam},
+		Request:  IncrementScoreRequest{},
+		Response: models.ScoreResult{},
+	},
+	"UpdateComponents": {
+		Summary:  "Set a user's score components and derive their score",
+		Path:     []openapi.Param{userIDParam},
+		Request:  UpdateComponentsRequest{},
+		Response: models.ScoreResult{},
+	},
+	"DeactivateUser": {
+		Summary:  "Hide a user from the leaderboard, keeping their record",
+		Path:     []openapi.Param{userIDParam},
+		Response: models.UserResult{},
+	},
+	"ReactivateUser": {
+		Summary:  "Put a deactivated user back on the leaderboard",
+		Path:     []openapi.Param{userIDParam},
+		Response: models.UserResult{},
+	},
+	"UpdateUsername": {
+		Summary:  "Rename a user",
+		Path:     []openapi.Param{userIDParam},
+		Request:  UpdateUsernameRequest{},
+		Response: models.UserResult{},
+	},
+	"UpdateCountry": {
+		Summary:  "Set or clear a user's country",
+		Path:     []openapi.Param{userIDParam},
+		Request:  UpdateCountryRequest{},
+		Response: models.UserResult{},
+	},
+	"DeleteUsers": {
+		Summary: "Delete users by score or username prefix",
+		Query: []openapi.Param{
+			{Name: "maxScore", Type: "integer", Description: "Delete every user scoring this or less"},
+			{Name: "prefix", Description: "Delete users whose username starts with this"},
+			{Name: "limit", Type: "integer", Description: "Most users to delete by prefix per call"},
+		},
+		Response: models.DeletedUsers{},
+	},
+
+	"BulkUpdateRandom": {
+		Summary:  "Give random users random scores",
+		Query:    []openapi.Param{dryRunParam},
+		Request:  BulkUpdateRandomRequest{},
+		Response: models.BulkUpdateResult{},
+	},
+	"BulkUpdateToValue": {
+		Summary:  "Set random users to one score",
+		Query:    []openapi.Param{dryRunParam},
+		Request:  BulkUpdateToValueRequest{},
+		Response: models.BulkUpdateResult{},
+	},
+
+	"RolloverSeason": {
+		Summary:  "Archive the season and reset scores",
+		Request:  RolloverRequest{},
+		Response: models.RolloverResult{},
+	},
+	"GetSeasonLeaderboard": {
+		Summary:  "Get a page of an archived season",
+		Query:    []openapi.Param{pageParam, limitParam},
+		Response: models.SeasonLeaderboard{},
+	},
+
+	"GetStats": {
+		Summary:  "Get board statistics",
+		Response: map[string]interface{}{},
+	},
+	"GetDistribution": {
+		Summary:  "Get the score histogram",
+		Query:    []openapi.Param{{Name: "bucket", Type: "integer", Description: "Bucket width"}},
+		Response: models.ScoreDistribution{},
+	},
+	"GetScoreCounts": {
+		Summary: "Count users in score buckets",
+		Query: []openapi.Param{
+			{Name: "from", Type: "integer", Required: true},
+			{Name: "to", Type: "integer", Required: true},
+			{Name: "bucket", Type: "integer", Description: "Bucket width"},
+		},
+		Response: models.ScoreCounts{},
+	},
+	"GetSummary": {
+		Summary:  "Get score summary statistics",
+		Response: models.ScoreSummary{},
+	},
+}
+
+// Index describes the API at the root path.
+func Index(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIInfo{
+		Name:    "Matiks Leaderboard API",
+		Version: "1.0.0",
+		Docs:    "/api/openapi.json",
+	})
+}
+
+// OpenAPI serves the OpenAPI document for the router's routes. routes is
+// called on the first request, once every route is registered; routes
+// without an entry in operations are logged and left out.
+func OpenAPI(routes func() gin.RoutesInfo) gin.HandlerFunc {
+	var (
+		once sync.Once
+		spec []byte
+	)
+	return func(c *gin.Context) {
+		once.Do(func() {
+			doc, undocumented := OpenAPIDocument(routes())
+			if len(undocumented) > 0 {
+				slog.Warn("routes missing from the OpenAPI document", "routes", undocumented)
+			}
+			spec, _ = json.Marshal(doc)
+		})
+		c.Data(http.StatusOK, "application/json; charset=utf-8", spec)
+	}
+}
+
+// OpenAPIDocument describes routes, returning the routes that have no entry
+// in operations alongside the document.
+func OpenAPIDocument(routes gin.RoutesInfo) (*openapi.Document, []string) {
+	return openapi.Build(
+		openapi.Info{Title: "Matiks Leaderboard API", Version: "1.0.0"},
+		routes,
+		operations,
+		openapi.Object{"success": false, "error": models.APIError{}},
+	)
+}
@@ -5,39 +5,160 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"google.golang.org/grpc"
 
+	"matiks-leaderboard/cache"
 	"matiks-leaderboard/database"
+	"matiks-leaderboard/engine"
+	"matiks-leaderboard/env"
+	"matiks-leaderboard/grpcapi"
 	"matiks-leaderboard/handlers"
+	"matiks-leaderboard/middleware"
 	"matiks-leaderboard/services"
 )
 
 func main() {
 	godotenv.Load()
+	setupLogging(os.Getenv("LOG_FORMAT"))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	if redisURI := os.Getenv("REDIS_URI"); redisURI != "" {
+		store, err := cache.NewRedisStore(ctx, redisURI)
+		if err != nil {
+			log.Fatal("Failed to connect to Redis:", err)
+		}
+		defer store.Close()
+		cache.Global = store
+		services.NewBoardStore = func(boardID string) cache.Store {
+			return store.Namespace(boardID)
+		}
+		log.Println("✅ Using Redis cache backend")
+	}
+
+	switch order := os.Getenv("LEADERBOARD_ORDER"); order {
+	case "", "desc":
+	case "asc":
+		engine.Global.SetAscending(true)
+		log.Println("🔃 Leaderboard order: ascending (lower is better)")
+	default:
+		log.Fatalf("Invalid LEADERBOARD_ORDER %q (expected asc or desc)", order)
+	}
+
+	tieBreak, ok := engine.ParseTieBreak(os.Getenv("TIEBREAK"))
+	if !ok {
+		log.Fatalf("Invalid TIEBREAK %q (expected username, time or insertion)", os.Getenv("TIEBREAK"))
+	}
+	engine.Global.SetTieBreak(tieBreak)
+
+	services.LoadDebounceConfig()
+	services.LoadDBConfig()
+	services.LoadUsernameConfig()
+	services.LoadScoreConfig()
+	services.LoadCompositeConfig()
+	services.LoadTierConfig()
+	services.LoadSnapshotConfig()
+	services.LoadHistoryConfig()
+	services.LoadWebhookConfig()
+	handlers.LoadLimitConfig()
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "3000"
+	}
+	srv := newServer(":"+port, newRouter())
+
+	// The gRPC API is for internal callers and only runs when given a port
+	grpcPort := os.Getenv("GRPC_PORT")
+	var grpcSrv *grpc.Server
+	if grpcPort != "" {
+		grpcSrv = grpcapi.NewServer(middleware.ParseAPIKeys(os.Getenv("API_KEYS")), handlers.MaxPageLimit())
+	}
+	// Profiles are served on their own address, localhost only by default,
+	// so they are never reachable through the public API port
+	var pprofSrv *http.Server
+	if os.Getenv("ENABLE_PPROF") == "true" {
+		addr := os.Getenv("PPROF_ADDR")
+		if addr == "" {
+			addr = "localhost:6060"
+		}
+		pprofSrv = &http.Server{Addr: addr, Handler: newPprofMux()}
+		go serve(pprofSrv)
+		log.Printf("🔬 pprof on http://%s/debug/pprof/\n", addr)
+	}
+	startServing := func() {
+		go serve(srv)
+		if grpcSrv != nil {
+			go serveGRPC(grpcSrv, ":"+grpcPort)
+		}
+	}
+
+	// With a snapshot file, reads are served straight away from the restored
+	// data and writes wait until MongoDB has been loaded.
+	snapshotFile := os.Getenv("SNAPSHOT_FILE")
+	serving := false
+	if snapshotFile != "" {
+		restored, err := services.LoadSnapshotFile(snapshotFile)
+		if err != nil {
+			log.Printf("⚠️ Ignoring snapshot file: %v", err)
+		} else if restored > 0 {
+			startServing()
+			serving = true
+			log.Printf("💾 Serving %d cached users on :%s while MongoDB loads", restored, port)
+		}
+	}
+
 	mongoURI := os.Getenv("MONGODB_URI")
 	if mongoURI == "" {
 		mongoURI = "mongodb://localhost:27017/matiks-leaderboard"
 	}
 
-	if err := database.Connect(ctx, mongoURI); err != nil {
-		log.Fatal("Failed to connect to MongoDB:", err)
+	for {
+		connectCtx, cancelConnect := context.WithTimeout(context.Background(), 30*time.Second)
+		err := database.Connect(connectCtx, mongoURI)
+		cancelConnect()
+		if err == nil {
+			break
+		}
+		if !serving {
+			log.Fatal("Failed to connect to MongoDB:", err)
+		}
+		// Keep serving cached reads rather than exiting
+		log.Printf("⚠️ MongoDB unavailable, retrying in 5s: %v", err)
+		time.Sleep(5 * time.Second)
 	}
 	defer database.Disconnect(context.Background())
 
+	healthInterval, err := time.ParseDuration(os.Getenv("DB_HEALTH_INTERVAL"))
+	if err != nil || healthInterval <= 0 {
+		healthInterval = 10 * time.Second
+	}
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+	database.StartHealthMonitor(monitorCtx, healthInterval)
+
+	initCtx, cancelInit := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelInit()
+
 	log.Println("📊 Initializing Leaderboard Service...")
-	if err := services.Initialize(ctx); err != nil {
+	if err := services.Initialize(initCtx); err != nil {
 		log.Fatal("Failed to initialize service:", err)
 	}
 
-	count, err := services.SeedDatabase(ctx)
+	count, err := services.SeedDatabase(initCtx)
 	if err != nil {
 		log.Fatal("Failed to seed database:", err)
 	}
@@ -45,61 +166,219 @@ func main() {
 		log.Printf("🌱 Seeded %d users\n", count)
 	}
 
-	gin.SetMode(gin.ReleaseMode)
-	r := gin.Default()
-
-	r.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	services.StartDecay(jobsCtx)
+	services.StartReconcile(jobsCtx)
+
+	if !serving {
+		startServing()
+	}
+
+	log.Println("🚀 Matiks Leaderboard API (Go)")
+	log.Printf("📡 http://localhost:%s\n", port)
+	if grpcSrv != nil {
+		log.Printf("📡 gRPC on :%s\n", grpcPort)
+	}
+	log.Println("✅ Server ready!")
+
+	stopCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-stopCtx.Done()
+
+	log.Println("🛑 Shutting down...")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("⚠️ Server shutdown: %v", err)
+	}
+	if grpcSrv != nil {
+		grpcSrv.GracefulStop()
+	}
+	if pprofSrv != nil {
+		pprofSrv.Shutdown(shutdownCtx)
+	}
+
+	// Saved after the server stops so no write lands after the file is taken
+	if snapshotFile != "" {
+		if err := services.SaveSnapshotFile(snapshotFile); err != nil {
+			log.Printf("⚠️ Failed to save snapshot file: %v", err)
 		}
-		c.Next()
-	})
-
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"status":    "ok",
-			"timestamp": time.Now().Format(time.RFC3339),
-		})
-	})
-
-	r.GET("/", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"name":    "Matiks Leaderboard API",
-			"version": "1.0.0",
-			"docs":    "/api/stats",
-		})
-	})
+	}
+}
 
-	api := r.Group("/api")
-	{
-		api.GET("/leaderboard", handlers.GetLeaderboard)
-		api.GET("/leaderboard/top/:n", handlers.GetTopN)
+func serve(srv *http.Server) {
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal("Failed to start server:", err)
+	}
+}
 
-		api.GET("/users/search", handlers.SearchUsers)
-		api.GET("/users/:id", handlers.GetUserByID)
-		api.POST("/users", handlers.CreateUser)
-		api.PUT("/users/:id/score", handlers.UpdateScore)
+func serveGRPC(srv *grpc.Server, addr string) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal("Failed to start gRPC server:", err)
+	}
+	if err := srv.Serve(lis); err != nil {
+		log.Fatal("gRPC server stopped:", err)
+	}
+}
 
-		api.POST("/bulk-update/random", handlers.BulkUpdateRandom)
-		api.POST("/bulk-update/value", handlers.BulkUpdateToValue)
+// newServer wraps the router in an http.Server with timeouts from the
+// environment, so slow or stalled clients can't hold connections open
+// indefinitely. The write timeout also bounds streamed exports and admin
+// reloads, so it defaults well above the per-operation database deadline.
+// Cleartext HTTP/2 (h2c) is served alongside HTTP/1.1 unless
+// HTTP2_ENABLED=false.
+func newServer(addr string, r *gin.Engine) *http.Server {
+	r.UseH2C = os.Getenv("HTTP2_ENABLED") != "false"
+	return &http.Server{
+		Addr:              addr,
+		Handler:           r.Handler(),
+		ReadHeaderTimeout: env.PositiveDuration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       env.PositiveDuration("HTTP_READ_TIMEOUT", time.Minute),
+		WriteTimeout:      env.PositiveDuration("HTTP_WRITE_TIMEOUT", 3*time.Minute),
+		IdleTimeout:       env.PositiveDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+	}
+}
+
+// newPprofMux serves the runtime profiles under /debug/pprof.
+func newPprofMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
 
-		api.GET("/stats", handlers.GetStats)
+// trustedProxies reads TRUSTED_PROXIES, a comma-separated list of proxy IPs
+// or CIDRs whose X-Forwarded-For is believed. None are trusted by default.
+func trustedProxies() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
 	}
+	return proxies
+}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3000"
+// newRouter builds the HTTP router with every middleware and route.
+func newRouter() *gin.Engine {
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	// Forwarded client addresses are only believed from TRUSTED_PROXIES, so
+	// a client can't choose its own rate limit bucket or idempotency scope
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Printf("⚠️ Invalid TRUSTED_PROXIES, trusting no proxies: %v", err)
+		r.SetTrustedProxies(nil)
+	}
+	r.Use(gin.Recovery(), middleware.RequestLogger())
+	if os.Getenv("GZIP_ENABLED") != "false" {
+		r.Use(middleware.Gzip())
 	}
 
-	log.Println("🚀 Matiks Leaderboard API (Go)")
-	log.Printf("📡 http://localhost:%s\n", port)
-	log.Println("✅ Server ready!")
+	r.Use(middleware.CORS(os.Getenv("CORS_ORIGINS")))
 
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	r.GET("/health", handlers.Health)
+	r.GET("/ready", handlers.Ready)
+
+	r.GET("/", handlers.Index)
+
+	api := r.Group("/api")
+	api.Use(middleware.RequireWritable(services.Loaded))
+	api.Use(middleware.NewRateLimiter(
+		env.PositiveFloat("RATE_LIMIT_RPS", 10),
+		env.PositiveInt("RATE_LIMIT_BURST", 20),
+	).Middleware())
+	if keys := middleware.ParseAPIKeys(os.Getenv("API_KEYS")); len(keys) > 0 {
+		api.Use(middleware.RequireAPIKey(keys))
+	} else {
+		log.Println("⚠️ API_KEYS not set, write endpoints are unauthenticated")
+	}
+	// Body limits are set per route rather than on the group, so imports
+	// can take a larger body than everything else
+	bodyLimit := middleware.BodyLimit(int64(env.PositiveInt("MAX_BODY_BYTES", 1<<20)))
+	importLimit := middleware.BodyLimit(int64(env.PositiveInt("MAX_IMPORT_BYTES", 32<<20)))
+	requireAdmin := middleware.RequireAdmin(os.Getenv("ADMIN_TOKEN"))
+	idempotent := middleware.NewIdempotencyStore(
+		env.PositiveDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		env.PositiveInt("IDEMPOTENCY_MAX_KEYS", 10000),
+	).Middleware()
+
+	// Admin routes are registered ahead of the maintenance check, so an
+	// operator can still lift maintenance mode
+	admin := api.Group("/admin", requireAdmin, bodyLimit)
+	admin.POST("/rebuild", handlers.ReloadFromDB)
+	admin.POST("/maintenance", handlers.SetMaintenance)
+	api.Use(middleware.RejectDuringMaintenance(services.InMaintenance))
+
+	registerBoardRoutes(api, bodyLimit, importLimit, requireAdmin, idempotent)
+	api.GET("/boards", handlers.ListBoards)
+	registerBoardRoutes(api.Group("/boards/:board"), bodyLimit, importLimit, requireAdmin, idempotent)
+	api.GET("/openapi.json", handlers.OpenAPI(r.Routes))
+
+	return r
+}
+
+// registerBoardRoutes mounts the per-board API. It is registered once at /api
+// for the default board and once under /api/boards/:board for every board.
+// Every route but the import is capped by bodyLimit.
+func registerBoardRoutes(base *gin.RouterGroup, bodyLimit, importLimit, requireAdmin, idempotent gin.HandlerFunc) {
+	base.POST("/users/import", importLimit, handlers.ImportUsers)
+
+	g := base.Group("", bodyLimit)
+	g.GET("/leaderboard", handlers.GetLeaderboard)
+	g.GET("/leaderboard/top/:n", handlers.GetTopN)
+	g.GET("/leaderboard/bottom/:n", handlers.GetBottomN)
+	g.GET("/leaderboard/at/:rank", handlers.GetAtRank)
+	g.GET("/leaderboard/movers", handlers.GetMovers)
+	g.GET("/leaderboard/range", handlers.GetRange)
+	g.GET("/leaderboard/threshold", handlers.GetRankThreshold)
+	g.GET("/leaderboard/export", handlers.ExportLeaderboard)
+	g.GET("/preview-rank", handlers.PreviewRank)
+
+	g.GET("/users/search", handlers.SearchUsers)
+	g.GET("/users/by-username/:username", handlers.GetUserByUsername)
+	g.GET("/users/:id", handlers.GetUserByID)
+	g.GET("/users/:id/history", handlers.GetUserHistory)
+	g.GET("/users/:id/page", handlers.GetUserPage)
+	g.GET("/users/:id/tier", handlers.GetUserTier)
+	g.GET("/users/:id/compare", handlers.CompareUsers)
+	g.POST("/users", idempotent, handlers.CreateUser)
+	g.POST("/users/batch", handlers.CreateUsersBatch)
+	g.POST("/users/ranks", handlers.GetRanks)
+	g.PUT("/users/:id/score", handlers.UpdateScore)
+	g.POST("/users/:id/score/increment", idempotent, handlers.IncrementScore)
+	g.PUT("/users/:id/components", handlers.UpdateComponents)
+	g.PUT("/users/:id/username", handlers.UpdateUsername)
+	g.PUT("/users/:id/country", handlers.UpdateCountry)
+	g.PUT("/users/:id/deactivate", requireAdmin, handlers.DeactivateUser)
+	g.PUT("/users/:id/reactivate", requireAdmin, handlers.ReactivateUser)
+	g.DELETE("/users", requireAdmin, handlers.DeleteUsers)
+
+	g.POST("/bulk-update/random", handlers.BulkUpdateRandom)
+	g.POST("/bulk-update/value", handlers.BulkUpdateToValue)
+
+	g.POST("/seasons/rollover", handlers.RolloverSeason)
+	g.GET("/seasons/:label/leaderboard", handlers.GetSeasonLeaderboard)
+
+	g.GET("/stats", handlers.GetStats)
+	g.GET("/stats/distribution", handlers.GetDistribution)
+	g.GET("/stats/counts", handlers.GetScoreCounts)
+	g.GET("/stats/summary", handlers.GetSummary)
+}
+
+// setupLogging installs the default slog logger. Standard library log calls
+// are routed through it as well, so every line shares the same format.
+func setupLogging(format string) {
+	var handler slog.Handler
+	switch format {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, nil)
+	default:
+		handler = slog.NewTextHandler(os.Stdout, nil)
 	}
+	slog.SetDefault(slog.New(handler))
 }
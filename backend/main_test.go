@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"matiks-leaderboard/database/dbtest"
+	"matiks-leaderboard/handlers"
+	"matiks-leaderboard/models"
+	"matiks-leaderboard/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestRouter returns the full router over an empty in-memory database.
+func newTestRouter(t testing.TB) *gin.Engine {
+	t.Helper()
+	dbtest.Install(t)
+	if err := services.Initialize(context.Background()); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	return newRouter()
+}
+
+type apiResponse struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Error   struct {
+		Code    models.ErrorCode `json:"code"`
+		Message string           `json:"message"`
+	} `json:"error"`
+}
+
+func doRequest(t *testing.T, r http.Handler, req *http.Request) (int, apiResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var body apiResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s %s: decoding %q: %v", req.Method, req.URL, w.Body, err)
+	}
+	return w.Code, body
+}
+
+func TestBodyLimits(t *testing.T) {
+	t.Setenv("MAX_BODY_BYTES", "256")
+	r := newTestRouter(t)
+	large := `{"username":"alice","score":500,"padding":"` + strings.Repeat("x", 512) + `"}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(large))
+	if status, body := doRequest(t, r, req); status != http.StatusRequestEntityTooLarge || body.Error.Code != models.CodeBodyTooLarge {
+		t.Errorf("declared oversized body: %d %+v, want 413 BODY_TOO_LARGE", status, body.Error)
+	}
+
+	// Without a Content-Length the limit is only hit while reading
+	req = httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(large))
+	req.ContentLength = -1
+	if status, body := doRequest(t, r, req); status != http.StatusRequestEntityTooLarge || body.Error.Code != models.CodeBodyTooLarge {
+		t.Errorf("streamed oversized body: %d %+v, want 413 BODY_TOO_LARGE", status, body.Error)
+	}
+
+	// Imports have their own, larger limit
+	var csv strings.Builder
+	csv.WriteString("username,score\n")
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&csv, "player%d,%d\n", i, 1000+i)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/api/users/import?format=csv", strings.NewReader(csv.String()))
+	req.Header.Set("Content-Type", "text/csv")
+	status, body := doRequest(t, r, req)
+	var result struct {
+		Inserted int `json:"inserted"`
+	}
+	json.Unmarshal(body.Data, &result)
+	if status != http.StatusOK || result.Inserted != 50 {
+		t.Errorf("%d byte import: %d, inserted %d; want 200, 50", csv.Len(), status, result.Inserted)
+	}
+}
+
+func TestMissingRequiredField(t *testing.T) {
+	r := newTestRouter(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(`{"score":500}`))
+	req.Header.Set("Content-Type", "application/json")
+	status, body := doRequest(t, r, req)
+	if status != http.StatusBadRequest || body.Error.Message != "username is required" {
+		t.Errorf("missing username: %d %q, want 400 \"username is required\"", status, body.Error.Message)
+	}
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	r := newTestRouter(t)
+	if _, undocumented := handlers.OpenAPIDocument(r.Routes()); len(undocumented) > 0 {
+		t.Errorf("routes missing from the OpenAPI document (add them to operations in handlers/openapi.go): %v", undocumented)
+	}
+}
+
+// seedBoard creates n users on the default board, every third tied with the
+// one before, and rebuilds it.
+func seedBoard(t testing.TB, n int) {
+	t.Helper()
+	board := services.DefaultBoard()
+	for i := 0; i < n; i++ {
+		if _, err := board.CreateUser(context.Background(), fmt.Sprintf("player%d", i), 4000-i+i%3/2, "", nil); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	board.ForceRebuild()
+}
+
+// setTopCacheSize changes TOP_CACHE_SIZE for the rest of the test.
+func setTopCacheSize(t testing.TB, size int) {
+	t.Helper()
+	t.Cleanup(services.LoadSnapshotConfig)
+	t.Setenv("TOP_CACHE_SIZE", strconv.Itoa(size))
+	services.LoadSnapshotConfig()
+}
+
+func TestCachedTopNMatchesUncached(t *testing.T) {
+	setTopCacheSize(t, 100)
+	r := newTestRouter(t)
+	seedBoard(t, 50)
+
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", path, w.Code, w.Body)
+		}
+		return w.Body.String()
+	}
+
+	paths := []string{"/api/leaderboard/top/1", "/api/leaderboard/top/10", "/api/leaderboard/top/50", "/api/leaderboard/top/80"}
+	cached := make([]string, len(paths))
+	for i, path := range paths {
+		cached[i] = get(path)
+	}
+
+	setTopCacheSize(t, 0)
+	for i, path := range paths {
+		if uncached := get(path); cached[i] != uncached {
+			t.Errorf("GET %s:\ncached   %s\nuncached %s", path, cached[i], uncached)
+		}
+	}
+}
+
+func BenchmarkTopN(b *testing.B) {
+	for _, tc := range []struct {
+		name      string
+		cacheSize int
+	}{{"cached", 100}, {"uncached", 0}} {
+		for _, n := range []int{10, 100} {
+			b.Run(fmt.Sprintf("%s/top%d", tc.name, n), func(b *testing.B) {
+				setTopCacheSize(b, tc.cacheSize)
+				r := newTestRouter(b)
+				seedBoard(b, 1000)
+				path := fmt.Sprintf("/api/leaderboard/top/%d", n)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					w := httptest.NewRecorder()
+					r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+				}
+			})
+		}
+	}
+}
+
+func TestMalformedParams(t *testing.T) {
+	r := newTestRouter(t)
+	valid := "0123456789abcdef01234567"
+
+	for _, tc := range []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, "/api/leaderboard/top/ten", ""},
+		{http.MethodGet, "/api/leaderboard/top/1.5", ""},
+		{http.MethodGet, "/api/leaderboard/bottom/ten", ""},
+		{http.MethodGet, "/api/leaderboard/bottom/-", ""},
+		{http.MethodGet, "/api/users/not-an-id", ""},
+		{http.MethodGet, "/api/users/0123456789abcdef0123456", ""},
+		{http.MethodGet, "/api/users/not-an-id/history", ""},
+		{http.MethodGet, "/api/users/not-an-id/page", ""},
+		{http.MethodGet, "/api/users/not-an-id/tier", ""},
+		{http.MethodGet, "/api/users/not-an-id/compare?with=" + valid, ""},
+		{http.MethodGet, "/api/users/" + valid + "/compare?with=not-an-id", ""},
+		{http.MethodGet, "/api/users/" + valid + "/compare?with=" + valid + "zz", ""},
+		{http.MethodPut, "/api/users/not-an-id/score", `{"score":500}`},
+		{http.MethodPost, "/api/users/not-an-id/score/increment", `{"delta":5}`},
+		{http.MethodPut, "/api/users/not-an-id/username", `{"username":"alice"}`},
+		{http.MethodPut, "/api/users/not-an-id/country", `{"country":"US"}`},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		if status, body := doRequest(t, r, req); status != http.StatusBadRequest || body.Error.Code != models.CodeValidation {
+			t.Errorf("%s %s: %d %+v, want 400 %s", tc.method, tc.path, status, body.Error, models.CodeValidation)
+		}
+	}
+}
+
+func TestUpdateScoreUnknownUser(t *testing.T) {
+	r := newTestRouter(t)
+
+	for _, tc := range []struct {
+		id     string
+		status int
+		code   models.ErrorCode
+	}{
+		{"0123456789abcdef01234567", http.StatusNotFound, models.CodeUserNotFound},
+		{"not-an-id", http.StatusBadRequest, models.CodeValidation},
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/api/users/"+tc.id+"/score", strings.NewReader(`{"score":500}`))
+		req.Header.Set("Content-Type", "application/json")
+		if status, body := doRequest(t, r, req); status != tc.status || body.Error.Code != tc.code {
+			t.Errorf("user %s: %d %+v, want %d %s", tc.id, status, body.Error, tc.status, tc.code)
+		}
+	}
+}
+
+func TestLeaderboardPagination(t *testing.T) {
+	r := newTestRouter(t)
+	seedBoard(t, 5)
+
+	for _, tc := range []struct {
+		query               string
+		status              int
+		wantPage, wantPages int
+	}{
+		// Five users fill one page of the default 50, or three of 2
+		{"", http.StatusOK, 1, 1},
+		{"?page=0&limit=500", http.StatusOK, 1, 1},
+		{"?page=2&limit=2", http.StatusOK, 2, 3},
+		{"?strict=true", http.StatusOK, 1, 1},
+		{"?strict=true&page=2&limit=2", http.StatusOK, 2, 3},
+		{"?strict=true&page=0", http.StatusBadRequest, 0, 0},
+		{"?strict=true&limit=500", http.StatusBadRequest, 0, 0},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/leaderboard"+tc.query, nil)
+		status, body := doRequest(t, r, req)
+		if status != tc.status {
+			t.Errorf("%s: status %d %+v, want %d", tc.query, status, body.Error, tc.status)
+			continue
+		}
+		if status != http.StatusOK {
+			continue
+		}
+		var page models.LeaderboardResponse
+		if err := json.Unmarshal(body.Data, &page); err != nil {
+			t.Fatalf("%s: decoding %s: %v", tc.query, body.Data, err)
+		}
+		if page.Page != tc.wantPage || page.TotalPages != tc.wantPages {
+			t.Errorf("%s: page %d of %d, want page %d of %d", tc.query, page.Page, page.TotalPages, tc.wantPage, tc.wantPages)
+		}
+	}
+}
+
+func TestLimitsClampToCeilings(t *testing.T) {
+	t.Cleanup(handlers.LoadLimitConfig)
+	t.Setenv("MAX_PAGE_LIMIT", "5")
+	t.Setenv("MAX_SEARCH_LIMIT", "3")
+	handlers.LoadLimitConfig()
+	r := newTestRouter(t)
+	seedBoard(t, 10)
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/api/leaderboard/top/50", 5},
+		{"/api/leaderboard/bottom/50", 5},
+		{"/api/leaderboard?limit=50", 5},
+		{"/api/leaderboard", 5},
+		{"/api/users/search?prefix=player&limit=50", 3},
+		{"/api/users/search?prefix=player", 3},
+	} {
+		status, body := doRequest(t, r, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		var data struct {
+			Count int `json:"count"`
+		}
+		json.Unmarshal(body.Data, &data)
+		if status != http.StatusOK || data.Count != tc.want {
+			t.Errorf("GET %s: %d with %d results, want 200 with %d", tc.path, status, data.Count, tc.want)
+		}
+	}
+}
+
+func TestCreateUserTwice(t *testing.T) {
+	r := newTestRouter(t)
+
+	for i, want := range []int{http.StatusCreated, http.StatusConflict} {
+		req := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(`{"username":"alice","score":500}`))
+		req.Header.Set("Content-Type", "application/json")
+		status, body := doRequest(t, r, req)
+		if status != want {
+			t.Errorf("create %d: %d %+v, want %d", i+1, status, body.Error, want)
+		}
+		if status == http.StatusConflict && body.Error.Code != models.CodeUsernameTaken {
+			t.Errorf("create %d: code %s, want %s", i+1, body.Error.Code, models.CodeUsernameTaken)
+		}
+	}
+}
+
+func TestEmptyBoardPages(t *testing.T) {
+	r := newTestRouter(t)
+
+	for _, path := range []string{"/api/leaderboard", "/api/leaderboard?limit=0", "/api/leaderboard?page=5", "/api/leaderboard/top/10", "/api/leaderboard/bottom/10"} {
+		status, body := doRequest(t, r, httptest.NewRequest(http.MethodGet, path, nil))
+		var data struct {
+			Entries json.RawMessage `json:"entries"`
+			Count   int             `json:"count"`
+		}
+		json.Unmarshal(body.Data, &data)
+		if status != http.StatusOK || string(data.Entries) != "[]" || data.Count != 0 {
+			t.Errorf("GET %s: %d with entries %s, want 200 with []", path, status, data.Entries)
+		}
+	}
+}
+
+func TestLargeLeaderboardIsGzipped(t *testing.T) {
+	r := newTestRouter(t)
+	seedBoard(t, 100)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/leaderboard?limit=100", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("status %d, Content-Encoding %q, want 200 gzip", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want Accept-Encoding", vary)
+	}
+	compressed := w.Body.Len()
+
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("decompressing: %v", err)
+	}
+	var body struct {
+		Data models.LeaderboardResponse `json:"data"`
+	}
+	if err := json.Unmarshal(plain, &body); err != nil || body.Data.Count != 100 {
+		t.Fatalf("decompressed body holds %d entries (err %v), want 100", body.Data.Count, err)
+	}
+	if compressed >= len(plain) {
+		t.Errorf("compressed body is %d bytes, no smaller than the %d uncompressed", compressed, len(plain))
+	}
+
+	// Clients that don't ask for gzip get the plain body
+	req = httptest.NewRequest(http.MethodGet, "/api/leaderboard?limit=100", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if enc := w.Header().Get("Content-Encoding"); enc != "" || !bytes.Equal(w.Body.Bytes(), plain) {
+		t.Errorf("without Accept-Encoding: Content-Encoding %q, body differs: %v", enc, !bytes.Equal(w.Body.Bytes(), plain))
+	}
+}
+
+func TestForwardedForCannotResetRateLimit(t *testing.T) {
+	t.Setenv("RATE_LIMIT_RPS", "0.001")
+	t.Setenv("RATE_LIMIT_BURST", "2")
+
+	// statuses creates one user per forwarded address, all from the same
+	// peer, and returns the status of each; only writes are rate limited
+	statuses := func(r http.Handler, forwarded ...string) []int {
+		codes := make([]int, len(forwarded))
+		for i, ip := range forwarded {
+			body := `{"username":"user` + strconv.Itoa(i) + `","score":500}`
+			req := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(body))
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Header.Set("X-Forwarded-For", ip)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			codes[i] = w.Code
+		}
+		return codes
+	}
+	forged := []string{"203.0.113.1", "203.0.113.2", "203.0.113.3", "203.0.113.4"}
+
+	// Untrusted peer: the header is ignored and every request shares a bucket
+	got := statuses(newTestRouter(t), forged...)
+	want := []int{http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests, http.StatusTooManyRequests}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("forged X-Forwarded-For: statuses %v, want %v", got, want)
+	}
+
+	// A trusted proxy's forwarded addresses each get their own bucket
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1")
+	got = statuses(newTestRouter(t), forged...)
+	want = []int{http.StatusCreated, http.StatusCreated, http.StatusCreated, http.StatusCreated}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("trusted proxy: statuses %v, want %v", got, want)
+	}
+}
+
+func TestUserHistoryOnUnknownBoard(t *testing.T) {
+	r := newTestRouter(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/boards/nope/users/64b7f0c2a1b2c3d4e5f60718/history", nil)
+	if status, body := doRequest(t, r, req); status != http.StatusNotFound || body.Error.Code != models.CodeBoardNotFound {
+		t.Errorf("history on an unknown board: %d %+v, want 404 %s", status, body.Error, models.CodeBoardNotFound)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/users/64b7f0c2a1b2c3d4e5f60718/history", nil)
+	if status, body := doRequest(t, r, req); status != http.StatusOK {
+		t.Errorf("history on the default board: %d %+v, want 200", status, body.Error)
+	}
+}
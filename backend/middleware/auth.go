@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"matiks-leaderboard/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader carries the client's key on mutating requests.
+const APIKeyHeader = "X-API-Key"
+
+// ParseAPIKeys splits a comma-separated key list, dropping blanks.
+func ParseAPIKeys(raw string) []string {
+	var keys []string
+	for _, k := range strings.Split(raw, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// RequireAPIKey rejects write requests whose X-API-Key header does not match
+// one of keys. Reads stay public. Each key can be revoked on its own by
+// removing it from the list.
+func RequireAPIKey(keys []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isReadMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+
+		if !ValidAPIKey(c.GetHeader(APIKeyHeader), keys) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, models.ErrorBody(models.CodeUnauthorized, "Missing or invalid API key"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAdmin guards destructive endpoints with a bearer token shared by
+// operators. With no token configured the endpoints are disabled outright.
+func RequireAdmin(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, models.ErrorBody(models.CodeForbidden, "Admin endpoints are disabled"))
+			return
+		}
+
+		provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ValidAPIKey(provided, []string{token}) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, models.ErrorBody(models.CodeUnauthorized, "Missing or invalid admin token"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// ValidAPIKey reports whether provided matches one of keys, in constant time.
+func ValidAPIKey(provided string, keys []string) bool {
+	if provided == "" {
+		return false
+	}
+	valid := false
+	for _, k := range keys {
+		// Compare against every key so timing doesn't reveal which one matched
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(k)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
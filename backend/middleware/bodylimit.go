@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+
+	"matiks-leaderboard/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BodyLimit caps request bodies at limit bytes. A declared Content-Length
+// over the limit is rejected with 413 up front; otherwise reads fail once
+// the limit is passed and handlers report the 413. Apply one BodyLimit per
+// route: the first one to see a request rejects it.
+func BodyLimit(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, models.ErrorBody(models.CodeBodyTooLarge, "Request body too large"))
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORS answers cross-origin requests. allowed is a comma-separated list of
+// origins, as in CORS_ORIGINS: a listed origin is echoed back with
+// credentials allowed, and any other gets no CORS headers, so the browser
+// blocks it. An empty list (or a "*" entry) allows every origin without
+// credentials, which browsers refuse to combine with a wildcard.
+func CORS(allowed string) gin.HandlerFunc {
+	origins := make(map[string]bool)
+	for _, o := range strings.Split(allowed, ",") {
+		if o = strings.TrimSuffix(strings.TrimSpace(o), "/"); o != "" {
+			origins[o] = true
+		}
+	}
+	wildcard := len(origins) == 0 || origins["*"]
+
+	return func(c *gin.Context) {
+		if wildcard {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			// The response depends on Origin, so caches must key on it
+			c.Writer.Header().Add("Vary", "Origin")
+			if origin := c.GetHeader("Origin"); origins[origin] {
+				c.Header("Access-Control-Allow-Origin", origin)
+				c.Header("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, Idempotency-Key")
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(204)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCORS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, tc := range []struct {
+		name, allowed, origin, method string
+		wantOrigin, wantCredentials   string
+		wantVary                      bool
+		wantStatus                    int
+	}{
+		{"allowed origin", "https://a.example, https://b.example/", "https://b.example", http.MethodGet, "https://b.example", "true", true, http.StatusOK},
+		{"disallowed origin", "https://a.example", "https://evil.example", http.MethodGet, "", "", true, http.StatusOK},
+		{"no origin", "https://a.example", "", http.MethodGet, "", "", true, http.StatusOK},
+		{"allowed preflight", "https://a.example", "https://a.example", http.MethodOptions, "https://a.example", "true", true, http.StatusNoContent},
+		{"empty list", "", "https://any.example", http.MethodGet, "*", "", false, http.StatusOK},
+		{"wildcard entry", "https://a.example,*", "https://any.example", http.MethodGet, "*", "", false, http.StatusOK},
+		{"wildcard preflight", "*", "https://any.example", http.MethodOptions, "*", "", false, http.StatusNoContent},
+	} {
+		r := gin.New()
+		r.Use(CORS(tc.allowed))
+		r.GET("/users", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		req := httptest.NewRequest(tc.method, "/users", nil)
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		h := w.Header()
+		if w.Code != tc.wantStatus {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.wantStatus)
+		}
+		if got := h.Get("Access-Control-Allow-Origin"); got != tc.wantOrigin {
+			t.Errorf("%s: Allow-Origin = %q, want %q", tc.name, got, tc.wantOrigin)
+		}
+		if got := h.Get("Access-Control-Allow-Credentials"); got != tc.wantCredentials {
+			t.Errorf("%s: Allow-Credentials = %q, want %q", tc.name, got, tc.wantCredentials)
+		}
+		if got := h.Get("Vary") == "Origin"; got != tc.wantVary {
+			t.Errorf("%s: Vary = %q, want Origin: %v", tc.name, h.Get("Vary"), tc.wantVary)
+		}
+	}
+}
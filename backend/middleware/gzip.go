@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// gzipWriter compresses the body on its way to the client. Flush pushes the
+// compressed bytes through, so streamed responses such as exports still
+// arrive incrementally.
+type gzipWriter struct {
+	gin.ResponseWriter
+	gz      *gzip.Writer
+	started bool
+}
+
+// start switches the response to gzip on the first body write, so empty
+// responses (204, 304, HEAD) go out untouched.
+func (w *gzipWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	w.gz.Reset(w.ResponseWriter)
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	w.start()
+	return w.gz.Write(b)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	w.start()
+	return w.gz.Write([]byte(s))
+}
+
+func (w *gzipWriter) Flush() {
+	if w.started {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Gzip compresses responses for clients that accept it. Event streams and
+// connection upgrades are passed through, since they need every write to
+// reach the client unbuffered.
+func Gzip() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") ||
+			strings.Contains(c.GetHeader("Accept"), "text/event-stream") ||
+			c.GetHeader("Upgrade") != "" ||
+			c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		gz := gzipWriters.Get().(*gzip.Writer)
+		w := &gzipWriter{ResponseWriter: c.Writer, gz: gz}
+		c.Writer = w
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+
+		defer func() {
+			if w.started {
+				gz.Close()
+			}
+			gz.Reset(nil)
+			gzipWriters.Put(gz)
+		}()
+		c.Next()
+	}
+}
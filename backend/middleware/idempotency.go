@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"matiks-leaderboard/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyHeader carries the client-chosen key identifying a request.
+const IdempotencyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLen bounds the keys clients may send.
+const maxIdempotencyKeyLen = 255
+
+type idempotentResponse struct {
+	scope string
+	// bodyHash is the SHA-256 of the request body that claimed the key
+	bodyHash    [sha256.Size]byte
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+// IdempotencyStore remembers responses by Idempotency-Key so a retried
+// request gets the original response instead of running again. Entries live
+// for ttl, and past maxKeys the oldest are dropped first. Everything is held
+// in memory, so keys don't survive a restart or span instances.
+type IdempotencyStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxKeys int
+	entries map[string]*list.Element
+	// order holds entries oldest first. Every entry has the same ttl, so
+	// that is also expiry order.
+	order *list.List
+}
+
+// NewIdempotencyStore keeps up to maxKeys responses for ttl each.
+func NewIdempotencyStore(ttl time.Duration, maxKeys int) *IdempotencyStore {
+	return &IdempotencyStore{
+		ttl:     ttl,
+		maxKeys: maxKeys,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// begin claims scope for a new request with the given body hash. If scope is
+// already known it returns the existing entry instead, which is still running
+// unless done is set.
+func (s *IdempotencyStore) begin(scope string, bodyHash [sha256.Size]byte) (idempotentResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for front := s.order.Front(); front != nil; front = s.order.Front() {
+		if front.Value.(*idempotentResponse).expires.After(now) {
+			break
+		}
+		s.remove(front)
+	}
+
+	if el, ok := s.entries[scope]; ok {
+		return *el.Value.(*idempotentResponse), false
+	}
+	for s.order.Len() >= s.maxKeys {
+		s.remove(s.order.Front())
+	}
+	s.entries[scope] = s.order.PushBack(&idempotentResponse{scope: scope, bodyHash: bodyHash, expires: now.Add(s.ttl)})
+	return idempotentResponse{}, true
+}
+
+// finish stores the response for a scope claimed by begin.
+func (s *IdempotencyStore) finish(scope string, status int, contentType string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[scope]; ok {
+		r := el.Value.(*idempotentResponse)
+		r.done, r.status, r.contentType, r.body = true, status, contentType, body
+	}
+}
+
+// release forgets scope so the request can be tried again.
+func (s *IdempotencyStore) release(scope string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[scope]; ok {
+		s.remove(el)
+	}
+}
+
+// remove drops el. Caller must hold s.mu.
+func (s *IdempotencyStore) remove(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.entries, el.Value.(*idempotentResponse).scope)
+}
+
+// recordingWriter keeps a copy of the body while passing it through.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Middleware replays the stored response when a request repeats an
+// Idempotency-Key on the same method and path. Keys are scoped to the
+// caller, by API key or else by IP, so clients can't collide or read each
+// other's responses. Requests without the header run as usual. Server errors
+// aren't stored, so the client can retry them; a repeat that arrives while
+// the first is still running gets a 409, and one with a different body a 422.
+func (s *IdempotencyStore) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			c.AbortWithStatusJSON(http.StatusBadRequest, models.ErrorBody(models.CodeValidation, "Idempotency-Key must be at most 255 characters"))
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, models.ErrorBody(models.CodeBodyTooLarge, "Request body too large"))
+			} else {
+				c.AbortWithStatusJSON(http.StatusBadRequest, models.ErrorBody(models.CodeValidation, "Could not read request body"))
+			}
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := c.Request.Method + " " + c.Request.URL.Path + " " + callerID(c) + " " + key
+		hash := sha256.Sum256(body)
+		prev, claimed := s.begin(scope, hash)
+		if !claimed {
+			if prev.bodyHash != hash {
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, models.ErrorBody(models.CodeKeyReused, "Idempotency-Key was already used with a different request body"))
+				return
+			}
+			if !prev.done {
+				c.AbortWithStatusJSON(http.StatusConflict, models.ErrorBody(models.CodeInProgress, "A request with this Idempotency-Key is still in progress"))
+				return
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(prev.status, prev.contentType, prev.body)
+			c.Abort()
+			return
+		}
+
+		w := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		completed := false
+		defer func() {
+			c.Writer = w.ResponseWriter
+			// A panicking handler leaves no response worth replaying
+			if status := w.Status(); completed && status < http.StatusInternalServerError {
+				s.finish(scope, status, w.Header().Get("Content-Type"), w.body.Bytes())
+			} else {
+				s.release(scope)
+			}
+		}()
+		c.Next()
+		completed = true
+	}
+}
+
+// callerID identifies who sent a request: a hash of their API key, so keys
+// aren't kept in memory in the clear, or else their IP.
+func callerID(c *gin.Context) string {
+	if key := c.GetHeader(APIKeyHeader); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:])
+	}
+	return "ip:" + c.ClientIP()
+}
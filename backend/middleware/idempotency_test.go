@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIdempotencyScopesAndBodies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	calls := 0
+	r := gin.New()
+	r.POST("/users", NewIdempotencyStore(time.Hour, 100).Middleware(), func(c *gin.Context) {
+		calls++
+		c.String(http.StatusCreated, "created %d", calls)
+	})
+
+	send := func(apiKey, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+		req.Header.Set(IdempotencyHeader, "key-1")
+		if apiKey != "" {
+			req.Header.Set(APIKeyHeader, apiKey)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send("client-a", `{"username":"alice"}`); w.Code != http.StatusCreated || w.Body.String() != "created 1" {
+		t.Fatalf("first request: %d %q", w.Code, w.Body)
+	}
+
+	w := send("client-a", `{"username":"alice"}`)
+	if w.Body.String() != "created 1" || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("repeat: %d %q, want the first response replayed", w.Code, w.Body)
+	}
+
+	// Another caller picking the same key gets its own request run
+	if w := send("client-b", `{"username":"alice"}`); w.Body.String() != "created 2" {
+		t.Errorf("same key from another API key: %d %q, want a fresh run", w.Code, w.Body)
+	}
+	if w := send("", `{"username":"alice"}`); w.Body.String() != "created 3" {
+		t.Errorf("same key without an API key: %d %q, want a fresh run", w.Code, w.Body)
+	}
+
+	if w := send("client-a", `{"username":"bob"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key with another body: %d %q, want 422", w.Code, w.Body)
+	}
+	if calls != 3 {
+		t.Errorf("handler ran %d times, want 3", calls)
+	}
+}
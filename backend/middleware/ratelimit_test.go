@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"matiks-leaderboard/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiterRejectsBurst(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	// One token a minute, so none refill during the test
+	r.Use(NewRateLimiter(1.0/60, 2).Middleware())
+	r.GET("/users", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/users", func(c *gin.Context) { c.Status(http.StatusCreated) })
+
+	serve := func(method, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/users", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := serve(http.MethodPost, "192.0.2.1"); w.Code != http.StatusCreated {
+			t.Fatalf("write %d within the burst: status %d, want 201", i+1, w.Code)
+		}
+	}
+
+	w := serve(http.MethodPost, "192.0.2.1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("write past the burst: status %d, want 429", w.Code)
+	}
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 60 {
+		t.Errorf("Retry-After = %q, want 1 to 60 seconds", w.Header().Get("Retry-After"))
+	}
+	var body struct {
+		Error struct {
+			Code models.ErrorCode `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Code != models.CodeRateLimited {
+		t.Errorf("write past the burst: body %s, want code %s", w.Body, models.CodeRateLimited)
+	}
+
+	// Reads aren't limited, and each client has its own bucket
+	if w := serve(http.MethodGet, "192.0.2.1"); w.Code != http.StatusOK {
+		t.Errorf("read from a limited client: status %d, want 200", w.Code)
+	}
+	if w := serve(http.MethodPost, "192.0.2.2"); w.Code != http.StatusCreated {
+		t.Errorf("write from another client: status %d, want 201", w.Code)
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+
+	"matiks-leaderboard/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireWritable answers write requests with 503 while writable reports
+// false, e.g. while serving data restored from disk before MongoDB is up.
+func RequireWritable(writable func() bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isReadMethod(c.Request.Method) || writable() {
+			c.Next()
+			return
+		}
+
+		c.Header("Retry-After", "5")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, models.ErrorBody(models.CodeUnavailable, "Serving cached data while the database loads, writes are unavailable"))
+	}
+}
+
+// RejectDuringMaintenance answers write requests with 503 while inMaintenance
+// reports true. Reads keep being served from the snapshot.
+func RejectDuringMaintenance(inMaintenance func() bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isReadMethod(c.Request.Method) || !inMaintenance() {
+			c.Next()
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, models.ErrorBody(models.CodeMaintenance, "Writes are paused for maintenance, reads are still available"))
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"matiks-leaderboard/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRejectDuringMaintenance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	maintenance := true
+	r := gin.New()
+	r.Use(RejectDuringMaintenance(func() bool { return maintenance }))
+	r.GET("/users", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.PUT("/users/1/score", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := serve(http.MethodPut, "/users/1/score")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("write in maintenance: status %d, want 503", w.Code)
+	}
+	var body struct {
+		Error struct {
+			Code models.ErrorCode `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Code != models.CodeMaintenance {
+		t.Errorf("write in maintenance: body %s, want code %s", w.Body, models.CodeMaintenance)
+	}
+
+	if w := serve(http.MethodGet, "/users"); w.Code != http.StatusOK {
+		t.Errorf("read in maintenance: status %d, want 200", w.Code)
+	}
+
+	maintenance = false
+	if w := serve(http.MethodPut, "/users/1/score"); w.Code != http.StatusOK {
+		t.Errorf("write after maintenance: status %d, want 200", w.Code)
+	}
+}
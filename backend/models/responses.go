@@ -0,0 +1,109 @@
+package models
+
+// APIInfo is the body of the root path.
+type APIInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Docs    string `json:"docs"`
+}
+
+// HealthStatus is the liveness report. Status is ok or degraded, Database
+// up or down.
+type HealthStatus struct {
+	Status    string `json:"status"`
+	Database  string `json:"database"`
+	Timestamp string `json:"timestamp"`
+}
+
+// Readiness reports whether the service can take traffic.
+type Readiness struct {
+	Ready         bool   `json:"ready"`
+	Database      string `json:"database"`
+	SnapshotBuilt bool   `json:"snapshotBuilt"`
+}
+
+// MaintenanceState reports whether writes are frozen for maintenance.
+type MaintenanceState struct {
+	Maintenance bool `json:"maintenance"`
+}
+
+// BoardList lists the registered boards by ID.
+type BoardList struct {
+	Boards []string `json:"boards"`
+	Count  int      `json:"count"`
+}
+
+// EntryList is a list of leaderboard entries, such as the top or bottom N.
+type EntryList struct {
+	Entries []LeaderboardEntry `json:"entries"`
+	Count   int                `json:"count"`
+}
+
+// RankEntries lists the users holding Rank, more than one when tied.
+type RankEntries struct {
+	Entries []LeaderboardEntry `json:"entries"`
+	Count   int                `json:"count"`
+	Rank    int                `json:"rank"`
+}
+
+// RankRange is the slice of the leaderboard between ranks From and To.
+type RankRange struct {
+	Entries    []LeaderboardEntry `json:"entries"`
+	Count      int                `json:"count"`
+	From       int                `json:"from"`
+	To         int                `json:"to"`
+	TotalUsers int                `json:"totalUsers"`
+	RankMode   string             `json:"rankMode"`
+}
+
+// UserSearchResult is a page of prefix search results. Total counts every
+// match, not just this page.
+type UserSearchResult struct {
+	Users   []UserResponse `json:"users"`
+	Count   int            `json:"count"`
+	Total   int            `json:"total"`
+	Offset  int            `json:"offset"`
+	HasNext bool           `json:"hasNext"`
+}
+
+// FuzzySearchResult lists the closest fuzzy search matches.
+type FuzzySearchResult struct {
+	Users []FuzzyMatch `json:"users"`
+	Count int          `json:"count"`
+}
+
+// UserRanks answers a batch rank lookup, keyed by user ID. NotFound lists
+// the IDs that matched no ranked user.
+type UserRanks struct {
+	Users    map[string]UserResponse `json:"users"`
+	NotFound []string                `json:"notFound"`
+}
+
+// RankHistory lists a user's recorded ranks.
+type RankHistory struct {
+	History []RankHistoryEntry `json:"history"`
+	Count   int                `json:"count"`
+}
+
+// UserResult wraps the user returned by a single-user write.
+type UserResult struct {
+	User *UserResponse `json:"user"`
+}
+
+// ScoreResult wraps the outcome of a score write.
+type ScoreResult struct {
+	User *ScoreUpdateResponse `json:"user"`
+}
+
+// BatchCreateResult reports a batch create, one result per requested user.
+type BatchCreateResult struct {
+	Results []BatchCreateItem `json:"results"`
+	Created int               `json:"created"`
+	Failed  int               `json:"failed"`
+}
+
+// SeasonLeaderboard is a page of an archived season's standings.
+type SeasonLeaderboard struct {
+	Season      string               `json:"season"`
+	Leaderboard *LeaderboardResponse `json:"leaderboard"`
+}
@@ -2,45 +2,409 @@
 // These models represent the core entities in the leaderboard system.
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 // User represents a player in the leaderboard system.
-// Stored in MongoDB with username and score fields.
+// Stored in MongoDB with username and score fields. Board is empty for
+// users of the default board.
 type User struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Username string             `bson:"username" json:"username"`
-	Score    int                `bson:"score" json:"score"`
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Username  string             `bson:"username" json:"username"`
+	Score     int                `bson:"score" json:"score"`
+	Board     string             `bson:"board,omitempty" json:"board,omitempty"`
+	Country   string             `bson:"country,omitempty" json:"country,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt,omitempty" json:"createdAt"`
+	// UpdatedAt is the last time the user's score changed.
+	UpdatedAt time.Time `bson:"updatedAt,omitempty" json:"updatedAt"`
+	// Active is false once a user is deactivated. Users stored before the
+	// field existed lack it and count as active, so queries match active
+	// users with {active: {$ne: false}} rather than {active: true}.
+	Active bool `bson:"active,omitempty" json:"active"`
+	// Components are the weighted stats a composite Score was derived
+	// from, when the user was scored that way.
+	Components map[string]float64 `bson:"components,omitempty" json:"components,omitempty"`
 }
 
 // UserResponse is the JSON response format for API endpoints.
-// Includes computed rank from the ranking engine.
+// Includes computed rank from the ranking engine. Match is only set on
+// search results.
 type UserResponse struct {
-	UserID   string `json:"userId"`
-	Username string `json:"username"`
-	Rating   int    `json:"rating"`
-	Rank     int    `json:"rank,omitempty"`
+	UserID      string      `json:"userId"`
+	Username    string      `json:"username"`
+	Rating      int         `json:"rating"`
+	Country     string      `json:"country,omitempty"`
+	Rank        int         `json:"rank,omitempty"`
+	TiedCount   int         `json:"tiedCount,omitempty"`
+	DisplayRank string      `json:"displayRank,omitempty"`
+	Match       *MatchRange `json:"match,omitempty"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	UpdatedAt   time.Time   `json:"updatedAt"`
+}
+
+// MatchRange is the part of a username a search matched, as character
+// offsets: Start inclusive, End exclusive.
+type MatchRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// FuzzyMatch is a fuzzy search result. Distance is the number of edits
+// between the query and the closest prefix of the username.
+type FuzzyMatch struct {
+	UserResponse
+	Distance int `json:"distance"`
+}
+
+// ScoreUpdateResponse is returned after a score change. RankDelta is
+// positive when the user climbed; both fields are 0 if previously unranked.
+// Changed is false when the update left the score as it was, either because
+// a conditional update lost or because the score was already the new one.
+type ScoreUpdateResponse struct {
+	UserResponse
+	PreviousRank int  `json:"previousRank"`
+	RankDelta    int  `json:"rankDelta"`
+	Changed      bool `json:"changed"`
 }
 
 // LeaderboardEntry represents a single entry in the leaderboard.
 // Includes rank computed from the snapshot manager.
+// TiedCount is how many users share the rating, and DisplayRank the rank as
+// shown to players ("T-5" when tied). Both are absent for archived seasons.
 type LeaderboardEntry struct {
-	UserID   string `json:"userId"`
-	Username string `json:"username"`
-	Rating   int    `json:"rating"`
-	Rank     int    `json:"rank"`
+	UserID      string `json:"userId"`
+	Username    string `json:"username"`
+	Country     string `json:"country,omitempty"`
+	Rating      int    `json:"rating"`
+	Rank        int    `json:"rank"`
+	TiedCount   int    `json:"tiedCount,omitempty"`
+	DisplayRank string `json:"displayRank,omitempty"`
+	// GlobalRank is set on regional leaderboards, where Rank counts only
+	// the region's users.
+	GlobalRank int `json:"globalRank,omitempty"`
+}
+
+// DisplayRank formats a rank for players, prefixing "T-" when tiedCount
+// users share it.
+func DisplayRank(rank, tiedCount int) string {
+	if tiedCount > 1 {
+		return "T-" + strconv.Itoa(rank)
+	}
+	return strconv.Itoa(rank)
 }
 
 // LeaderboardResponse is the paginated response for leaderboard queries.
 type LeaderboardResponse struct {
 	Entries    []LeaderboardEntry `json:"entries"`
+	Count      int                `json:"count"`
 	TotalUsers int                `json:"totalUsers"`
 	TotalPages int                `json:"totalPages"`
 	Page       int                `json:"page"`
+	HasNext    bool               `json:"hasNext"`
+	HasPrev    bool               `json:"hasPrev"`
+	RankMode   string             `json:"rankMode"`
+	Generation uint64             `json:"generation,omitempty"`
+	// MinScore and MaxScore are set when the page is a score band, in which
+	// case TotalUsers counts the band. BandRanks means ranks are counted
+	// from the top of the band rather than the whole board.
+	MinScore  *int `json:"minScore,omitempty"`
+	MaxScore  *int `json:"maxScore,omitempty"`
+	BandRanks bool `json:"bandRanks,omitempty"`
+	// Country is set when the page is a regional leaderboard, in which case
+	// TotalUsers counts that country's users.
+	Country string `json:"country,omitempty"`
+}
+
+// Mover is a user whose rank changed in the last rebuild. RankDelta is
+// positive when the user climbed. PreviousRank is 0 for newly ranked users.
+type Mover struct {
+	UserID       string `json:"userId"`
+	Username     string `json:"username"`
+	PreviousRank int    `json:"previousRank"`
+	Rank         int    `json:"rank"`
+	RankDelta    int    `json:"rankDelta"`
+}
+
+// MoversResponse lists the biggest rank changes of the last rebuild.
+type MoversResponse struct {
+	Climbers   []Mover `json:"climbers"`
+	Fallers    []Mover `json:"fallers"`
+	Entered    []Mover `json:"entered"`
+	Removed    int     `json:"removed"`
+	Window     int     `json:"window,omitempty"`
+	Generation uint64  `json:"generation"`
 }
 
 // BulkUpdateResult contains the results of a bulk update operation.
+// For a dry run, Updated is the number of users that would change and
+// Preview lists them.
 type BulkUpdateResult struct {
-	Updated       int     `json:"updated"`
-	DurationMs    int64   `json:"durationMs"`
-	UpdatesPerSec float64 `json:"updatesPerSec"`
+	Updated       int                 `json:"updated"`
+	DurationMs    int64               `json:"durationMs"`
+	UpdatesPerSec float64             `json:"updatesPerSec"`
+	DryRun        bool                `json:"dryRun,omitempty"`
+	Preview       []BulkUpdatePreview `json:"preview,omitempty"`
+}
+
+// BulkUpdatePreview is one user a dry-run bulk update would change, with
+// their current rank and rating.
+type BulkUpdatePreview struct {
+	UserID    string `json:"userId"`
+	Username  string `json:"username"`
+	Rank      int    `json:"rank,omitempty"`
+	Rating    int    `json:"rating"`
+	NewRating int    `json:"newRating"`
+}
+
+// BulkProgress is a progress event streamed during a bulk update.
+type BulkProgress struct {
+	Updated int `json:"updated"`
+	Total   int `json:"total"`
+}
+
+// RankHistoryEntry is a point-in-time record of a user's rank on a board.
+// Written to the rank_history collection whenever a rebuild changes the rank.
+type RankHistoryEntry struct {
+	Board     string    `bson:"board,omitempty" json:"-"`
+	UserID    string    `bson:"userId" json:"userId"`
+	Rank      int       `bson:"rank" json:"rank"`
+	Score     int       `bson:"score" json:"rating"`
+	Timestamp time.Time `bson:"timestamp" json:"timestamp"`
+}
+
+// RankCrossingEvent reports a user entering or leaving the top Threshold
+// ranks. Type is "entered" or "left"; a rank is 0 where the user wasn't
+// ranked.
+type RankCrossingEvent struct {
+	Type      string `json:"type"`
+	Threshold int    `json:"threshold"`
+	UserID    string `json:"userId"`
+	Username  string `json:"username,omitempty"`
+	OldRank   int    `json:"oldRank"`
+	NewRank   int    `json:"newRank"`
+}
+
+// RankWebhook is the body POSTed to WEBHOOK_URL after a rebuild moved users
+// across a threshold.
+type RankWebhook struct {
+	Board      string              `json:"board"`
+	Generation uint64              `json:"generation"`
+	Timestamp  time.Time           `json:"timestamp"`
+	Events     []RankCrossingEvent `json:"events"`
+}
+
+// RankPreview describes where a not-yet-created user with a given score would land.
+type RankPreview struct {
+	Rating     int                `json:"rating"`
+	Rank       int                `json:"rank"`
+	Tier       string             `json:"tier"`
+	Percentile float64            `json:"percentile"`
+	TotalUsers int                `json:"totalUsers"`
+	Neighbors  []LeaderboardEntry `json:"neighbors"`
+}
+
+// UserComparison puts two users side by side. ScoreDiff is User's score
+// minus Other's and RankDiff User's rank minus Other's, so a negative
+// RankDiff means User is ahead. RankDiff is null while either is unranked.
+type UserComparison struct {
+	User      UserResponse `json:"user"`
+	Other     UserResponse `json:"other"`
+	ScoreDiff int          `json:"scoreDiff"`
+	RankDiff  *int         `json:"rankDiff"`
+}
+
+// UserTier places a user in the percentile tier bands. TopPercent is the
+// rank as a share of the board for display, e.g. "Top 5%". Tier is empty
+// when the user falls below every configured band.
+type UserTier struct {
+	UserID     string  `json:"userId"`
+	Rank       int     `json:"rank"`
+	TotalUsers int     `json:"totalUsers"`
+	Percentile float64 `json:"percentile"`
+	TopPercent string  `json:"topPercent"`
+	Tier       string  `json:"tier,omitempty"`
+}
+
+// RankThreshold is the rating needed to reach a target rank. Rating is nil
+// when the board has fewer users than the rank, since any rating reaches it.
+// The user fields are only set when a user was given; Delta is how far their
+// rating is from the threshold, 0 once they have reached it.
+type RankThreshold struct {
+	Rank       int    `json:"rank"`
+	Rating     *int   `json:"rating"`
+	TotalUsers int    `json:"totalUsers"`
+	UserID     string `json:"userId,omitempty"`
+	UserRating *int   `json:"userRating,omitempty"`
+	UserRank   int    `json:"userRank,omitempty"`
+	Delta      *int   `json:"delta,omitempty"`
+	Reached    *bool  `json:"reached,omitempty"`
+}
+
+// NewUser is a single user to be created in a batch.
+type NewUser struct {
+	Username string
+	Score    int
+	Country  string
+}
+
+// BatchCreateItem reports the outcome of one user in a batch create.
+// Index refers to the position of the item in the request array.
+type BatchCreateItem struct {
+	Index    int           `json:"index"`
+	Username string        `json:"username"`
+	Success  bool          `json:"success"`
+	User     *UserResponse `json:"user,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// DeletedUsers reports a bulk delete. Deleting by prefix lists the IDs and
+// whether more users still match; deleting by score only counts them.
+type DeletedUsers struct {
+	Deleted []string `json:"deleted,omitempty"`
+	Count   int      `json:"count"`
+	More    bool     `json:"more"`
+}
+
+// ImportResult summarizes a bulk import. Rows whose username is already
+// taken are skipped; every other rejected row counts as failed.
+type ImportResult struct {
+	Inserted   int              `json:"inserted"`
+	Skipped    int              `json:"skipped"`
+	Failed     int              `json:"failed"`
+	Errors     []ImportRowError `json:"errors"`
+	DurationMs int64            `json:"durationMs"`
+}
+
+// ImportRowError describes why a single row was not imported.
+// Row is the line number for CSV and the 1-based element index for JSON.
+type ImportRowError struct {
+	Row      int    `json:"row"`
+	Username string `json:"username,omitempty"`
+	Error    string `json:"error"`
+}
+
+// SeasonEntry is one archived row of a board's final standings for a season.
+type SeasonEntry struct {
+	Board      string    `bson:"board,omitempty" json:"-"`
+	Season     string    `bson:"season" json:"season"`
+	Rank       int       `bson:"rank" json:"rank"`
+	UserID     string    `bson:"userId" json:"userId"`
+	Username   string    `bson:"username" json:"username"`
+	Score      int       `bson:"score" json:"rating"`
+	ArchivedAt time.Time `bson:"archivedAt" json:"archivedAt"`
+}
+
+// RolloverResult summarizes a season rollover.
+type RolloverResult struct {
+	Season     string `json:"season"`
+	Archived   int    `json:"archived"`
+	Reset      int    `json:"reset"`
+	Baseline   int    `json:"baseline"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// ReloadResult summarizes reloading the caches from MongoDB.
+type ReloadResult struct {
+	UsersBefore int `json:"usersBefore"`
+	UsersAfter  int `json:"usersAfter"`
+	// Rescored counts users whose composite score changed under the
+	// current SCORE_WEIGHTS.
+	Rescored   int   `json:"rescored"`
+	DurationMs int64 `json:"durationMs"`
+}
+
+// Reconciliation records the last comparison of a board's cache with MongoDB.
+// Drift counts the users present on only one side, and is only measured when
+// the totals disagree.
+type Reconciliation struct {
+	At         time.Time `json:"at"`
+	CacheUsers int       `json:"cacheUsers"`
+	DBUsers    int       `json:"dbUsers"`
+	Drift      int       `json:"drift"`
+	Repaired   bool      `json:"repaired"`
+}
+
+// ScoreBucket is one histogram bar of the score distribution.
+type ScoreBucket struct {
+	Min   int `json:"min"`
+	Max   int `json:"max"`
+	Count int `json:"count"`
+}
+
+// ScoreDistribution is the response for the distribution stats endpoint.
+type ScoreDistribution struct {
+	TotalUsers int           `json:"totalUsers"`
+	BucketSize int           `json:"bucketSize"`
+	Min        int           `json:"min"`
+	Max        int           `json:"max"`
+	Mean       float64       `json:"mean"`
+	Median     float64       `json:"median"`
+	P50        float64       `json:"p50"`
+	P90        int           `json:"p90"`
+	P99        int           `json:"p99"`
+	Buckets    []ScoreBucket `json:"buckets"`
+}
+
+// ScoreCounts is the response for the count-by-score endpoint. Total is
+// the number of users scoring from From through To.
+type ScoreCounts struct {
+	From       int           `json:"from"`
+	To         int           `json:"to"`
+	BucketSize int           `json:"bucketSize"`
+	Total      int           `json:"total"`
+	Buckets    []ScoreBucket `json:"buckets"`
+}
+
+// ScoreSummary is the response for the stats summary endpoint.
+type ScoreSummary struct {
+	TotalUsers      int `json:"totalUsers"`
+	Highest         int `json:"highest"`
+	Lowest          int `json:"lowest"`
+	MostCommon      int `json:"mostCommon"`
+	MostCommonCount int `json:"mostCommonCount"`
+	TiedAtTop       int `json:"tiedAtTop"`
+}
+
+// ErrorCode identifies an error for clients. Codes are stable; the
+// accompanying messages are for people and may change.
+type ErrorCode string
+
+const (
+	CodeValidation     ErrorCode = "VALIDATION_ERROR"
+	CodeBodyTooLarge   ErrorCode = "BODY_TOO_LARGE"
+	CodeUnauthorized   ErrorCode = "UNAUTHORIZED"
+	CodeForbidden      ErrorCode = "FORBIDDEN"
+	CodeBoardNotFound  ErrorCode = "BOARD_NOT_FOUND"
+	CodeUserNotFound   ErrorCode = "USER_NOT_FOUND"
+	CodeUserNotRanked  ErrorCode = "USER_NOT_RANKED"
+	CodeRankNotFound   ErrorCode = "RANK_NOT_FOUND"
+	CodeSeasonNotFound ErrorCode = "SEASON_NOT_FOUND"
+	CodeUsernameTaken  ErrorCode = "USERNAME_TAKEN"
+	CodeSeasonExists   ErrorCode = "SEASON_EXISTS"
+	CodeInProgress     ErrorCode = "IDEMPOTENCY_IN_PROGRESS"
+	CodeKeyReused      ErrorCode = "IDEMPOTENCY_KEY_REUSED"
+	CodeRateLimited    ErrorCode = "RATE_LIMITED"
+	CodeUnavailable    ErrorCode = "SERVICE_UNAVAILABLE"
+	CodeMaintenance    ErrorCode = "MAINTENANCE"
+	CodeTimeout        ErrorCode = "TIMEOUT"
+	CodeInternal       ErrorCode = "INTERNAL_ERROR"
+)
+
+// APIError is the error object of every failed API response.
+type APIError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// ErrorBody builds the {success: false, error: {code, message}} envelope
+// shared by handlers and middleware.
+func ErrorBody(code ErrorCode, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"success": false,
+		"error":   APIError{Code: code, Message: message},
+	}
 }
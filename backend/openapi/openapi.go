@@ -0,0 +1,498 @@
+// Package openapi builds an OpenAPI 3 document from the registered gin routes
+// and the Go types their handlers bind and return. Schemas are derived by
+// reflection from json and binding struct tags, so they can't drift from the
+// handlers the way a hand-written spec would.
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Version is the OpenAPI version the document conforms to.
+const Version = "3.0.3"
+
+// Object describes a JSON object built ad hoc, such as a gin.H response.
+// Each value is a sample whose type gives the property's schema; every
+// property is required.
+type Object map[string]interface{}
+
+// Param documents a path or query parameter. Type is a JSON schema type and
+// defaults to string.
+type Param struct {
+	Name        string
+	Type        string
+	Description string
+	Required    bool
+}
+
+// Operation annotates one handler. Request and Response are sample values
+// whose types describe the JSON body and the data in the success envelope.
+type Operation struct {
+	Summary string
+	Path    []Param
+	Query   []Param
+	Request interface{}
+	// RequestType replaces the JSON request body with a raw one of this
+	// content type.
+	RequestType string
+	Response    interface{}
+	// ResponseType replaces the JSON response with a raw one of this
+	// content type.
+	ResponseType string
+	// Unwrapped responses aren't enclosed in {"success": true, "data": ...}.
+	Unwrapped bool
+	// Status is the success status code; 0 means 200.
+	Status int
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+// PathItem maps lower-case HTTP methods to their operations.
+type PathItem map[string]*OperationObject
+
+type OperationObject struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// errorSchemaName is the component every operation's default response uses.
+const errorSchemaName = "ErrorResponse"
+
+// Build describes every route that has an operation, looked up by the bare
+// name of its handler (the last one in the chain). errorBody is a sample of
+// the body returned on failure. The routes without an operation are returned
+// as "METHOD /path" so callers can report the gaps.
+func Build(info Info, routes gin.RoutesInfo, ops map[string]Operation, errorBody interface{}) (*Document, []string) {
+	g := &generator{
+		schemas: make(map[string]*Schema),
+		types:   make(map[string]component),
+	}
+	doc := &Document{
+		OpenAPI:    Version,
+		Info:       info,
+		Paths:      make(map[string]PathItem),
+		Components: Components{Schemas: g.schemas},
+	}
+	g.schemas[errorSchemaName] = g.sample(errorBody, true)
+
+	// Sorted, so duplicate handlers get the same operationId suffix each run
+	sorted := append(gin.RoutesInfo(nil), routes...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Path != sorted[j].Path {
+			return sorted[i].Path < sorted[j].Path
+		}
+		return sorted[i].Method < sorted[j].Method
+	})
+
+	var undocumented []string
+	ids := make(map[string]int)
+	for _, route := range sorted {
+		name := handlerName(route.Handler)
+		op, ok := ops[name]
+		if !ok {
+			undocumented = append(undocumented, route.Method+" "+route.Path)
+			continue
+		}
+
+		id := lowerFirst(name)
+		if ids[id]++; ids[id] > 1 {
+			id += strconv.Itoa(ids[id])
+		}
+		path, params := g.pathParams(route.Path, op.Path)
+		operation := &OperationObject{
+			OperationID: id,
+			Summary:     op.Summary,
+			Parameters:  append(params, g.params("query", op.Query)...),
+			RequestBody: g.requestBody(op),
+			Responses: map[string]Response{
+				strconv.Itoa(statusOr200(op.Status)): g.response(op),
+				"default": {
+					Description: "Error",
+					Content:     jsonContent(&Schema{Ref: ref(errorSchemaName)}),
+				},
+			},
+		}
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(PathItem)
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = operation
+	}
+	return doc, undocumented
+}
+
+// handlerName strips the package path, closure suffixes and any method value
+// suffix from a gin handler name, so "matiks-leaderboard/handlers.GetTopN"
+// and "matiks-leaderboard/handlers.OpenAPI.func1" give GetTopN and OpenAPI.
+func handlerName(full string) string {
+	full = strings.TrimSuffix(full, "-fm")
+	for {
+		i := strings.LastIndex(full, ".")
+		if i < 0 {
+			return full
+		}
+		name := full[i+1:]
+		if !isClosure(name) {
+			return name
+		}
+		full = full[:i]
+	}
+}
+
+// isClosure reports whether name is a compiler-generated closure name such
+// as func1.
+func isClosure(name string) bool {
+	digits := strings.TrimPrefix(name, "func")
+	if digits == name || digits == "" {
+		return false
+	}
+	_, err := strconv.Atoi(digits)
+	return err == nil
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+func statusOr200(status int) int {
+	if status == 0 {
+		return http.StatusOK
+	}
+	return status
+}
+
+func ref(name string) string {
+	return "#/components/schemas/" + name
+}
+
+func jsonContent(s *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: s}}
+}
+
+// generator turns Go types into schemas, collecting named structs as
+// components.
+type generator struct {
+	schemas map[string]*Schema
+	types   map[string]component
+}
+
+// component is what a component schema was generated from. A struct gets
+// one component per direction it is used in, since the two mark different
+// fields required.
+type component struct {
+	t        reflect.Type
+	response bool
+}
+
+// pathParams rewrites gin's :name and *name segments to {name} and declares
+// each one, using the annotation for its name when there is one.
+func (g *generator) pathParams(path string, annotated []Param) (string, []Parameter) {
+	segments := strings.Split(path, "/")
+	var names []string
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			names = append(names, seg[1:])
+			segments[i] = "{" + seg[1:] + "}"
+		}
+	}
+
+	params := make([]Param, len(names))
+	for i, name := range names {
+		params[i] = Param{Name: name}
+		for _, p := range annotated {
+			if p.Name == name {
+				params[i] = p
+			}
+		}
+		// OpenAPI requires every path parameter to be marked required
+		params[i].Required = true
+	}
+	return strings.Join(segments, "/"), g.params("path", params)
+}
+
+func (g *generator) params(in string, params []Param) []Parameter {
+	result := make([]Parameter, 0, len(params))
+	for _, p := range params {
+		typ := p.Type
+		if typ == "" {
+			typ = "string"
+		}
+		result = append(result, Parameter{
+			Name:        p.Name,
+			In:          in,
+			Description: p.Description,
+			Required:    p.Required,
+			Schema:      &Schema{Type: typ},
+		})
+	}
+	return result
+}
+
+func (g *generator) requestBody(op Operation) *RequestBody {
+	switch {
+	case op.RequestType != "":
+		return &RequestBody{
+			Required: true,
+			Content:  map[string]MediaType{op.RequestType: {Schema: &Schema{Type: "string"}}},
+		}
+	case op.Request != nil:
+		return &RequestBody{
+			Required: true,
+			Content:  jsonContent(g.sample(op.Request, false)),
+		}
+	}
+	return nil
+}
+
+func (g *generator) response(op Operation) Response {
+	resp := Response{Description: http.StatusText(statusOr200(op.Status))}
+	switch {
+	case op.ResponseType != "":
+		resp.Content = map[string]MediaType{op.ResponseType: {Schema: &Schema{Type: "string"}}}
+	case op.Unwrapped:
+		if op.Response != nil {
+			resp.Content = jsonContent(g.sample(op.Response, true))
+		}
+	default:
+		envelope := &Schema{
+			Type: "object",
+			Properties: map[string]*Schema{
+				"success": {Type: "boolean"},
+			},
+			Required: []string{"success"},
+		}
+		if op.Response != nil {
+			envelope.Properties["data"] = g.sample(op.Response, true)
+			envelope.Required = append(envelope.Required, "data")
+		}
+		resp.Content = jsonContent(envelope)
+	}
+	return resp
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// sample describes the type of v, or for an Object the types of its values.
+func (g *generator) sample(v interface{}, response bool) *Schema {
+	obj, ok := v.(Object)
+	if !ok {
+		return g.schema(reflect.TypeOf(v), response)
+	}
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for name, value := range obj {
+		s.Properties[name] = g.sample(value, response)
+		s.Required = append(s.Required, name)
+	}
+	sort.Strings(s.Required)
+	return s
+}
+
+// schema describes t as encoding/json would marshal it. Response schemas
+// require every field without omitempty; request schemas only require fields
+// bound with binding:"required". A named struct used both ways gets a
+// component for each; see componentName.
+func (g *generator) schema(t reflect.Type, response bool) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		return &Schema{}
+	case t.Implements(textMarshalerType):
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schema(t.Elem(), response)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem(), response)}
+	case reflect.Struct:
+		return g.structSchema(t, response)
+	}
+	// Interfaces could hold anything
+	return &Schema{}
+}
+
+// structSchema registers a named struct as a component and refers to it;
+// anonymous structs are described inline.
+func (g *generator) structSchema(t reflect.Type, response bool) *Schema {
+	if t.Name() == "" {
+		return g.fields(t, response)
+	}
+
+	c := component{t: t, response: response}
+	name := componentName(t, response)
+	if seen, ok := g.types[name]; ok && seen != c {
+		// Same name in another package, or a type named *Request that is
+		// used both ways
+		name = t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:] + "." + name
+	}
+	if _, ok := g.types[name]; !ok {
+		g.types[name] = c
+		// Registered before the fields are walked, so recursive types
+		// refer back to themselves instead of looping
+		g.schemas[name] = &Schema{}
+		*g.schemas[name] = *g.fields(t, response)
+	}
+	return &Schema{Ref: ref(name)}
+}
+
+// componentName names the component for t. Response components take the
+// type's name; request components end in Request, so a struct used both ways
+// gets two.
+func componentName(t reflect.Type, response bool) string {
+	name := t.Name()
+	if response || strings.HasSuffix(name, "Request") {
+		return name
+	}
+	return name + "Request"
+}
+
+// fields describes a struct's exported fields, flattening embedded structs
+// the way encoding/json does.
+func (g *generator) fields(t reflect.Type, response bool) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	g.addFields(s, t, response)
+	sort.Strings(s.Required)
+	return s
+}
+
+func (g *generator) addFields(s *Schema, t reflect.Type, response bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			g.addFields(s, ft, response)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := g.schema(f.Type, response)
+		// Siblings of $ref are ignored, so only inline schemas take a doc
+		if doc := f.Tag.Get("doc"); doc != "" && prop.Ref == "" {
+			prop.Description = doc
+		}
+		s.Properties[name] = prop
+
+		var required bool
+		if response {
+			required = !strings.Contains(","+opts+",", ",omitempty,")
+		} else {
+			required = hasRule(f.Tag.Get("binding"), "required")
+		}
+		if required {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// hasRule reports whether a validator tag lists rule.
+func hasRule(tag, rule string) bool {
+	for _, r := range strings.Split(tag, ",") {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
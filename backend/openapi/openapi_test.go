@@ -0,0 +1,42 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type profile struct {
+	Name  string `json:"name" binding:"required"`
+	Bio   string `json:"bio,omitempty"`
+	Score int    `json:"score"`
+}
+
+func TestStructUsedBothWaysGetsTwoComponents(t *testing.T) {
+	routes := gin.RoutesInfo{{Method: http.MethodPut, Path: "/profile", Handler: "app.UpdateProfile"}}
+	ops := map[string]Operation{
+		"UpdateProfile": {Request: profile{}, Response: profile{}},
+	}
+	doc, undocumented := Build(Info{}, routes, ops, Object{"success": false})
+	if len(undocumented) > 0 {
+		t.Fatalf("undocumented routes: %v", undocumented)
+	}
+
+	put := doc.Paths["/profile"]["put"]
+	if got := put.RequestBody.Content["application/json"].Schema.Ref; got != ref("profileRequest") {
+		t.Errorf("request body refers to %q, want the request component", got)
+	}
+	data := put.Responses["200"].Content["application/json"].Schema.Properties["data"]
+	if data.Ref != ref("profile") {
+		t.Errorf("response data refers to %q, want the response component", data.Ref)
+	}
+
+	if got, want := doc.Components.Schemas["profileRequest"].Required, []string{"name"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("request component requires %v, want %v", got, want)
+	}
+	if got, want := doc.Components.Schemas["profile"].Required, []string{"name", "score"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("response component requires %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+// Package services deactivates users without deleting their records.
+package services
+
+import (
+	"context"
+	"log/slog"
+
+	"matiks-leaderboard/cache"
+	"matiks-leaderboard/database"
+	"matiks-leaderboard/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// activeUsers matches users that haven't been deactivated, including those
+// stored before the active field existed. Never modify it; filter copies it.
+var activeUsers = bson.M{"active": bson.M{"$ne": false}}
+
+// activeUser matches one active user of this board, plus any extra
+// conditions. Writes use it so a deactivated user can't be updated back
+// into the cache.
+func (b *Board) activeUser(objID primitive.ObjectID, extra bson.M) bson.M {
+	f := b.filter(extra)
+	f["_id"] = objID
+	f["active"] = activeUsers["active"]
+	return f
+}
+
+// Deactivate hides a user from the leaderboard while keeping their record.
+// The user leaves the cache and the snapshot is rebuilt before it returns,
+// so their rank reads as 0 straight away. Deactivating twice is harmless.
+func (b *Board) Deactivate(ctx context.Context, userID string) (*models.UserResponse, error) {
+	user, err := b.setActive(ctx, userID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	b.cache.Delete(userID)
+	b.ForceRebuild()
+	slog.Info("user deactivated", "board", b.ID, "user_id", userID)
+
+	return &models.UserResponse{
+		UserID:    userID,
+		Username:  user.Username,
+		Rating:    user.Score,
+		Country:   user.Country,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}, nil
+}
+
+// Reactivate restores a deactivated user, reloading them from MongoDB into
+// the cache and rebuilding so they are ranked again when it returns.
+func (b *Board) Reactivate(ctx context.Context, userID string) (*models.UserResponse, error) {
+	user, err := b.setActive(ctx, userID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := cache.Entry{
+		Username:  user.Username,
+		Score:     user.Score,
+		Country:   user.Country,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}
+	b.cache.Set(userID, entry)
+	b.ForceRebuild()
+	slog.Info("user reactivated", "board", b.ID, "user_id", userID)
+
+	response := b.userResponse(userID, entry)
+	return &response, nil
+}
+
+// setActive stores the user's active flag and returns the updated document.
+func (b *Board) setActive(ctx context.Context, userID string, active bool) (*models.User, error) {
+	objID, err := parseUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	err = withRetry(ctx, func(ctx context.Context) error {
+		return database.Collection("users").FindOneAndUpdate(
+			ctx,
+			b.filter(bson.M{"_id": objID}),
+			bson.M{"$set": bson.M{"active": active}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&user)
+	})
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
@@ -0,0 +1,371 @@
+// Package services contains the board registry and per-board rebuild loop.
+package services
+
+import (
+	"log/slog"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"matiks-leaderboard/cache"
+	"matiks-leaderboard/engine"
+	"matiks-leaderboard/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DefaultBoardID is the board served by the un-prefixed /api routes.
+// Its users are stored without a board field in MongoDB.
+const DefaultBoardID = "default"
+
+var boardIDPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// NewBoardStore creates the cache for a non-default board. The default board
+// always uses cache.Global. Replaced at startup when Redis is configured.
+var NewBoardStore = func(boardID string) cache.Store {
+	return cache.NewUserCache()
+}
+
+// Stats counts updates and rebuilds for a board. mu also guards every change
+// to Board.pendingUpdates, so a reader holding it sees pending and total
+// updates that agree with each other.
+type Stats struct {
+	mu                sync.RWMutex
+	TotalUpdates      int64
+	RebuildsTriggered int64
+	// RebuiltUpdates sums the pending updates each triggered rebuild took
+	// in. It moves together with RebuildsTriggered, so the two always
+	// describe the same rebuilds, unlike TotalUpdates, which also counts
+	// updates still pending or taken in by a forced rebuild.
+	RebuiltUpdates int64
+	LastRebuild    time.Time
+	LastReconcile  *models.Reconciliation
+}
+
+// AvgUpdatesPerRebuild is how many updates a triggered rebuild took in on
+// average. Caller must hold s.mu.
+func (s *Stats) AvgUpdatesPerRebuild() float64 {
+	if s.RebuildsTriggered == 0 {
+		return 0
+	}
+	return float64(s.RebuiltUpdates) / float64(s.RebuildsTriggered)
+}
+
+// Board is an independent leaderboard with its own cache, snapshot and
+// debounced rebuild loop. Updates to one board never rebuild another.
+type Board struct {
+	ID       string
+	cache    cache.Store
+	snapshot *engine.Snapshot
+
+	stats           Stats
+	pendingUpdates  atomic.Int64
+	rebuildSignal   chan struct{}
+	forceRebuild    chan chan struct{}
+	rebuildLoopOnce sync.Once
+
+	// rolloverMu serializes season rollovers on this board.
+	rolloverMu sync.Mutex
+
+	// top is the encoded top of the latest snapshot, see CachedTopN.
+	top atomic.Pointer[topCache]
+}
+
+var (
+	boardsMu sync.RWMutex
+	boards   = make(map[string]*Board)
+)
+
+// rebuildSlots bounds how many boards rebuild at once. Every board keeps its
+// own debounce and holds at most one slot at a time, releasing it between
+// rebuilds, so a burst on one board can't starve the others.
+var rebuildSlots = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+func newBoard(id string, store cache.Store, snapshot *engine.Snapshot) *Board {
+	return &Board{
+		ID:            id,
+		cache:         store,
+		snapshot:      snapshot,
+		rebuildSignal: make(chan struct{}, 1),
+		forceRebuild:  make(chan chan struct{}),
+	}
+}
+
+// ensureBoard returns the board with the given ID, registering it if needed.
+func ensureBoard(id string) *Board {
+	boardsMu.Lock()
+	defer boardsMu.Unlock()
+
+	if b, ok := boards[id]; ok {
+		return b
+	}
+	b := buildBoard(id)
+	boards[id] = b
+	return b
+}
+
+// registerBoard adds b unless a board with its ID is already registered,
+// returning whichever board holds the ID.
+func registerBoard(b *Board) *Board {
+	boardsMu.Lock()
+	defer boardsMu.Unlock()
+
+	if cur, ok := boards[b.ID]; ok {
+		return cur
+	}
+	boards[b.ID] = b
+	return b
+}
+
+// buildBoard creates board id without registering it.
+func buildBoard(id string) *Board {
+	if id == DefaultBoardID {
+		return newBoard(id, cache.Global, engine.Global)
+	}
+	snapshot := &engine.Snapshot{}
+	snapshot.SetAscending(engine.Global.Ascending())
+	snapshot.SetTieBreak(engine.Global.TieBreak())
+	return newBoard(id, NewBoardStore(id), snapshot)
+}
+
+// GetBoard looks up a registered board.
+func GetBoard(id string) (*Board, bool) {
+	boardsMu.RLock()
+	defer boardsMu.RUnlock()
+	b, ok := boards[id]
+	return b, ok
+}
+
+// DefaultBoard returns the board served by the un-prefixed routes.
+func DefaultBoard() *Board {
+	return ensureBoard(DefaultBoardID)
+}
+
+// Boards returns every registered board ordered by ID.
+func Boards() []*Board {
+	boardsMu.RLock()
+	defer boardsMu.RUnlock()
+
+	result := make([]*Board, 0, len(boards))
+	for _, b := range boards {
+		result = append(result, b)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// Ready reports whether every registered board has built its snapshot.
+func Ready() bool {
+	all := Boards()
+	if len(all) == 0 {
+		return false
+	}
+	for _, b := range all {
+		if !b.snapshot.Built() {
+			return false
+		}
+	}
+	return true
+}
+
+// parseBoardIDs splits the BOARDS env value, skipping malformed IDs.
+func parseBoardIDs(raw string) []string {
+	var ids []string
+	for _, id := range strings.Split(raw, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if !boardIDPattern.MatchString(id) {
+			slog.Warn("ignoring invalid board id", "board", id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// storedBoard is the value written to a user's board field in MongoDB.
+func (b *Board) storedBoard() string {
+	if b.ID == DefaultBoardID {
+		return ""
+	}
+	return b.ID
+}
+
+// filter returns a MongoDB filter scoped to this board's users.
+func (b *Board) filter(extra bson.M) bson.M {
+	f := bson.M{}
+	for k, v := range extra {
+		f[k] = v
+	}
+	if b.ID == DefaultBoardID {
+		// Matches documents where the field is missing or null
+		f["board"] = nil
+	} else {
+		f["board"] = b.ID
+	}
+	return f
+}
+
+// scheduleRebuild records an update and wakes the rebuild loop.
+// Signals coalesce, so bursts of updates never queue up rebuilds.
+func (b *Board) scheduleRebuild() {
+	b.startRebuildLoop()
+
+	b.stats.mu.Lock()
+	b.pendingUpdates.Add(1)
+	b.stats.TotalUpdates++
+	b.stats.mu.Unlock()
+
+	select {
+	case b.rebuildSignal <- struct{}{}:
+	default:
+	}
+}
+
+func (b *Board) startRebuildLoop() {
+	b.rebuildLoopOnce.Do(func() {
+		go b.rebuildLoop()
+	})
+}
+
+// rebuildLoop is the single owner of snapshot rebuilds, so rebuilds never
+// overlap. Updates are debounced by rebuildDelay, but a rebuild is never
+// postponed past maxRebuildDelay. Forced rebuilds that queue up behind one
+// another are answered by a single rebuild.
+func (b *Board) rebuildLoop() {
+	var (
+		timer       = time.NewTimer(0)
+		timerC      <-chan time.Time
+		lastRebuild = time.Now()
+		// Read once; LoadDebounceConfig runs before any board starts
+		delay, maxDelay = rebuildDelay, maxRebuildDelay
+	)
+	if !timer.Stop() {
+		<-timer.C
+	}
+
+	stopTimer := func() {
+		if timerC != nil && !timer.Stop() {
+			<-timer.C
+		}
+		timerC = nil
+	}
+
+	for {
+		select {
+		case <-b.rebuildSignal:
+			if time.Since(lastRebuild) >= maxDelay {
+				stopTimer()
+				b.executeRebuild()
+				lastRebuild = time.Now()
+				continue
+			}
+			stopTimer()
+			timer.Reset(delay)
+			timerC = timer.C
+
+		case <-timerC:
+			timerC = nil
+			b.executeRebuild()
+			lastRebuild = time.Now()
+
+		case done := <-b.forceRebuild:
+			// Every caller already waiting made its cache writes before this
+			// rebuild reads the cache, so one rebuild answers them all
+			waiting := []chan struct{}{done}
+			for drained := false; !drained; {
+				select {
+				case next := <-b.forceRebuild:
+					waiting = append(waiting, next)
+				default:
+					drained = true
+				}
+			}
+
+			stopTimer()
+			b.stats.mu.Lock()
+			b.pendingUpdates.Store(0)
+			b.stats.mu.Unlock()
+			b.rebuildSnapshot()
+			lastRebuild = time.Now()
+			for _, w := range waiting {
+				close(w)
+			}
+		}
+	}
+}
+
+func (b *Board) executeRebuild() {
+	b.stats.mu.Lock()
+	count := b.pendingUpdates.Swap(0)
+	if count == 0 {
+		b.stats.mu.Unlock()
+		return
+	}
+	b.stats.RebuildsTriggered++
+	b.stats.RebuiltUpdates += count
+	b.stats.mu.Unlock()
+
+	start := time.Now()
+	b.rebuildSnapshot()
+	slog.Info("snapshot rebuilt",
+		"board", b.ID,
+		"pending", count,
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+}
+
+// ForceRebuild rebuilds the snapshot immediately, discarding any pending
+// debounce, and returns once the new snapshot is live.
+func (b *Board) ForceRebuild() {
+	b.startRebuildLoop()
+
+	done := make(chan struct{})
+	b.forceRebuild <- done
+	<-done
+}
+
+// rebuildSnapshot rebuilds the ranking engine from the cache and, when
+// history is enabled, records every rank that changed. Threshold crossings
+// are then queued for the webhook. It waits for a free rebuild slot first.
+// If the cache can't be read in full, the previous snapshot stays live until
+// the next rebuild.
+func (b *Board) rebuildSnapshot() {
+	// LoadDebounceConfig may swap the pool; release to the one acquired from
+	slots := rebuildSlots
+	slots <- struct{}{}
+	defer func() { <-slots }()
+
+	var prev map[string]int
+	if historyEnabled {
+		prev = b.snapshot.RankIndex()
+	}
+	if err := b.snapshot.RebuildFrom(b.cache.Range); err != nil {
+		slog.Error("snapshot rebuild failed, keeping the previous snapshot", "board", b.ID, "error", err)
+		return
+	}
+	defer b.markRebuilt()
+	defer b.notifyCrossings()
+	defer b.warmTopCache()
+
+	if !historyEnabled {
+		return
+	}
+	if changes := b.rankChanges(prev, b.topEntries(b.snapshot.Size())); len(changes) > 0 {
+		go writeHistory(changes)
+	}
+}
+
+func (b *Board) markRebuilt() {
+	b.stats.mu.Lock()
+	b.stats.LastRebuild = time.Now()
+	b.stats.mu.Unlock()
+}
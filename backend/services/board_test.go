@@ -0,0 +1,314 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"matiks-leaderboard/cache"
+	"matiks-leaderboard/engine"
+	"matiks-leaderboard/models"
+)
+
+// newTestBoard returns an unregistered board holding n users, with its
+// first snapshot built.
+func newTestBoard(t testing.TB, n int) *Board {
+	t.Helper()
+	b := newBoard("test", cache.NewUserCache(), &engine.Snapshot{})
+	for i := 0; i < n; i++ {
+		b.cache.Set(strconv.Itoa(i), cache.Entry{Username: "user" + strconv.Itoa(i), Score: 100 + i})
+	}
+	b.ForceRebuild()
+	return b
+}
+
+// setDebounce changes the rebuild delays for the rest of the test.
+func setDebounce(t testing.TB, delay, maxDelay time.Duration) {
+	t.Helper()
+	oldDelay, oldMax := rebuildDelay, maxRebuildDelay
+	rebuildDelay, maxRebuildDelay = delay, maxDelay
+	t.Cleanup(func() { rebuildDelay, maxRebuildDelay = oldDelay, oldMax })
+}
+
+// waitRebuilt waits until the board has no updates pending.
+func waitRebuilt(t testing.TB, b *Board) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for b.pendingUpdates.Load() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d updates still pending after 5s", b.pendingUpdates.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestScheduleRebuildBurstIsBounded(t *testing.T) {
+	setDebounce(t, 20*time.Millisecond, 100*time.Millisecond)
+	b := newTestBoard(t, 100)
+	startGeneration := b.snapshot.Generation()
+
+	const workers, perWorker = 100, 100
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				b.scheduleRebuild()
+			}
+		}()
+	}
+	wg.Wait()
+	burst := time.Since(start)
+	waitRebuilt(t, b)
+
+	b.stats.mu.RLock()
+	rebuilds, rebuilt := b.stats.RebuildsTriggered, b.stats.RebuiltUpdates
+	b.stats.mu.RUnlock()
+
+	if rebuilt != workers*perWorker {
+		t.Errorf("rebuilds took in %d updates, want %d", rebuilt, workers*perWorker)
+	}
+	// During the burst the loop can only rebuild once per maxRebuildDelay;
+	// one more follows the last update
+	limit := int64(burst/maxRebuildDelay) + 2
+	if rebuilds < 1 || rebuilds > limit {
+		t.Errorf("%d updates over %v triggered %d rebuilds, want 1 to %d", workers*perWorker, burst, rebuilds, limit)
+	}
+	if got := b.snapshot.Generation() - startGeneration; got != uint64(rebuilds) {
+		t.Errorf("snapshot rebuilt %d times for %d triggered rebuilds", got, rebuilds)
+	}
+}
+
+// Run with -race: GetStats must read pending and total updates
+// consistently while updates keep arriving.
+func TestStatsConsistentDuringUpdates(t *testing.T) {
+	users := make([]models.User, 20)
+	for i := range users {
+		users[i] = testUser("user"+strconv.Itoa(i), 100+i)
+	}
+	b, _ := loadUsers(t, users...)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := range users {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := b.UpdateScore(context.Background(), id, minScore+n%1000); err != nil {
+					t.Errorf("UpdateScore: %v", err)
+					return
+				}
+			}
+		}(users[w].ID.Hex())
+	}
+
+	first := b.GetStats()["totalUpdates"].(int64)
+	last := first
+	for deadline := time.Now().Add(5 * time.Second); last-first < 500 && time.Now().Before(deadline); {
+		stats := b.GetStats()
+		pending, total := stats["pendingUpdates"].(int64), stats["totalUpdates"].(int64)
+		if pending < 0 || pending > total {
+			t.Errorf("pendingUpdates %d outside 0..totalUpdates %d", pending, total)
+			break
+		}
+		if total < last {
+			t.Errorf("totalUpdates went back from %d to %d", last, total)
+			break
+		}
+		last = total
+	}
+	close(stop)
+	wg.Wait()
+
+	if last-first < 500 {
+		t.Errorf("stats saw %d updates in 5s, want 500", last-first)
+	}
+}
+
+func TestConcurrentForceRebuildsShareOneRebuild(t *testing.T) {
+	b := newTestBoard(t, 10)
+	start := b.snapshot.Generation()
+
+	// Hold every rebuild slot so the first forced rebuild blocks while the
+	// others queue up behind it
+	slots := rebuildSlots
+	for i := 0; i < cap(slots); i++ {
+		slots <- struct{}{}
+	}
+	first := make(chan struct{})
+	go func() {
+		b.ForceRebuild()
+		close(first)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	const callers = 50
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.ForceRebuild()
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < cap(slots); i++ {
+		<-slots
+	}
+
+	all := make(chan struct{})
+	go func() {
+		<-first
+		wg.Wait()
+		close(all)
+	}()
+	select {
+	case <-all:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ForceRebuild callers still waiting after 5s")
+	}
+
+	// One rebuild for the first caller, one shared by everyone queued
+	if got := b.snapshot.Generation() - start; got != 2 {
+		t.Errorf("%d queued ForceRebuild calls ran %d rebuilds, want 2", callers+1, got)
+	}
+}
+
+func TestAvgUpdatesPerRebuild(t *testing.T) {
+	// Long delays so only the test decides when rebuilds happen
+	setDebounce(t, time.Hour, time.Hour)
+	b := newTestBoard(t, 10)
+
+	schedule := func(n int) {
+		for i := 0; i < n; i++ {
+			b.scheduleRebuild()
+		}
+	}
+	schedule(3)
+	b.executeRebuild()
+	schedule(5)
+	b.executeRebuild()
+	// Updates taken in by a forced rebuild or still pending aren't part
+	// of any triggered rebuild
+	schedule(2)
+	b.ForceRebuild()
+	schedule(1)
+
+	stats := b.GetStats()
+	if got := stats["rebuildsTriggered"]; got != int64(2) {
+		t.Errorf("rebuildsTriggered = %v, want 2", got)
+	}
+	if got := stats["totalUpdates"]; got != int64(11) {
+		t.Errorf("totalUpdates = %v, want 11", got)
+	}
+	if got := stats["pendingUpdates"]; got != int64(1) {
+		t.Errorf("pendingUpdates = %v, want 1", got)
+	}
+	if got := stats["avgUpdatesPerRebuild"]; got != 4.0 {
+		t.Errorf("avgUpdatesPerRebuild = %v, want 4", got)
+	}
+}
+
+// failingRange is a store whose Range stops early with an error while fail
+// is set.
+type failingRange struct {
+	*cache.UserCache
+	fail bool
+}
+
+func (s *failingRange) Range(fn func(id string, e cache.Entry)) error {
+	if s.fail {
+		return errors.New("scan interrupted")
+	}
+	return s.UserCache.Range(fn)
+}
+
+func TestFailedRangeKeepsPreviousSnapshot(t *testing.T) {
+	store := &failingRange{UserCache: cache.NewUserCache()}
+	b := newBoard("test", store, &engine.Snapshot{})
+	store.Set("1", cache.Entry{Username: "alice", Score: 300})
+	b.ForceRebuild()
+	generation := b.snapshot.Generation()
+
+	store.Set("2", cache.Entry{Username: "bob", Score: 400})
+	store.fail = true
+	b.ForceRebuild()
+	if got := b.snapshot.Generation(); got != generation {
+		t.Errorf("generation moved from %d to %d on a failed rebuild", generation, got)
+	}
+	if top := b.GetTopN(10); len(top) != 1 || top[0].Username != "alice" {
+		t.Errorf("snapshot after failed rebuild = %+v, want only alice", top)
+	}
+
+	store.fail = false
+	b.ForceRebuild()
+	if top := b.GetTopN(10); len(top) != 2 || top[0].Username != "bob" {
+		t.Errorf("snapshot after recovery = %+v, want bob then alice", top)
+	}
+}
+
+// blockingRange is a store whose Range waits for release once started is
+// closed.
+type blockingRange struct {
+	*cache.UserCache
+	started, release chan struct{}
+}
+
+func (s *blockingRange) Range(fn func(id string, e cache.Entry)) error {
+	close(s.started)
+	<-s.release
+	return s.UserCache.Range(fn)
+}
+
+func TestRebuildReleasesTheSlotItTook(t *testing.T) {
+	t.Setenv("REBUILD_WORKERS", "1")
+	LoadDebounceConfig()
+	t.Cleanup(LoadDebounceConfig)
+
+	store := &blockingRange{UserCache: cache.NewUserCache(), started: make(chan struct{}), release: make(chan struct{})}
+	store.Set("1", cache.Entry{Username: "alice", Score: 300})
+	blocked := newBoard("blocked", store, &engine.Snapshot{})
+	other := newTestBoard(t, 0)
+	other.cache.Set("2", cache.Entry{Username: "bob", Score: 400})
+
+	first := make(chan struct{})
+	go func() {
+		blocked.ForceRebuild()
+		close(first)
+	}()
+	<-store.started
+
+	// Swap the pool while the blocked board holds a slot of the old one
+	LoadDebounceConfig()
+	second := make(chan struct{})
+	go func() {
+		other.ForceRebuild()
+		close(second)
+	}()
+	close(store.release)
+
+	for name, done := range map[string]chan struct{}{"blocked": first, "other": second} {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s board's rebuild never finished", name)
+		}
+	}
+	if top := other.GetTopN(1); len(top) != 1 || top[0].Username != "bob" {
+		t.Errorf("other board = %+v, want bob", top)
+	}
+	if top := blocked.GetTopN(1); len(top) != 1 || top[0].Username != "alice" {
+		t.Errorf("blocked board = %+v, want alice", top)
+	}
+}
@@ -0,0 +1,231 @@
+// Package services derives composite scores from weighted stat components.
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"matiks-leaderboard/cache"
+	"matiks-leaderboard/database"
+	"matiks-leaderboard/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// scoreWeights maps each component a client may submit to its weight.
+	// Nil means composite scoring is off.
+	scoreWeights map[string]float64
+	// scoreBase is added to every composite score before rounding.
+	scoreBase float64
+)
+
+// LoadCompositeConfig reads SCORE_WEIGHTS, a comma-separated list of
+// name=weight pairs such as wins=10,accuracy=2.5, and SCORE_BASE, a constant
+// added to every composite score. A malformed SCORE_WEIGHTS leaves composite
+// scoring off. Changing either takes effect for stored users on the next
+// admin rebuild.
+func LoadCompositeConfig() {
+	raw := os.Getenv("SCORE_WEIGHTS")
+	if raw == "" {
+		return
+	}
+	weights, err := parseScoreWeights(raw)
+	if err != nil {
+		slog.Warn("invalid SCORE_WEIGHTS, composite scoring disabled", "error", err)
+		return
+	}
+	base := 0.0
+	if v := os.Getenv("SCORE_BASE"); v != "" {
+		if base, err = strconv.ParseFloat(v, 64); err != nil || math.IsNaN(base) || math.IsInf(base, 0) {
+			slog.Warn("invalid SCORE_BASE, composite scoring disabled", "value", v)
+			return
+		}
+	}
+	scoreWeights, scoreBase = weights, base
+	slog.Info("composite scoring configured", "weights", raw, "base", base)
+}
+
+func parseScoreWeights(raw string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, pair := range strings.Split(raw, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q is not name=weight", pair)
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return nil, fmt.Errorf("weight of %q is not a number", name)
+		}
+		if _, dup := weights[name]; dup {
+			return nil, fmt.Errorf("%q is weighted twice", name)
+		}
+		weights[name] = weight
+	}
+	return weights, nil
+}
+
+// compositeScore weighs the components into a score, rounded and clamped to
+// the valid score range. Missing components count as zero; unknown ones are
+// rejected so a typo can't silently score nothing.
+func compositeScore(components map[string]float64) (int, error) {
+	if scoreWeights == nil {
+		return 0, &ValidationError{"composite scoring is not enabled; set SCORE_WEIGHTS"}
+	}
+
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	// Sorted so the sum, and any error, is the same on every call
+	sort.Strings(names)
+
+	total := scoreBase
+	for _, name := range names {
+		weight, ok := scoreWeights[name]
+		if !ok {
+			return 0, &ValidationError{"unknown score component " + strconv.Quote(name)}
+		}
+		value := components[name]
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return 0, &ValidationError{"score component " + strconv.Quote(name) + " must be a finite number"}
+		}
+		total += weight * value
+	}
+	return int(math.Max(minScore, math.Min(maxScore, math.Round(total)))), nil
+}
+
+// UpdateComponents replaces a user's score components and sets their score
+// to the composite of them. Like UpdateScore, an unchanged score writes the
+// components but schedules no rebuild.
+func (b *Board) UpdateComponents(ctx context.Context, userID string, components map[string]float64) (*models.ScoreUpdateResponse, error) {
+	if len(components) == 0 {
+		return nil, &ValidationError{"components are required"}
+	}
+	score, err := compositeScore(components)
+	if err != nil {
+		return nil, err
+	}
+	objID, err := parseUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	previousRank := b.snapshot.GetRank(userID)
+	cached, known := b.cache.Get(userID)
+	changed := !known || cached.Score != score
+
+	now := time.Now()
+	set := bson.M{"components": components}
+	if changed {
+		set["score"] = score
+		set["updatedAt"] = now
+	}
+	var user models.User
+	err = withRetry(ctx, func(ctx context.Context) error {
+		return database.Collection("users").FindOneAndUpdate(
+			ctx,
+			b.activeUser(objID, nil),
+			bson.M{"$set": set},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&user)
+	})
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	entry := cache.Entry{Username: user.Username, Score: user.Score, Country: user.Country, CreatedAt: user.CreatedAt, UpdatedAt: user.UpdatedAt}
+	if !changed {
+		return b.scoreUpdateResponse(userID, entry, previousRank), nil
+	}
+	b.cache.Set(userID, entry)
+	b.scheduleRebuild()
+
+	response := b.scoreUpdateResponse(userID, entry, previousRank)
+	response.Changed = true
+	return response, nil
+}
+
+// rescoreComposites recomputes the score of every user with components
+// under the current weights and writes the ones that changed, so a new
+// formula reaches stored users. It doesn't touch the caches; Reload follows
+// it with a full load. Returns the number of users rescored, which counts
+// only the writes that succeeded.
+func rescoreComposites(ctx context.Context) (int, error) {
+	if scoreWeights == nil {
+		return 0, nil
+	}
+
+	collection := database.Collection("users")
+	findCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cursor, err := collection.Find(findCtx,
+		bson.M{"components": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"score": 1, "components": 1}),
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(findCtx)
+
+	now := time.Now()
+	var writes []mongo.WriteModel
+	rescored := 0
+	flush := func() error {
+		if len(writes) == 0 {
+			return nil
+		}
+		err := withRetry(ctx, func(ctx context.Context) error {
+			_, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+			return err
+		})
+		rescored += len(writes) - len(writeFailures(err, len(writes)))
+		writes = writes[:0]
+		return err
+	}
+
+	for cursor.Next(findCtx) {
+		var doc struct {
+			ID         primitive.ObjectID `bson:"_id"`
+			Score      int                `bson:"score"`
+			Components map[string]float64 `bson:"components"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			slog.Warn("skipping undecodable user while rescoring", "error", err)
+			continue
+		}
+		score, err := compositeScore(doc.Components)
+		if err != nil {
+			// Components the new formula no longer weighs keep their score
+			slog.Warn("cannot rescore user", "user_id", doc.ID.Hex(), "error", err)
+			continue
+		}
+		if score == doc.Score {
+			continue
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": doc.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"score": score, "updatedAt": now}}))
+		if len(writes) == bulkWriteBatchSize {
+			if err := flush(); err != nil {
+				return rescored, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return rescored, err
+	}
+	err = flush()
+	return rescored, err
+}
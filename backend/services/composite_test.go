@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"matiks-leaderboard/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// setWeights turns on composite scoring for the rest of the test.
+func setWeights(t testing.TB, weights map[string]float64) {
+	t.Helper()
+	oldWeights, oldBase := scoreWeights, scoreBase
+	scoreWeights, scoreBase = weights, 0
+	t.Cleanup(func() { scoreWeights, scoreBase = oldWeights, oldBase })
+}
+
+func compositeUser(name string, wins float64) models.User {
+	u := testUser(name, int(wins*10))
+	u.Components = map[string]float64{"wins": wins}
+	return u
+}
+
+func TestDirectScoreWritesDropComponents(t *testing.T) {
+	setWeights(t, map[string]float64{"wins": 10})
+	users := []models.User{compositeUser("alice", 50), compositeUser("bob", 50), compositeUser("carol", 50)}
+	b, coll := loadUsers(t, users...)
+	ctx := context.Background()
+
+	if _, err := b.UpdateScore(ctx, users[0].ID.Hex(), 1200); err != nil {
+		t.Fatalf("UpdateScore: %v", err)
+	}
+	if _, err := b.IncrementScore(ctx, users[1].ID.Hex(), 100); err != nil {
+		t.Fatalf("IncrementScore: %v", err)
+	}
+	if _, err := b.UpdateScoreIf(ctx, users[2].ID.Hex(), 900, ScoreMax); err != nil {
+		t.Fatalf("UpdateScoreIf: %v", err)
+	}
+
+	// A new formula must not overwrite the scores written directly
+	setWeights(t, map[string]float64{"wins": 20})
+	rescored, err := rescoreComposites(ctx)
+	if err != nil {
+		t.Fatalf("rescoreComposites: %v", err)
+	}
+	if rescored != 0 {
+		t.Errorf("rescored %d users, want 0", rescored)
+	}
+	want := []int{1200, 600, 900}
+	for i, u := range users {
+		if e, _ := b.cache.Get(u.ID.Hex()); e.Score != want[i] {
+			t.Errorf("cached %s = %d, want %d", u.Username, e.Score, want[i])
+		}
+		docs := coll.Docs(bson.M{"username": u.Username})
+		if len(docs) != 1 {
+			t.Fatalf("found %d documents for %s", len(docs), u.Username)
+		}
+		if _, ok := docs[0]["components"]; ok {
+			t.Errorf("%s kept components after a direct score write", u.Username)
+		}
+	}
+}
+
+func TestRescoreCountsOnlySuccessfulWrites(t *testing.T) {
+	setWeights(t, map[string]float64{"wins": 10})
+	_, coll := loadUsers(t, compositeUser("alice", 50), compositeUser("bob", 50), compositeUser("carol", 50))
+
+	setWeights(t, map[string]float64{"wins": 20})
+	coll.FailWrite = func(doc bson.M) bool { return doc["username"] == "bob" }
+	rescored, err := rescoreComposites(context.Background())
+	if err == nil {
+		t.Fatal("rescoreComposites reported no error for a failed write")
+	}
+	if rescored != 2 {
+		t.Errorf("rescored %d users, want 2", rescored)
+	}
+}
+
+func TestScoreResetsSurviveReload(t *testing.T) {
+	ctx := context.Background()
+	for name, write := range map[string]func(b *Board) error{
+		"rollover": func(b *Board) error {
+			_, err := b.RolloverSeason(ctx, "s1", 150)
+			return err
+		},
+		"bulk update": func(b *Board) error {
+			_, err := b.BulkUpdateToValue(ctx, 3, 150, false, nil)
+			return err
+		},
+		"decay": func(b *Board) error {
+			b.applyDecay(ctx, DecayConfig{InactiveAfter: time.Hour, Factor: 0.1, MinScore: 150})
+			return nil
+		},
+	} {
+		setWeights(t, map[string]float64{"wins": 10})
+		users := []models.User{compositeUser("alice", 50), compositeUser("bob", 60), compositeUser("carol", 70)}
+		for i := range users {
+			users[i].UpdatedAt = time.Now().Add(-48 * time.Hour)
+		}
+		b, coll := loadUsers(t, users...)
+
+		if err := write(b); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// A new formula makes the reload rescore every user still holding
+		// components
+		setWeights(t, map[string]float64{"wins": 20})
+		if _, err := Reload(ctx); err != nil {
+			t.Fatalf("%s: Reload: %v", name, err)
+		}
+
+		for _, u := range users {
+			if e, _ := b.cache.Get(u.ID.Hex()); e.Score != 150 {
+				t.Errorf("%s: cached %s = %d after Reload, want 150", name, u.Username, e.Score)
+			}
+			docs := coll.Docs(bson.M{"username": u.Username})
+			if len(docs) != 1 {
+				t.Fatalf("%s: found %d documents for %s", name, len(docs), u.Username)
+			}
+			if _, ok := docs[0]["components"]; ok {
+				t.Errorf("%s: %s kept components", name, u.Username)
+			}
+		}
+	}
+}
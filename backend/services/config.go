@@ -0,0 +1,160 @@
+// Package services contains runtime configuration read from the environment.
+package services
+
+import (
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	"matiks-leaderboard/env"
+)
+
+const (
+	DefaultRebuildDelayMS    = 100
+	DefaultMaxRebuildDelayMS = 500
+	DefaultDBTimeoutMS       = 5000
+	DefaultUsernameMinLength = 1
+	DefaultUsernameMaxLength = 32
+	DefaultNewUserScore      = 100
+	DefaultInitBatchSize     = 1000
+	DefaultInitMaxBadDocs    = 10
+	DefaultTopCacheSize      = 100
+)
+
+var (
+	rebuildDelay    = DefaultRebuildDelayMS * time.Millisecond
+	maxRebuildDelay = DefaultMaxRebuildDelayMS * time.Millisecond
+	dbTimeout       = DefaultDBTimeoutMS * time.Millisecond
+
+	// initBatchSize is how many users each cursor batch fetches on load, and
+	// initMaxBadDocs how many undecodable users a load tolerates
+	initBatchSize  = DefaultInitBatchSize
+	initMaxBadDocs = DefaultInitMaxBadDocs
+
+	usernameMinLength = DefaultUsernameMinLength
+	usernameMaxLength = DefaultUsernameMaxLength
+
+	// defaultScore is given to users created without a score
+	defaultScore = DefaultNewUserScore
+
+	// sharedReads serves leaderboard pages and top-N reads from shared
+	// snapshot views instead of copies
+	sharedReads bool
+
+	// topCacheSize is how many of each board's top entries are kept
+	// encoded for GET /leaderboard/top/:n; 0 disables the cache
+	topCacheSize = DefaultTopCacheSize
+
+	// historyEnabled records rank changes on every rebuild. Rebuilds read
+	// it unlocked, so it is only set before any board starts
+	historyEnabled bool
+)
+
+// LoadDebounceConfig reads REBUILD_DELAY_MS and MAX_REBUILD_DELAY_MS, and
+// REBUILD_WORKERS, how many boards may rebuild at once (GOMAXPROCS by
+// default). Missing, invalid or inconsistent values fall back to the
+// defaults. Must be called before Initialize.
+func LoadDebounceConfig() {
+	delay := env.PositiveInt("REBUILD_DELAY_MS", DefaultRebuildDelayMS)
+	maxDelay := env.PositiveInt("MAX_REBUILD_DELAY_MS", DefaultMaxRebuildDelayMS)
+
+	if maxDelay < delay {
+		slog.Warn("MAX_REBUILD_DELAY_MS is below REBUILD_DELAY_MS, using defaults",
+			"rebuild_delay_ms", delay,
+			"max_rebuild_delay_ms", maxDelay,
+		)
+		delay, maxDelay = DefaultRebuildDelayMS, DefaultMaxRebuildDelayMS
+	}
+
+	workers := env.PositiveInt("REBUILD_WORKERS", runtime.GOMAXPROCS(0))
+
+	rebuildDelay = time.Duration(delay) * time.Millisecond
+	maxRebuildDelay = time.Duration(maxDelay) * time.Millisecond
+	rebuildSlots = make(chan struct{}, workers)
+	slog.Info("rebuild debounce configured",
+		"rebuild_delay_ms", delay,
+		"max_rebuild_delay_ms", maxDelay,
+		"rebuild_workers", workers,
+	)
+}
+
+// LoadDBConfig reads DB_TIMEOUT_MS, the deadline applied to each MongoDB
+// operation, DB_RETRY_ATTEMPTS / DB_RETRY_BACKOFF_MS for retried writes, and
+// INIT_BATCH_SIZE / INIT_MAX_BAD_DOCS for loading users.
+// Must be called before Initialize.
+func LoadDBConfig() {
+	timeout := env.PositiveInt("DB_TIMEOUT_MS", DefaultDBTimeoutMS)
+	backoff := env.PositiveInt("DB_RETRY_BACKOFF_MS", DefaultDBRetryBackoffMS)
+	dbTimeout = time.Duration(timeout) * time.Millisecond
+	dbRetryAttempts = env.PositiveInt("DB_RETRY_ATTEMPTS", DefaultDBRetryAttempts)
+	dbRetryBackoff = time.Duration(backoff) * time.Millisecond
+	initBatchSize = env.PositiveInt("INIT_BATCH_SIZE", DefaultInitBatchSize)
+	// Zero is meaningful here: it makes any bad document fail the load
+	initMaxBadDocs = DefaultInitMaxBadDocs
+	if v, err := strconv.Atoi(os.Getenv("INIT_MAX_BAD_DOCS")); err == nil && v >= 0 {
+		initMaxBadDocs = v
+	}
+	slog.Info("database operations configured",
+		"db_timeout_ms", timeout,
+		"retry_attempts", dbRetryAttempts,
+		"retry_backoff_ms", backoff,
+		"init_batch_size", initBatchSize,
+		"init_max_bad_docs", initMaxBadDocs,
+	)
+}
+
+// LoadUsernameConfig reads USERNAME_MIN_LENGTH and USERNAME_MAX_LENGTH,
+// counted in characters. An inverted range falls back to the defaults.
+func LoadUsernameConfig() {
+	minLength := env.PositiveInt("USERNAME_MIN_LENGTH", DefaultUsernameMinLength)
+	maxLength := env.PositiveInt("USERNAME_MAX_LENGTH", DefaultUsernameMaxLength)
+	if maxLength < minLength {
+		slog.Warn("USERNAME_MAX_LENGTH is below USERNAME_MIN_LENGTH, using defaults",
+			"min_length", minLength,
+			"max_length", maxLength,
+		)
+		minLength, maxLength = DefaultUsernameMinLength, DefaultUsernameMaxLength
+	}
+	usernameMinLength, usernameMaxLength = minLength, maxLength
+}
+
+// LoadScoreConfig reads DEFAULT_SCORE, the score of users created without
+// one. A value outside the valid score range falls back to the default.
+func LoadScoreConfig() {
+	score := env.PositiveInt("DEFAULT_SCORE", DefaultNewUserScore)
+	if score < minScore || score > maxScore {
+		slog.Warn("DEFAULT_SCORE is outside the valid score range, using default",
+			"default_score", score,
+			"min_score", minScore,
+			"max_score", maxScore,
+		)
+		score = DefaultNewUserScore
+	}
+	defaultScore = score
+}
+
+// LoadHistoryConfig reads ENABLE_HISTORY, which turns on rank history.
+// Must be called before Initialize.
+func LoadHistoryConfig() {
+	historyEnabled = os.Getenv("ENABLE_HISTORY") == "true"
+}
+
+// LoadSnapshotConfig reads SNAPSHOT_SHARED_READS. When true, reads convert
+// entries straight from the snapshot's own array rather than copying the
+// requested slice first, saving an allocation per read. TOP_CACHE_SIZE sets
+// how many top entries are kept encoded after each rebuild (default 100, 0
+// to disable).
+func LoadSnapshotConfig() {
+	sharedReads = os.Getenv("SNAPSHOT_SHARED_READS") == "true"
+
+	if v := os.Getenv("TOP_CACHE_SIZE"); v != "" {
+		if size, err := strconv.Atoi(v); err == nil && size >= 0 {
+			topCacheSize = size
+		} else {
+			slog.Warn("invalid TOP_CACHE_SIZE, using default", "value", v)
+		}
+	}
+	slog.Info("snapshot reads configured", "shared", sharedReads, "top_cache_size", topCacheSize)
+}
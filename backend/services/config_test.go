@@ -0,0 +1,37 @@
+package services
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestLoadDebounceConfigFallsBack(t *testing.T) {
+	t.Cleanup(LoadDebounceConfig)
+	defaultDelay := DefaultRebuildDelayMS * time.Millisecond
+	defaultMax := DefaultMaxRebuildDelayMS * time.Millisecond
+
+	for _, tc := range []struct {
+		delay, maxDelay, workers string
+		wantDelay, wantMax       time.Duration
+		wantWorkers              int
+	}{
+		{"", "", "", defaultDelay, defaultMax, runtime.GOMAXPROCS(0)},
+		{"50", "200", "3", 50 * time.Millisecond, 200 * time.Millisecond, 3},
+		{"0", "0", "0", defaultDelay, defaultMax, runtime.GOMAXPROCS(0)},
+		{"-10", "-20", "-1", defaultDelay, defaultMax, runtime.GOMAXPROCS(0)},
+		{"fast", "1.5", "many", defaultDelay, defaultMax, runtime.GOMAXPROCS(0)},
+		// A maximum below the delay is inconsistent, so both reset
+		{"300", "200", "2", defaultDelay, defaultMax, 2},
+	} {
+		t.Setenv("REBUILD_DELAY_MS", tc.delay)
+		t.Setenv("MAX_REBUILD_DELAY_MS", tc.maxDelay)
+		t.Setenv("REBUILD_WORKERS", tc.workers)
+		LoadDebounceConfig()
+		if rebuildDelay != tc.wantDelay || maxRebuildDelay != tc.wantMax || cap(rebuildSlots) != tc.wantWorkers {
+			t.Errorf("REBUILD_DELAY_MS=%q MAX_REBUILD_DELAY_MS=%q REBUILD_WORKERS=%q: got %v, %v, %d workers; want %v, %v, %d",
+				tc.delay, tc.maxDelay, tc.workers, rebuildDelay, maxRebuildDelay, cap(rebuildSlots),
+				tc.wantDelay, tc.wantMax, tc.wantWorkers)
+		}
+	}
+}
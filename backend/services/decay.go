@@ -0,0 +1,214 @@
+// Package services contains the background score decay job.
+package services
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"matiks-leaderboard/cache"
+	"matiks-leaderboard/database"
+	"matiks-leaderboard/env"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const decayBatchSize = 1000
+
+// DecayConfig controls how inactive players lose score over time.
+type DecayConfig struct {
+	Interval      time.Duration
+	InactiveAfter time.Duration
+	Factor        float64
+	MinScore      int
+}
+
+// StartDecay launches the decay job if DECAY_ENABLED=true. It runs every
+// DECAY_INTERVAL until ctx is cancelled, skipping runs in maintenance mode.
+func StartDecay(ctx context.Context) {
+	if os.Getenv("DECAY_ENABLED") != "true" {
+		return
+	}
+
+	cfg := loadDecayConfig()
+	slog.Info("score decay enabled",
+		"interval", cfg.Interval.String(),
+		"inactive_after", cfg.InactiveAfter.String(),
+		"factor", cfg.Factor,
+		"min_score", cfg.MinScore,
+	)
+
+	go func() {
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if InMaintenance() {
+					slog.Info("maintenance mode, skipping score decay")
+					continue
+				}
+				for _, b := range Boards() {
+					b.applyDecay(ctx, cfg)
+				}
+			}
+		}
+	}()
+}
+
+// loadDecayConfig reads DECAY_INTERVAL, DECAY_INACTIVE_AFTER, DECAY_FACTOR
+// and MIN_SCORE. A factor of 1 or more, or a MIN_SCORE outside the valid
+// score range, falls back to the default.
+func loadDecayConfig() DecayConfig {
+	cfg := DecayConfig{
+		Interval:      env.PositiveDuration("DECAY_INTERVAL", time.Hour),
+		InactiveAfter: env.PositiveDuration("DECAY_INACTIVE_AFTER", 7*24*time.Hour),
+		Factor:        env.PositiveFloat("DECAY_FACTOR", 0.98),
+		MinScore:      env.PositiveInt("MIN_SCORE", minScore),
+	}
+	if cfg.Factor >= 1 {
+		slog.Warn("DECAY_FACTOR must be below 1, using default", "factor", cfg.Factor)
+		cfg.Factor = 0.98
+	}
+	if cfg.MinScore < minScore || cfg.MinScore > maxScore {
+		slog.Warn("MIN_SCORE is outside the valid score range, using default",
+			"min_score", cfg.MinScore,
+			"valid_min", minScore,
+			"valid_max", maxScore,
+		)
+		cfg.MinScore = minScore
+	}
+	return cfg
+}
+
+// applyDecay lowers the score of every user inactive for longer than
+// cfg.InactiveAfter, then rebuilds the board once. Decayed users lose their
+// score components, so a reload's rescore can't restore the old score.
+//
+// Both the MongoDB write and the cache write are compare-and-set against the
+// score read at the start, so a concurrent UpdateScore always wins. Users with
+// no recorded update time are skipped.
+func (b *Board) applyDecay(ctx context.Context, cfg DecayConfig) {
+	cutoff := time.Now().Add(-cfg.InactiveAfter)
+
+	type decayed struct {
+		id       string
+		old, new cache.Entry
+	}
+	var pending []decayed
+
+	for id, e := range b.cache.GetAllWithIDs() {
+		if e.UpdatedAt.IsZero() || e.UpdatedAt.After(cutoff) {
+			continue
+		}
+		score := int(float64(e.Score) * cfg.Factor)
+		if score < cfg.MinScore {
+			score = cfg.MinScore
+		}
+		if score >= e.Score {
+			continue
+		}
+		next := e
+		next.Score = score
+		pending = append(pending, decayed{id: id, old: e, new: next})
+	}
+
+	if len(pending) == 0 {
+		return
+	}
+
+	start := time.Now()
+	changed := 0
+	for i := 0; i < len(pending); i += decayBatchSize {
+		end := i + decayBatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		batch := pending[i:end]
+
+		writes := make([]mongo.WriteModel, 0, len(batch))
+		written := make([]decayed, 0, len(batch))
+		objIDs := make([]primitive.ObjectID, 0, len(batch))
+		for _, d := range batch {
+			objID, err := primitive.ObjectIDFromHex(d.id)
+			if err != nil {
+				continue
+			}
+			objIDs = append(objIDs, objID)
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": objID, "score": d.old.Score}).
+				SetUpdate(bson.M{
+					"$set":   bson.M{"score": d.new.Score},
+					"$unset": bson.M{"components": ""},
+				}))
+			written = append(written, d)
+		}
+
+		opCtx, cancel := dbContext(ctx)
+		_, err := database.Collection("users").BulkWrite(opCtx, writes, options.BulkWrite().SetOrdered(false))
+		cancel()
+		if failed := writeFailures(err, len(writes)); len(failed) > 0 {
+			slog.Warn("decay writes failed", "board", b.ID, "failed", len(failed), "batch", len(writes), "error", err)
+		}
+
+		// A write whose score filter matched nothing raises no error, so
+		// which users decayed is read back rather than inferred. If that
+		// fails the cache keeps the old scores; the next run finds the
+		// stored score already decayed and catches the cache up.
+		stored, err := storedScores(ctx, objIDs)
+		if err != nil {
+			slog.Warn("reading back decayed scores failed", "board", b.ID, "batch", len(writes), "error", err)
+			continue
+		}
+		for _, d := range written {
+			if score, ok := stored[d.id]; !ok || score != d.new.Score {
+				continue
+			}
+			if b.cache.CompareAndSet(d.id, d.old, d.new) {
+				changed++
+			}
+		}
+	}
+
+	if changed > 0 {
+		b.ForceRebuild()
+	}
+	slog.Info("score decay applied",
+		"board", b.ID,
+		"decayed", changed,
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+}
+
+// storedScores reads the current stored score of each user in objIDs, keyed
+// by hex ID. Users no longer stored are absent.
+func storedScores(ctx context.Context, objIDs []primitive.ObjectID) (map[string]int, error) {
+	scores := make(map[string]int, len(objIDs))
+	err := withRetry(ctx, func(ctx context.Context) error {
+		cursor, err := database.Collection("users").Find(ctx,
+			bson.M{"_id": bson.M{"$in": objIDs}},
+			options.Find().SetProjection(bson.M{"score": 1}))
+		if err != nil {
+			return err
+		}
+		var docs []struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Score int                `bson:"score"`
+		}
+		if err := cursor.All(ctx, &docs); err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			scores[doc.ID.Hex()] = doc.Score
+		}
+		return nil
+	})
+	return scores, err
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"matiks-leaderboard/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDecayKeepsWritesThatSucceeded(t *testing.T) {
+	idle := time.Now().Add(-30 * 24 * time.Hour)
+	users := []string{"alice", "bob", "carol"}
+	stored := make([]models.User, len(users))
+	for i, name := range users {
+		stored[i] = testUser(name, 1000)
+		stored[i].UpdatedAt = idle
+	}
+	b, coll := loadUsers(t, stored...)
+
+	// bob's write fails validation; the rest of the unordered batch lands
+	coll.FailWrite = func(doc bson.M) bool { return doc["username"] == "bob" }
+	b.applyDecay(context.Background(), DecayConfig{InactiveAfter: 24 * time.Hour, Factor: 0.5, MinScore: 100})
+
+	want := map[string]int{"alice": 500, "bob": 1000, "carol": 500}
+	for i, name := range users {
+		e, ok := b.cache.Get(stored[i].ID.Hex())
+		if !ok || e.Score != want[name] {
+			t.Errorf("cached %s = %d (found %v), want %d", name, e.Score, ok, want[name])
+		}
+		docs := coll.Docs(bson.M{"username": name})
+		if len(docs) != 1 || docs[0]["score"] != int32(want[name]) {
+			t.Errorf("stored %s = %v, want score %d", name, docs, want[name])
+		}
+	}
+}
+
+func TestDecaySkipsWritesThatMatchedNothing(t *testing.T) {
+	idle := time.Now().Add(-30 * 24 * time.Hour)
+	alice, bob := testUser("alice", 1000), testUser("bob", 1000)
+	alice.UpdatedAt, bob.UpdatedAt = idle, idle
+	b, coll := loadUsers(t, alice, bob)
+
+	// bob's stored score moves before the decay writes land, so its score
+	// filter matches nothing and MongoDB reports no error
+	coll.OnCall = func(method string) {
+		if method != "BulkWrite" {
+			return
+		}
+		coll.OnCall = nil
+		coll.UpdateMany(context.Background(), bson.M{"username": "bob"}, bson.M{"$set": bson.M{"score": 2000}})
+	}
+	b.applyDecay(context.Background(), DecayConfig{InactiveAfter: 24 * time.Hour, Factor: 0.5, MinScore: 100})
+
+	if e, _ := b.cache.Get(alice.ID.Hex()); e.Score != 500 {
+		t.Errorf("cached alice = %d, want 500", e.Score)
+	}
+	if e, _ := b.cache.Get(bob.ID.Hex()); e.Score != 1000 {
+		t.Errorf("cached bob = %d, want 1000: the cache decayed a write MongoDB didn't make", e.Score)
+	}
+	if docs := coll.Docs(bson.M{"username": "bob"}); len(docs) != 1 || docs[0]["score"] != int32(2000) {
+		t.Errorf("stored bob = %v, want score 2000", docs)
+	}
+}
+
+func TestLoadDecayConfigRejectsMinScoreOutOfRange(t *testing.T) {
+	for _, tc := range []struct {
+		env  string
+		want int
+	}{
+		{"", minScore},
+		{"250", 250},
+		{"50", minScore},
+		{strconv.Itoa(maxScore + 1), minScore},
+	} {
+		t.Setenv("MIN_SCORE", tc.env)
+		if got := loadDecayConfig().MinScore; got != tc.want {
+			t.Errorf("MIN_SCORE=%q: MinScore = %d, want %d", tc.env, got, tc.want)
+		}
+	}
+}
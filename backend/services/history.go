@@ -0,0 +1,119 @@
+// Package services contains the rank history recording and queries.
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"matiks-leaderboard/database"
+	"matiks-leaderboard/engine"
+	"matiks-leaderboard/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	historyCollection = "rank_history"
+	historyBatchSize  = 500
+)
+
+// initHistory prepares the rank_history collection if history is enabled.
+func initHistory(ctx context.Context) {
+	if !historyEnabled {
+		return
+	}
+
+	ctx, cancel := dbContext(ctx)
+	defer cancel()
+	err := database.EnsureIndex(ctx, historyCollection, mongo.IndexModel{
+		Keys: bson.D{{Key: "board", Value: 1}, {Key: "userId", Value: 1}, {Key: "timestamp", Value: 1}},
+	})
+	if err != nil {
+		log.Printf("⚠️ Failed to create rank history index: %v", err)
+	}
+	log.Println("📈 Rank history enabled")
+}
+
+// rankChanges compares the previous rank index with the current entries and
+// returns a history entry on the board for every user whose rank moved.
+func (b *Board) rankChanges(prev map[string]int, curr []engine.RankedEntry) []interface{} {
+	// No baseline yet (first load), nothing to compare against
+	if len(prev) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	var changes []interface{}
+	for _, e := range curr {
+		if prev[e.UserID] == e.Rank {
+			continue
+		}
+		changes = append(changes, models.RankHistoryEntry{
+			Board:     b.storedBoard(),
+			UserID:    e.UserID,
+			Rank:      e.Rank,
+			Score:     e.Score,
+			Timestamp: now,
+		})
+	}
+	return changes
+}
+
+// writeHistory inserts history entries in batches.
+// Runs in the background so rebuilds never wait on MongoDB.
+func writeHistory(entries []interface{}) {
+	collection := database.Collection(historyCollection)
+
+	for i := 0; i < len(entries); i += historyBatchSize {
+		end := i + historyBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		ctx, cancel := dbContext(context.Background())
+		_, err := collection.InsertMany(ctx, entries[i:end], options.InsertMany().SetOrdered(false))
+		cancel()
+
+		if err != nil {
+			log.Printf("⚠️ Failed to write rank history batch: %v", err)
+		}
+	}
+}
+
+// GetRankHistory returns the user's recorded ranks on the board between from
+// and to, oldest first. A zero bound leaves that end open.
+func (b *Board) GetRankHistory(ctx context.Context, userID string, from, to time.Time) ([]models.RankHistoryEntry, error) {
+	filter := b.filter(bson.M{"userId": userID})
+
+	timeRange := bson.M{}
+	if !from.IsZero() {
+		timeRange["$gte"] = from
+	}
+	if !to.IsZero() {
+		timeRange["$lte"] = to
+	}
+	if len(timeRange) > 0 {
+		filter["timestamp"] = timeRange
+	}
+
+	ctx, cancel := dbContext(ctx)
+	defer cancel()
+	cursor, err := database.Collection(historyCollection).Find(
+		ctx,
+		filter,
+		options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	history := []models.RankHistoryEntry{}
+	if err := cursor.All(ctx, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
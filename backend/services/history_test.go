@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"matiks-leaderboard/cache"
+	"matiks-leaderboard/database/dbtest"
+	"matiks-leaderboard/engine"
+)
+
+func TestRankHistoryIsScopedToBoard(t *testing.T) {
+	dbtest.Install(t)
+	ctx := context.Background()
+	def := newBoard(DefaultBoardID, cache.NewUserCache(), &engine.Snapshot{})
+	other := newBoard("other", cache.NewUserCache(), &engine.Snapshot{})
+
+	// The same user ranked on both boards
+	const id = "64b7f0c2a1b2c3d4e5f60718"
+	writeHistory(def.rankChanges(map[string]int{id: 5}, []engine.RankedEntry{{UserID: id, Rank: 3, Score: 400}}))
+	writeHistory(other.rankChanges(map[string]int{id: 1}, []engine.RankedEntry{{UserID: id, Rank: 2, Score: 900}}))
+
+	for _, tc := range []struct {
+		board *Board
+		rank  int
+	}{{def, 3}, {other, 2}} {
+		history, err := tc.board.GetRankHistory(ctx, id, time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatalf("board %s: GetRankHistory: %v", tc.board.ID, err)
+		}
+		if len(history) != 1 || history[0].Rank != tc.rank {
+			t.Errorf("board %s: history = %+v, want one entry at rank %d", tc.board.ID, history, tc.rank)
+		}
+	}
+}
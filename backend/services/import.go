@@ -0,0 +1,231 @@
+// Package services implements bulk user import from CSV or JSON uploads.
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"matiks-leaderboard/models"
+)
+
+// Import formats accepted by ImportUsers.
+const (
+	ImportCSV  = "csv"
+	ImportJSON = "json"
+)
+
+// maxImportErrors caps the row errors returned; counts stay exact beyond it.
+const maxImportErrors = 100
+
+// importer accumulates parsed rows and inserts them in batches, so a file is
+// never held in memory as a whole.
+type importer struct {
+	board   *Board
+	ctx     context.Context
+	result  models.ImportResult
+	pending []models.NewUser
+	rows    []int
+}
+
+// ImportUsers streams users from r in the given format and inserts them in
+// batches. The snapshot is rebuilt once at the end rather than per batch.
+//
+// CSV rows are username,score; a header row naming the columns (username and
+// score or rating) may reorder them. JSON must be an array of objects shaped
+// like the batch create request. A malformed file stops the import at that
+// point; rows already inserted are kept and reported.
+func (b *Board) ImportUsers(ctx context.Context, r io.Reader, format string) (*models.ImportResult, error) {
+	start := time.Now()
+	imp := &importer{board: b, ctx: ctx, result: models.ImportResult{Errors: []models.ImportRowError{}}}
+
+	src := &sourceReader{r: r}
+	var err error
+	switch format {
+	case ImportCSV:
+		err = imp.readCSV(src)
+	case ImportJSON:
+		err = imp.readJSON(src)
+	default:
+		return nil, &ValidationError{"format must be csv or json"}
+	}
+	if src.err != nil {
+		// The upload itself failed (too large, client gone), so the parse
+		// error it caused says nothing about the file
+		err = src.err
+	}
+	imp.flush()
+
+	if imp.result.Inserted > 0 {
+		b.ForceRebuild()
+	}
+	imp.result.DurationMs = time.Since(start).Milliseconds()
+	return &imp.result, err
+}
+
+// sourceReader remembers the first read error from the upload, so it can be
+// told apart from malformed content.
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF && s.err == nil {
+		s.err = err
+	}
+	return n, err
+}
+
+func (imp *importer) readCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	usernameCol, scoreCol := 0, 1
+	first := true
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &ValidationError{"malformed CSV: " + err.Error()}
+		}
+		line, _ := reader.FieldPos(0)
+
+		if first {
+			first = false
+			if u, s, ok := csvHeader(record); ok {
+				usernameCol, scoreCol = u, s
+				continue
+			}
+		}
+
+		username := csvField(record, usernameCol)
+		score := defaultScore
+		if raw := csvField(record, scoreCol); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				imp.fail(line, username, "invalid score "+strconv.Quote(raw))
+				continue
+			}
+			score = n
+		}
+		if err := imp.add(line, username, score); err != nil {
+			return err
+		}
+	}
+}
+
+// csvHeader reports the username and score columns if record is a header row.
+func csvHeader(record []string) (int, int, bool) {
+	usernameCol, scoreCol := -1, -1
+	for i, field := range record {
+		switch strings.ToLower(strings.TrimSpace(field)) {
+		case "username":
+			usernameCol = i
+		case "score", "rating":
+			scoreCol = i
+		}
+	}
+	return usernameCol, scoreCol, usernameCol >= 0
+}
+
+func csvField(record []string, col int) string {
+	if col < 0 || col >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[col])
+}
+
+func (imp *importer) readJSON(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return &ValidationError{"JSON body must be an array of users"}
+	}
+
+	for row := 1; dec.More(); row++ {
+		var item struct {
+			Username string `json:"username"`
+			Rating   int    `json:"rating"`
+			Score    int    `json:"score"`
+		}
+		if err := dec.Decode(&item); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				// The decoder has consumed the element, so keep going
+				imp.fail(row, item.Username, "invalid "+typeErr.Field)
+				continue
+			}
+			return &ValidationError{"malformed JSON at element " + strconv.Itoa(row) + ": " + err.Error()}
+		}
+
+		score := item.Rating
+		if score == 0 {
+			score = item.Score
+		}
+		if score == 0 {
+			score = defaultScore
+		}
+		if err := imp.add(row, strings.TrimSpace(item.Username), score); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add queues a row, inserting the batch once it is full.
+func (imp *importer) add(row int, username string, score int) error {
+	imp.pending = append(imp.pending, models.NewUser{Username: username, Score: score})
+	imp.rows = append(imp.rows, row)
+	if len(imp.pending) >= bulkWriteBatchSize {
+		imp.flush()
+	}
+	return imp.ctx.Err()
+}
+
+func (imp *importer) flush() {
+	if len(imp.pending) == 0 {
+		return
+	}
+
+	results, inserted := imp.board.insertUsers(imp.ctx, imp.pending)
+	imp.result.Inserted += inserted
+	for i, r := range results {
+		if r.Success {
+			continue
+		}
+		if r.Error == ErrUsernameTaken.Message {
+			imp.result.Skipped++
+			imp.recordError(imp.rows[i], r.Username, r.Error)
+			continue
+		}
+		imp.fail(imp.rows[i], r.Username, r.Error)
+	}
+
+	imp.pending = imp.pending[:0]
+	imp.rows = imp.rows[:0]
+}
+
+func (imp *importer) fail(row int, username, msg string) {
+	imp.result.Failed++
+	imp.recordError(row, username, msg)
+}
+
+func (imp *importer) recordError(row int, username, msg string) {
+	if len(imp.result.Errors) < maxImportErrors {
+		imp.result.Errors = append(imp.result.Errors, models.ImportRowError{
+			Row:      row,
+			Username: username,
+			Error:    msg,
+		})
+	}
+}
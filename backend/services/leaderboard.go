@@ -3,10 +3,17 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"math/rand"
+	"os"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"matiks-leaderboard/cache"
 	"matiks-leaderboard/database"
@@ -19,262 +26,1186 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-const (
-	RebuildDelayMS    = 100
-	MaxRebuildDelayMS = 500
-)
+// cachedUser is the part of a user document the cache holds. Initialize
+// fetches and decodes only these fields, so fields added to users later
+// don't slow down startup.
+type cachedUser struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Username  string             `bson:"username"`
+	Score     int                `bson:"score"`
+	Board     string             `bson:"board"`
+	Country   string             `bson:"country"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt"`
+}
 
-type Stats struct {
-	mu                   sync.RWMutex
-	TotalUpdates         int64
-	RebuildsTriggered    int64
-	AvgUpdatesPerRebuild float64
+// boardLoad is a board's users being loaded into a staged store.
+type boardLoad struct {
+	board  *Board
+	staged cache.Staging
 }
 
-var (
-	stats          = &Stats{}
-	pendingUpdates int64
-	rebuildTimer   *time.Timer
-	lastRebuild    time.Time
-	rebuildMu      sync.Mutex
-)
+// cachedUserFields projects a user document down to cachedUser.
+var cachedUserFields = bson.M{
+	"username":  1,
+	"score":     1,
+	"board":     1,
+	"country":   1,
+	"createdAt": 1,
+	"updatedAt": 1,
+}
 
+// Initialize registers the default board plus any listed in BOARDS, loads
+// every user from MongoDB into its board's cache and builds all snapshots.
+// Boards found in MongoDB but not listed in BOARDS are registered as well.
+// Users are loaded into staged stores that replace the board caches only
+// once every user is read, so a cursor error or more than INIT_MAX_BAD_DOCS
+// undecodable documents fails the load and leaves the caches, and the set
+// of boards, as they were rather than half loaded.
 func Initialize(ctx context.Context) error {
-	cursor, err := database.Collection("users").Find(ctx, bson.M{})
+	DefaultBoard()
+	for _, id := range parseBoardIDs(os.Getenv("BOARDS")) {
+		ensureBoard(id)
+	}
+
+	if err := backfillTimestamps(ctx); err != nil {
+		return err
+	}
+
+	// Loading every user is bounded by the caller's startup deadline rather
+	// than the per-operation timeout, which is sized for single requests.
+	// Sorted by _id, which grows with creation time, so the cache numbers
+	// users for the insertion tie-break roughly in the order they joined
+	cursor, err := database.Collection("users").Find(ctx, activeUsers, options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetProjection(cachedUserFields).
+		SetBatchSize(int32(initBatchSize)))
 	if err != nil {
 		return err
 	}
 	defer cursor.Close(ctx)
 
-	cache.Global.Clear()
+	// Boards first seen in MongoDB are only registered once the load commits.
+	loads := make(map[string]*boardLoad)
+	for _, b := range Boards() {
+		loads[b.ID] = &boardLoad{board: b, staged: b.cache.Stage()}
+	}
+	stage := func(id string) cache.Staging {
+		l, ok := loads[id]
+		if !ok {
+			b := buildBoard(id)
+			l = &boardLoad{board: b, staged: b.cache.Stage()}
+			loads[id] = l
+		}
+		return l.staged
+	}
+	discard := func() {
+		for _, l := range loads {
+			l.staged.Discard()
+		}
+	}
+
+	badDocs := 0
 	for cursor.Next(ctx) {
-		var user models.User
+		var user cachedUser
 		if err := cursor.Decode(&user); err != nil {
+			badDocs++
+			log.Printf("⚠️ Skipping undecodable user %s: %v", cursor.Current.Lookup("_id"), err)
+			if badDocs > initMaxBadDocs {
+				discard()
+				return fmt.Errorf("more than %d user documents failed to decode, last: %w", initMaxBadDocs, err)
+			}
 			continue
 		}
-		cache.Global.Set(user.ID.Hex(), cache.Entry{
-			Username: user.Username,
-			Score:    user.Score,
+		boardID := user.Board
+		if boardID == "" {
+			boardID = DefaultBoardID
+		}
+		stage(boardID).Set(user.ID.Hex(), cache.Entry{
+			Username:  user.Username,
+			Score:     user.Score,
+			Country:   user.Country,
+			CreatedAt: user.CreatedAt,
+			UpdatedAt: user.UpdatedAt,
 		})
 	}
+	if err := cursor.Err(); err != nil {
+		discard()
+		return fmt.Errorf("reading users: %w", err)
+	}
 
-	// Create unique index on username
-	_, err = database.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "username", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	})
-	if err != nil {
-		log.Printf("⚠️ Failed to create unique index: %v", err)
-		// Don't fail, maybe existing duplicates prevents it
+	for _, l := range loads {
+		b := registerBoard(l.board)
+		if err := l.staged.Commit(); err != nil {
+			discard()
+			return fmt.Errorf("loading board %q: %w", b.ID, err)
+		}
 	}
 
-	ForceRebuild()
-	log.Printf("✅ Loaded %d users into cache", cache.Global.Size())
+	initHistory(ctx)
+	initSeasons(ctx)
+	for _, b := range Boards() {
+		b.ForceRebuild()
+		log.Printf("✅ Loaded %d users into board %q", b.cache.Size(), b.ID)
+	}
+	dbLoaded.Store(true)
 	return nil
 }
 
-func GetLeaderboard(page, limit int) *models.LeaderboardResponse {
-	entries, total := engine.Global.GetLeaderboard(page, limit)
+// reloadMu serializes Reload so two reloads can't interleave their loads.
+var reloadMu sync.Mutex
 
-	result := make([]models.LeaderboardEntry, len(entries))
+// Reload re-reads every user from MongoDB into the board caches and rebuilds
+// all snapshots, as Initialize does at startup. It is the recovery path when
+// the cache has drifted from the database, e.g. after a failed write. It
+// first rescores composite users, so a changed SCORE_WEIGHTS applies to them.
+func Reload(ctx context.Context) (*models.ReloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	start := time.Now()
+	before := totalUsers()
+	rescored, err := rescoreComposites(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := Initialize(ctx); err != nil {
+		return nil, err
+	}
+	return &models.ReloadResult{
+		UsersBefore: before,
+		UsersAfter:  totalUsers(),
+		Rescored:    rescored,
+		DurationMs:  time.Since(start).Milliseconds(),
+	}, nil
+}
+
+// totalUsers counts cached users across every board.
+func totalUsers() int {
+	n := 0
+	for _, b := range Boards() {
+		n += b.cache.Size()
+	}
+	return n
+}
+
+func (b *Board) GetLeaderboard(page, limit int, mode engine.RankMode) *models.LeaderboardResponse {
+	entries, total, generation := b.leaderboardPage(page, limit)
+
+	result := toLeaderboardEntries(entries, mode, 0)
+	response := newLeaderboardResponse(result, total, page, limit)
+	response.RankMode = string(mode)
+	response.Generation = generation
+	return response
+}
+
+// GetRegionLeaderboard returns a page of the users from country, ranked
+// among themselves. Each entry keeps its global rank alongside.
+func (b *Board) GetRegionLeaderboard(country string, page, limit int, mode engine.RankMode) (*models.LeaderboardResponse, error) {
+	country, err := normalizeCountry(country)
+	if err != nil {
+		return nil, err
+	}
+	entries, total, generation := b.snapshot.GetRegion(country, page, limit)
+
+	ranked := make([]engine.RankedEntry, len(entries))
 	for i, e := range entries {
-		result[i] = models.LeaderboardEntry{
-			UserID:   e.UserID,
-			Username: e.Username,
-			Rating:   e.Score,
-			Rank:     e.Rank,
+		ranked[i] = e.RankedEntry
+	}
+	result := toLeaderboardEntries(ranked, mode, 0)
+	for i, e := range entries {
+		result[i].GlobalRank = e.GlobalRank
+		if mode == engine.RankDense {
+			result[i].GlobalRank = e.GlobalDenseRank
 		}
 	}
 
+	response := newLeaderboardResponse(result, total, page, limit)
+	response.RankMode = string(mode)
+	response.Generation = generation
+	response.Country = country
+	return response, nil
+}
+
+// GetLeaderboardBand returns a page of the users scoring between minScore and
+// maxScore. Ranks are global unless bandRanks asks for them to be counted
+// from the top of the band.
+func (b *Board) GetLeaderboardBand(page, limit int, mode engine.RankMode, minScore, maxScore int, bandRanks bool) *models.LeaderboardResponse {
+	band := b.snapshot.GetByScoreRange(minScore, maxScore, page, limit)
+
+	offset := 0
+	if bandRanks {
+		offset = band.RankOffset
+		if mode == engine.RankDense {
+			offset = band.DenseRankOffset
+		}
+	}
+	result := toLeaderboardEntries(band.Entries, mode, offset)
+	response := newLeaderboardResponse(result, band.Total, page, limit)
+	response.RankMode = string(mode)
+	response.Generation = band.Generation
+	// An open end is reported as absent rather than as the int limit
+	if minScore > math.MinInt {
+		response.MinScore = &minScore
+	}
+	if maxScore < math.MaxInt {
+		response.MaxScore = &maxScore
+	}
+	response.BandRanks = bandRanks
+	return response
+}
+
+// GetUserPage returns the leaderboard page containing the user, or nil if
+// the user isn't ranked.
+func (b *Board) GetUserPage(userID string, limit int) *models.LeaderboardResponse {
+	pos, ok := b.snapshot.Position(userID)
+	if !ok || limit < 1 {
+		return nil
+	}
+	return b.GetLeaderboard(pos/limit+1, limit, engine.RankStandard)
+}
+
+// newLeaderboardResponse fills in the pagination metadata for a page of entries.
+// Pages past the end yield an empty entries array with accurate totals.
+func newLeaderboardResponse(entries []models.LeaderboardEntry, total, page, limit int) *models.LeaderboardResponse {
+	if entries == nil {
+		entries = []models.LeaderboardEntry{}
+	}
+	// An empty board has no pages at all, rather than one empty page
+	totalPages := 0
+	if limit > 0 {
+		totalPages = (total + limit - 1) / limit
+	}
+
 	return &models.LeaderboardResponse{
-		Entries:    result,
+		Entries:    entries,
+		Count:      len(entries),
 		TotalUsers: total,
-		TotalPages: (total + limit - 1) / limit,
+		TotalPages: totalPages,
 		Page:       page,
+		HasNext:    page >= 1 && page < totalPages,
+		HasPrev:    page > 1 && totalPages > 0,
+		RankMode:   string(engine.RankStandard),
+	}
+}
+
+func (b *Board) GetTopN(n int) []models.LeaderboardEntry {
+	return toLeaderboardEntries(b.topEntries(n), engine.RankStandard, 0)
+}
+
+// Export is one snapshot's full standings, read out a chunk at a time. It
+// holds the snapshot's shared entries, so rebuilds while an export streams
+// never shift rows between chunks.
+type Export struct {
+	entries []engine.RankedEntry
+}
+
+// Export pins the current snapshot for export.
+func (b *Board) Export() *Export {
+	return &Export{entries: b.snapshot.ViewTop(math.MaxInt)}
+}
+
+// Next returns up to n more entries and whether any remain after them.
+func (e *Export) Next(n int) ([]models.LeaderboardEntry, bool) {
+	n = min(n, len(e.entries))
+	chunk := toLeaderboardEntries(e.entries[:n], engine.RankStandard, 0)
+	e.entries = e.entries[n:]
+	return chunk, len(e.entries) > 0
+}
+
+// GetBottomN returns the n lowest-ranked users, last place first.
+func (b *Board) GetBottomN(n int) []models.LeaderboardEntry {
+	return toLeaderboardEntries(b.snapshot.GetBottom(n), engine.RankStandard, 0)
+}
+
+// GetRange returns the leaderboard rows at positions from through to.
+func (b *Board) GetRange(from, to int, mode engine.RankMode) ([]models.LeaderboardEntry, int) {
+	entries := b.snapshot.GetRange(from, to)
+
+	return toLeaderboardEntries(entries, mode, 0), b.snapshot.Size()
+}
+
+// GetAtRank returns the users holding the given rank, or nil if no one does.
+func (b *Board) GetAtRank(rank int) []models.LeaderboardEntry {
+	entries := b.snapshot.GetAtRank(rank)
+	if entries == nil {
+		return nil
+	}
+	return toLeaderboardEntries(entries, engine.RankStandard, 0)
+}
+
+// GetMovers reports the biggest rank changes between the last two rebuilds
+// among users now ranked within window (0 for all).
+func (b *Board) GetMovers(window, limit int) *models.MoversResponse {
+	m := b.snapshot.Movers(window, limit)
+	return &models.MoversResponse{
+		Climbers:   toMovers(m.Climbers),
+		Fallers:    toMovers(m.Fallers),
+		Entered:    toMovers(m.Entered),
+		Removed:    m.Removed,
+		Window:     window,
+		Generation: m.Generation,
+	}
+}
+
+func toMovers(movers []engine.Mover) []models.Mover {
+	result := make([]models.Mover, len(movers))
+	for i, m := range movers {
+		result[i] = models.Mover{
+			UserID:       m.UserID,
+			Username:     m.Username,
+			PreviousRank: m.OldRank,
+			Rank:         m.NewRank,
+			RankDelta:    m.Delta,
+		}
+	}
+	return result
+}
+
+// RankThreshold reports the score needed to reach rank and, if userID is
+// given, how far that user is from it.
+func (b *Board) RankThreshold(rank int, userID string) (*models.RankThreshold, error) {
+	if rank < 1 {
+		return nil, &ValidationError{"rank must be a positive integer"}
+	}
+
+	result := &models.RankThreshold{Rank: rank, TotalUsers: b.snapshot.Size()}
+	threshold, ok := b.snapshot.ScoreAt(rank)
+	if ok {
+		result.Rating = &threshold
+	}
+
+	if userID == "" {
+		return result, nil
+	}
+	entry, found := b.cache.Get(userID)
+	if !found {
+		return nil, ErrUserNotFound
+	}
+
+	result.UserID = userID
+	result.UserRating = &entry.Score
+	result.UserRank = b.snapshot.GetRank(userID)
+
+	delta := 0
+	if ok {
+		delta = threshold - entry.Score
+		if b.snapshot.Ascending() {
+			delta = -delta
+		}
+		if delta < 0 {
+			delta = 0
+		}
+	}
+	reached := delta == 0
+	result.Delta = &delta
+	result.Reached = &reached
+	return result, nil
+}
+
+// PreviewRank reports the rank, tier, percentile and neighbors a new user
+// with the given score would have. It does not modify any state.
+func (b *Board) PreviewRank(score, neighbors int) (*models.RankPreview, error) {
+	if score < minScore || score > maxScore {
+		return nil, &ValidationError{"Score must be between 100 and 5000"}
+	}
+
+	// Tiered by percentile, as GetUserTier does, so a preview names the
+	// tier the user would actually get
+	percentile := b.snapshot.Percentile(score)
+	return &models.RankPreview{
+		Rating:     score,
+		Rank:       b.snapshot.RankForScore(score),
+		Tier:       tierForPercentile(percentile),
+		Percentile: percentile,
+		TotalUsers: b.snapshot.Size(),
+		Neighbors:  toLeaderboardEntries(b.snapshot.NearScore(score, neighbors), engine.RankStandard, 0),
+	}, nil
+}
+
+// userResponse builds the API view of a cached user with their current rank.
+func (b *Board) userResponse(userID string, entry cache.Entry) models.UserResponse {
+	rank, tied := b.snapshot.GetRankWithTies(userID)
+	return rankedUserResponse(userID, entry, rank, tied)
+}
+
+// searchResponse is the user response for a search hit, with the matched
+// part of the username.
+func (b *Board) searchResponse(r cache.SearchResult) models.UserResponse {
+	user := b.userResponse(r.UserID, r.Entry)
+	user.Match = &models.MatchRange{Start: r.MatchStart, End: r.MatchEnd}
+	return user
+}
+
+// rankedUserResponse builds a UserResponse from an already looked-up rank.
+func rankedUserResponse(userID string, entry cache.Entry, rank, tied int) models.UserResponse {
+	response := models.UserResponse{
+		UserID:    userID,
+		Username:  entry.Username,
+		Rating:    entry.Score,
+		Country:   entry.Country,
+		Rank:      rank,
+		TiedCount: tied,
+		CreatedAt: entry.CreatedAt,
+		UpdatedAt: entry.UpdatedAt,
+	}
+	if rank > 0 {
+		response.DisplayRank = models.DisplayRank(rank, tied)
+	}
+	return response
+}
+
+// leaderboardPage reads a page of the snapshot, shared or copied as
+// configured. Callers only read the entries.
+func (b *Board) leaderboardPage(page, limit int) ([]engine.RankedEntry, int, uint64) {
+	if sharedReads {
+		return b.snapshot.ViewLeaderboard(page, limit)
 	}
+	return b.snapshot.GetLeaderboard(page, limit)
 }
 
-func GetTopN(n int) []models.LeaderboardEntry {
-	entries := engine.Global.GetTop(n)
+// topEntries reads the first n entries of the snapshot, shared or copied as
+// configured. Callers only read the entries.
+func (b *Board) topEntries(n int) []engine.RankedEntry {
+	if sharedReads {
+		return b.snapshot.ViewTop(n)
+	}
+	return b.snapshot.GetTop(n)
+}
 
+// toLeaderboardEntries converts snapshot rows, ranking them by mode less offset.
+func toLeaderboardEntries(entries []engine.RankedEntry, mode engine.RankMode, offset int) []models.LeaderboardEntry {
 	result := make([]models.LeaderboardEntry, len(entries))
 	for i, e := range entries {
+		rank := e.RankFor(mode) - offset
 		result[i] = models.LeaderboardEntry{
-			UserID:   e.UserID,
-			Username: e.Username,
-			Rating:   e.Score,
-			Rank:     e.Rank,
+			UserID:      e.UserID,
+			Username:    e.Username,
+			Country:     e.Country,
+			Rating:      e.Score,
+			Rank:        rank,
+			TiedCount:   e.TiedCount,
+			DisplayRank: models.DisplayRank(rank, e.TiedCount),
 		}
 	}
 	return result
 }
 
-func SearchByPrefix(prefix string, limit int) []models.UserResponse {
-	results := cache.Global.SearchByPrefix(prefix, limit)
+// SearchByPrefix returns one page of users whose username starts with prefix
+// and the total number of matches.
+func (b *Board) SearchByPrefix(prefix string, offset, limit int) ([]models.UserResponse, int) {
+	results, total := b.cache.SearchByPrefix(prefix, offset, limit)
 
 	users := make([]models.UserResponse, len(results))
 	for i, r := range results {
-		users[i] = models.UserResponse{
-			UserID:   r.UserID,
-			Username: r.Username,
-			Rating:   r.Score,
-			Rank:     engine.Global.GetRank(r.UserID),
-		}
+		users[i] = b.searchResponse(r)
+	}
+	return users, total
+}
+
+// SearchFuzzy finds usernames within maxDistance edits of query.
+func (b *Board) SearchFuzzy(query string, maxDistance, limit int) []models.FuzzyMatch {
+	results := b.cache.SearchFuzzy(query, maxDistance, limit)
+
+	users := make([]models.FuzzyMatch, len(results))
+	for i, r := range results {
+		users[i] = models.FuzzyMatch{UserResponse: b.searchResponse(r), Distance: r.Distance}
 	}
 	return users
 }
 
-func GetUserByID(userID string) *models.UserResponse {
-	entry, ok := cache.Global.Get(userID)
+func (b *Board) GetUserByID(userID string) *models.UserResponse {
+	entry, ok := b.cache.Get(userID)
 	if !ok {
 		return nil
 	}
 
-	return &models.UserResponse{
-		UserID:   userID,
-		Username: entry.Username,
-		Rating:   entry.Score,
-		Rank:     engine.Global.GetRank(userID),
+	user := b.userResponse(userID, entry)
+	return &user
+}
+
+// GetUserByUsername returns the user with exactly this username, with rank.
+// Matching is case-sensitive, like the uniqueness check on create.
+func (b *Board) GetUserByUsername(username string) *models.UserResponse {
+	userID, entry, ok := b.cache.GetByUsername(username)
+	if !ok {
+		return nil
+	}
+
+	user := b.userResponse(userID, entry)
+	return &user
+}
+
+// GetRanks looks up many users at once. Unknown IDs are returned in notFound.
+func (b *Board) GetRanks(userIDs []string) (map[string]models.UserResponse, []string) {
+	users := make(map[string]models.UserResponse, len(userIDs))
+	notFound := []string{}
+
+	ranks, tied := b.snapshot.GetRanksWithTies(userIDs)
+	for _, id := range userIDs {
+		entry, ok := b.cache.Get(id)
+		if !ok {
+			notFound = append(notFound, id)
+			continue
+		}
+		users[id] = rankedUserResponse(id, entry, ranks[id], tied[id])
+	}
+	return users, notFound
+}
+
+// CompareUsers returns two users and the gap between them, with both ranks
+// read from the same rebuild, or nil if either user doesn't exist.
+func (b *Board) CompareUsers(userID, otherID string) *models.UserComparison {
+	entry, ok := b.cache.Get(userID)
+	if !ok {
+		return nil
+	}
+	otherEntry, ok := b.cache.Get(otherID)
+	if !ok {
+		return nil
+	}
+
+	ranks, tied := b.snapshot.GetRanksWithTies([]string{userID, otherID})
+	comparison := &models.UserComparison{
+		User:      rankedUserResponse(userID, entry, ranks[userID], tied[userID]),
+		Other:     rankedUserResponse(otherID, otherEntry, ranks[otherID], tied[otherID]),
+		ScoreDiff: entry.Score - otherEntry.Score,
+	}
+	if ranks[userID] > 0 && ranks[otherID] > 0 {
+		diff := ranks[userID] - ranks[otherID]
+		comparison.RankDiff = &diff
 	}
+	return comparison
 }
 
-func CreateUser(ctx context.Context, username string, score int) (*models.UserResponse, error) {
-	if score < 100 || score > 5000 {
+// CreateUser adds a user to the board. A zero score means none was given,
+// and the user starts at DEFAULT_SCORE. Components, if given, replace the
+// score with their weighted composite and are stored alongside it.
+func (b *Board) CreateUser(ctx context.Context, username string, score int, country string, components map[string]float64) (*models.UserResponse, error) {
+	if len(components) > 0 {
+		if score != 0 {
+			return nil, &ValidationError{"give either a score or components, not both"}
+		}
+		var err error
+		if score, err = compositeScore(components); err != nil {
+			return nil, err
+		}
+	}
+	if score == 0 {
+		score = defaultScore
+	}
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
+	if score < minScore || score > maxScore {
 		return nil, &ValidationError{"Score must be between 100 and 5000"}
 	}
+	country, err := normalizeCountry(country)
+	if err != nil {
+		return nil, err
+	}
 
-	user := models.User{Username: username, Score: score}
+	if _, _, taken := b.cache.GetByUsername(username); taken {
+		return nil, ErrUsernameTaken
+	}
+
+	now := time.Now()
+	user := models.User{
+		Username:   username,
+		Score:      score,
+		Country:    country,
+		Board:      b.storedBoard(),
+		Active:     true,
+		Components: components,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	ctx, cancel := dbContext(ctx)
+	defer cancel()
 	result, err := database.Collection("users").InsertOne(ctx, user)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrUsernameTaken
+		}
 		return nil, err
 	}
 
 	userID := result.InsertedID.(primitive.ObjectID).Hex()
-	cache.Global.Set(userID, cache.Entry{Username: username, Score: score})
-	scheduleRebuild()
+	entry := cache.Entry{Username: username, Score: score, Country: country, CreatedAt: now, UpdatedAt: now}
+	b.cache.Set(userID, entry)
+	b.scheduleRebuild()
 
 	return &models.UserResponse{
-		UserID:   userID,
-		Username: username,
-		Rating:   score,
+		UserID:    userID,
+		Username:  username,
+		Rating:    score,
+		Country:   country,
+		CreatedAt: now,
+		UpdatedAt: now,
 	}, nil
 }
 
-func UpdateScore(ctx context.Context, userID string, newScore int) (*models.UserResponse, error) {
-	if newScore < 100 || newScore > 5000 {
+// CreateUsersBatch inserts users with a single unordered InsertMany. Every
+// user is validated first; if any fail, nothing is written and the failures
+// are returned together as ValidationErrors. Users with a zero score start at
+// DEFAULT_SCORE. With partial, the valid users
+// are inserted and the invalid ones reported per item instead. Either way a
+// user can still fail at insert time, e.g. if its name is taken meanwhile.
+func (b *Board) CreateUsersBatch(ctx context.Context, users []models.NewUser, partial bool) ([]models.BatchCreateItem, error) {
+	for i := range users {
+		if users[i].Score == 0 {
+			users[i].Score = defaultScore
+		}
+	}
+
+	if !partial {
+		var errs ValidationErrors
+		for i, msg := range b.validateNewUsers(users) {
+			if msg != "" {
+				errs = append(errs, models.BatchCreateItem{Index: i, Username: users[i].Username, Error: msg})
+			}
+		}
+		if len(errs) > 0 {
+			return nil, errs
+		}
+	}
+
+	results, inserted := b.insertUsers(ctx, users)
+	if inserted > 0 {
+		b.scheduleRebuild()
+	}
+	return results, nil
+}
+
+// validateNewUsers returns, for each user, why it cannot be created or ""
+// if it can. A name repeated within the batch counts as taken after its
+// first use.
+func (b *Board) validateNewUsers(users []models.NewUser) []string {
+	errs := make([]string, len(users))
+	seen := make(map[string]bool, len(users))
+	for i, u := range users {
+		if err := validateUsername(u.Username); err != nil {
+			errs[i] = err.Error()
+			continue
+		}
+		if u.Score < minScore || u.Score > maxScore {
+			errs[i] = "Score must be between 100 and 5000"
+			continue
+		}
+		if _, err := normalizeCountry(u.Country); err != nil {
+			errs[i] = err.Error()
+			continue
+		}
+		if _, _, taken := b.cache.GetByUsername(u.Username); taken || seen[u.Username] {
+			errs[i] = ErrUsernameTaken.Message
+			continue
+		}
+		seen[u.Username] = true
+	}
+	return errs
+}
+
+// insertUsers inserts the valid users and reports every user's outcome. It
+// does not schedule a rebuild, so callers inserting many batches can rebuild
+// once at the end.
+func (b *Board) insertUsers(ctx context.Context, users []models.NewUser) ([]models.BatchCreateItem, int) {
+	results := make([]models.BatchCreateItem, len(users))
+	var docs []interface{}
+	var docIndex []int
+	now := time.Now()
+
+	errs := b.validateNewUsers(users)
+	for i, u := range users {
+		results[i] = models.BatchCreateItem{Index: i, Username: u.Username}
+		if errs[i] != "" {
+			results[i].Error = errs[i]
+			continue
+		}
+
+		// Already validated, so only the upper-casing remains
+		country, _ := normalizeCountry(u.Country)
+		docs = append(docs, models.User{
+			ID:        primitive.NewObjectID(),
+			Username:  u.Username,
+			Score:     u.Score,
+			Country:   country,
+			Board:     b.storedBoard(),
+			Active:    true,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+		docIndex = append(docIndex, i)
+	}
+
+	if len(docs) == 0 {
+		return results, 0
+	}
+
+	insertCtx, cancel := dbContext(ctx)
+	defer cancel()
+	_, err := database.Collection("users").InsertMany(insertCtx, docs, options.InsertMany().SetOrdered(false))
+	failed := writeFailures(err, len(docs))
+
+	inserted := 0
+	for i, doc := range docs {
+		item := &results[docIndex[i]]
+		if we, ok := failed[i]; ok {
+			item.Error = we.Message
+			if we.Code == duplicateKeyCode {
+				item.Error = ErrUsernameTaken.Message
+			}
+			continue
+		}
+
+		user := doc.(models.User)
+		userID := user.ID.Hex()
+		b.cache.Set(userID, cache.Entry{Username: user.Username, Score: user.Score, Country: user.Country, CreatedAt: now, UpdatedAt: now})
+		item.Success = true
+		item.User = &models.UserResponse{
+			UserID:    userID,
+			Username:  user.Username,
+			Rating:    user.Score,
+			Country:   user.Country,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		inserted++
+	}
+	return results, inserted
+}
+
+// DeleteByPrefix removes up to limit users whose username starts with prefix,
+// matched the same way as SearchByPrefix so a search previews the deletion.
+// Returns the deleted IDs and whether more users still match.
+func (b *Board) DeleteByPrefix(ctx context.Context, prefix string, limit int) ([]string, bool, error) {
+	if prefix == "" {
+		return nil, false, &ValidationError{"prefix is required"}
+	}
+
+	matches, total := b.cache.SearchByPrefix(prefix, 0, limit)
+	more := total > limit
+	if len(matches) == 0 {
+		return []string{}, false, nil
+	}
+
+	ids := make([]string, len(matches))
+	objIDs := make([]primitive.ObjectID, 0, len(matches))
+	for i, m := range matches {
+		ids[i] = m.UserID
+		if objID, err := primitive.ObjectIDFromHex(m.UserID); err == nil {
+			objIDs = append(objIDs, objID)
+		}
+	}
+
+	err := withRetry(ctx, func(ctx context.Context) error {
+		_, err := database.Collection("users").DeleteMany(ctx, b.filter(bson.M{"_id": bson.M{"$in": objIDs}}))
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, id := range ids {
+		b.cache.Delete(id)
+	}
+	b.ForceRebuild()
+
+	return ids, more, nil
+}
+
+// DeleteByMaxScore removes every user scoring maxScore or less. The matching
+// IDs are read first and deleted with one DeleteMany, so the cache drops
+// exactly the users MongoDB deleted before rebuilding once. Returns the
+// number deleted from MongoDB.
+func (b *Board) DeleteByMaxScore(ctx context.Context, maxScore int) (int, error) {
+	var objIDs []primitive.ObjectID
+	err := withRetry(ctx, func(ctx context.Context) error {
+		cursor, err := database.Collection("users").Find(ctx,
+			b.filter(bson.M{"score": bson.M{"$lte": maxScore}}),
+			options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return err
+		}
+		var docs []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.All(ctx, &docs); err != nil {
+			return err
+		}
+		objIDs = make([]primitive.ObjectID, len(docs))
+		for i, doc := range docs {
+			objIDs[i] = doc.ID
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(objIDs) == 0 {
+		return 0, nil
+	}
+
+	var deleted int64
+	err = withRetry(ctx, func(ctx context.Context) error {
+		result, err := database.Collection("users").DeleteMany(ctx, b.filter(bson.M{"_id": bson.M{"$in": objIDs}}))
+		if err != nil {
+			return err
+		}
+		deleted = result.DeletedCount
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, objID := range objIDs {
+		b.cache.Delete(objID.Hex())
+	}
+	b.ForceRebuild()
+	return int(deleted), nil
+}
+
+// UpdateScore sets a user's score. The returned rank is projected from the
+// current snapshot so clients see the move immediately, even though the
+// snapshot itself is rebuilt on the debounce. Setting the score a user
+// already has writes nothing and schedules no rebuild. Any other direct
+// score write drops the user's score components, so a later rescore can't
+// overwrite it with the composite.
+func (b *Board) UpdateScore(ctx context.Context, userID string, newScore int) (*models.ScoreUpdateResponse, error) {
+	if newScore < minScore || newScore > maxScore {
 		return nil, &ValidationError{"Score must be between 100 and 5000"}
 	}
 
-	objID, err := primitive.ObjectIDFromHex(userID)
+	objID, err := parseUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	previousRank := b.snapshot.GetRank(userID)
+	if cached, ok := b.cache.Get(userID); ok && cached.Score == newScore {
+		return b.scoreUpdateResponse(userID, cached, previousRank), nil
+	}
+
+	now := time.Now()
+	var user models.User
+	err = withRetry(ctx, func(ctx context.Context) error {
+		return database.Collection("users").FindOneAndUpdate(
+			ctx,
+			b.activeUser(objID, nil),
+			bson.M{
+				"$set":   bson.M{"score": newScore, "updatedAt": now},
+				"$unset": bson.M{"components": ""},
+			},
+		).Decode(&user)
+	})
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	entry := cache.Entry{Username: user.Username, Score: newScore, Country: user.Country, CreatedAt: user.CreatedAt, UpdatedAt: now}
+	b.cache.Set(userID, entry)
+	b.scheduleRebuild()
+
+	response := b.scoreUpdateResponse(userID, entry, previousRank)
+	response.Changed = true
+	return response, nil
+}
+
+// IncrementScore adds delta to a user's score, clamped to the valid range.
+// The add and clamp happen in one update, so concurrent increments can't
+// lose each other's points or push the stored score out of range. Like
+// UpdateScore, it drops the user's score components.
+func (b *Board) IncrementScore(ctx context.Context, userID string, delta int) (*models.ScoreUpdateResponse, error) {
+	if delta == 0 {
+		return nil, &ValidationError{"delta must be non-zero"}
+	}
+
+	objID, err := parseUserID(userID)
 	if err != nil {
 		return nil, err
 	}
 
+	previousRank := b.snapshot.GetRank(userID)
+
+	ctx, cancel := dbContext(ctx)
+	defer cancel()
+
+	// An update pipeline behaves like $inc but can clamp the result
+	now := time.Now()
+	incremented := bson.M{"$add": bson.A{"$score", delta}}
 	var user models.User
 	err = database.Collection("users").FindOneAndUpdate(
 		ctx,
-		bson.M{"_id": objID},
-		bson.M{"$set": bson.M{"score": newScore}},
+		b.activeUser(objID, nil),
+		bson.A{bson.M{"$set": bson.M{
+			"score":     bson.M{"$min": bson.A{maxScore, bson.M{"$max": bson.A{minScore, incremented}}}},
+			"updatedAt": now,
+		}}, bson.M{"$unset": "components"}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
 	).Decode(&user)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 
-	cache.Global.Set(userID, cache.Entry{Username: user.Username, Score: newScore})
-	scheduleRebuild()
+	entry := cache.Entry{Username: user.Username, Score: user.Score, Country: user.Country, CreatedAt: user.CreatedAt, UpdatedAt: now}
+	b.cache.Set(userID, entry)
+	b.scheduleRebuild()
 
-	return &models.UserResponse{
-		UserID:   userID,
-		Username: user.Username,
-		Rating:   newScore,
-		Rank:     engine.Global.GetRank(userID),
-	}, nil
+	response := b.scoreUpdateResponse(userID, entry, previousRank)
+	response.Changed = true
+	return response, nil
 }
 
-func BulkUpdateRandom(ctx context.Context, count int) (*models.BulkUpdateResult, error) {
-	start := time.Now()
+// ScoreMode selects how UpdateScore combines the submitted and stored scores.
+type ScoreMode string
+
+const (
+	// ScoreSet always overwrites the stored score.
+	ScoreSet ScoreMode = "set"
+	// ScoreMax only applies a score higher than the stored one.
+	ScoreMax ScoreMode = "max"
+	// ScoreMin only applies a score lower than the stored one.
+	ScoreMin ScoreMode = "min"
+)
 
-	allUsers := cache.Global.GetAllWithIDs()
-	userIDs := make([]string, 0, len(allUsers))
-	for id := range allUsers {
-		userIDs = append(userIDs, id)
+// ParseScoreMode converts a query value into a ScoreMode.
+// An empty value selects ScoreSet.
+func ParseScoreMode(v string) (ScoreMode, bool) {
+	switch ScoreMode(v) {
+	case "", ScoreSet:
+		return ScoreSet, true
+	case ScoreMax:
+		return ScoreMax, true
+	case ScoreMin:
+		return ScoreMin, true
 	}
+	return "", false
+}
 
-	if count > len(userIDs) {
-		count = len(userIDs)
+// UpdateScoreIf applies newScore only if it beats the stored score under
+// mode, so concurrent submissions can't clobber a better one. The check and
+// write are a single conditional update with $max/$min semantics; updatedAt
+// only moves when the score does, which keeps time tie-breaks honest, and
+// the user's score components are only dropped when it moves.
+// The response carries the effective score, which may be the stored one.
+func (b *Board) UpdateScoreIf(ctx context.Context, userID string, newScore int, mode ScoreMode) (*models.ScoreUpdateResponse, error) {
+	if mode == ScoreSet {
+		return b.UpdateScore(ctx, userID, newScore)
+	}
+	if newScore < minScore || newScore > maxScore {
+		return nil, &ValidationError{"Score must be between 100 and 5000"}
 	}
 
-	rand.Shuffle(len(userIDs), func(i, j int) {
-		userIDs[i], userIDs[j] = userIDs[j], userIDs[i]
-	})
-	userIDs = userIDs[:count]
+	objID, err := parseUserID(userID)
+	if err != nil {
+		return nil, err
+	}
 
-	updated := 0
-	for _, id := range userIDs {
-		newScore := rand.Intn(4901) + 100
-		objID, _ := primitive.ObjectIDFromHex(id)
+	op := "$lt"
+	if mode == ScoreMin {
+		op = "$gt"
+	}
+
+	previousRank := b.snapshot.GetRank(userID)
+	users := database.Collection("users")
+	ctx, cancel := dbContext(ctx)
+	defer cancel()
+
+	now := time.Now()
+	changed := true
+	var user models.User
+	err = users.FindOneAndUpdate(
+		ctx,
+		b.activeUser(objID, bson.M{"score": bson.M{op: newScore}}),
+		bson.M{
+			"$set":   bson.M{"score": newScore, "updatedAt": now},
+			"$unset": bson.M{"components": ""},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		// Either the user doesn't exist or their score already wins
+		changed = false
+		err = users.FindOne(ctx, b.activeUser(objID, nil)).Decode(&user)
+	}
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	entry := cache.Entry{Username: user.Username, Score: user.Score, Country: user.Country, CreatedAt: user.CreatedAt, UpdatedAt: user.UpdatedAt}
+	if cached, ok := b.cache.Get(userID); changed || !ok || cached.Score != entry.Score {
+		b.cache.Set(userID, entry)
+		b.scheduleRebuild()
+	}
+
+	response := b.scoreUpdateResponse(userID, entry, previousRank)
+	response.Changed = changed
+	return response, nil
+}
 
-		_, err := database.Collection("users").UpdateOne(
+func (b *Board) scoreUpdateResponse(userID string, entry cache.Entry, previousRank int) *models.ScoreUpdateResponse {
+	response := &models.ScoreUpdateResponse{
+		UserResponse: b.userResponse(userID, entry),
+		PreviousRank: previousRank,
+	}
+	response.Rank = b.snapshot.ProjectedRank(userID, entry.Score)
+	if previousRank > 0 {
+		response.RankDelta = previousRank - response.Rank
+	}
+	return response
+}
+
+// UpdateUsername renames a user, keeping their score. A rebuild is scheduled
+// because the snapshot orders tied scores by username.
+func (b *Board) UpdateUsername(ctx context.Context, userID, username string) (*models.UserResponse, error) {
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
+	if id, _, taken := b.cache.GetByUsername(username); taken && id != userID {
+		return nil, ErrUsernameTaken
+	}
+
+	objID, err := parseUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	err = withRetry(ctx, func(ctx context.Context) error {
+		return database.Collection("users").FindOneAndUpdate(
 			ctx,
-			bson.M{"_id": objID},
-			bson.M{"$set": bson.M{"score": newScore}},
-		)
-		if err == nil {
-			entry, _ := cache.Global.Get(id)
-			cache.Global.Set(id, cache.Entry{Username: entry.Username, Score: newScore})
-			updated++
+			b.activeUser(objID, nil),
+			bson.M{"$set": bson.M{"username": username}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&user)
+	})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrUsernameTaken
 		}
+		return nil, notFound(err)
 	}
 
-	ForceRebuild()
-	duration := time.Since(start)
+	entry := cache.Entry{
+		Username:  user.Username,
+		Score:     user.Score,
+		Country:   user.Country,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}
+	b.cache.Set(userID, entry)
+	b.scheduleRebuild()
 
-	return &models.BulkUpdateResult{
-		Updated:       updated,
-		DurationMs:    duration.Milliseconds(),
-		UpdatesPerSec: float64(updated) / duration.Seconds(),
-	}, nil
+	response := b.userResponse(userID, entry)
+	return &response, nil
 }
 
-func BulkUpdateToValue(ctx context.Context, count, targetScore int) (*models.BulkUpdateResult, error) {
-	if targetScore < 100 || targetScore > 5000 {
-		return nil, &ValidationError{"Score must be between 100 and 5000"}
+// UpdateCountry sets or, with an empty code, clears a user's country. A
+// rebuild is scheduled so the regional leaderboards pick it up.
+func (b *Board) UpdateCountry(ctx context.Context, userID, country string) (*models.UserResponse, error) {
+	country, err := normalizeCountry(country)
+	if err != nil {
+		return nil, err
+	}
+
+	objID, err := parseUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	update := bson.M{"$set": bson.M{"country": country}}
+	if country == "" {
+		update = bson.M{"$unset": bson.M{"country": ""}}
+	}
+
+	var user models.User
+	err = withRetry(ctx, func(ctx context.Context) error {
+		return database.Collection("users").FindOneAndUpdate(
+			ctx,
+			b.activeUser(objID, nil),
+			update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&user)
+	})
+	if err != nil {
+		return nil, notFound(err)
 	}
 
+	entry := cache.Entry{
+		Username:  user.Username,
+		Score:     user.Score,
+		Country:   user.Country,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}
+	b.cache.Set(userID, entry)
+	b.scheduleRebuild()
+
+	response := b.userResponse(userID, entry)
+	return &response, nil
+}
+
+// bulkWriteBatchSize bounds the number of operations sent per BulkWrite.
+const bulkWriteBatchSize = 1000
+
+// BulkProgress is told how many of total users a bulk update has written so
+// far. It is called after every batch of bulkWriteBatchSize writes, from the
+// updating goroutine, so it must not block.
+type BulkProgress func(updated, total int)
+
+// BulkUpdateRandom gives count random users a random score. With dryRun
+// nothing is written; the preview shows one possible draw, and a real run
+// afterwards picks different users and scores.
+// A non-nil progress follows the writes.
+func (b *Board) BulkUpdateRandom(ctx context.Context, count int, dryRun bool, progress BulkProgress) (*models.BulkUpdateResult, error) {
 	start := time.Now()
 
-	allUsers := cache.Global.GetAllWithIDs()
-	userIDs := make([]string, 0, len(allUsers))
-	for id := range allUsers {
-		userIDs = append(userIDs, id)
+	userIDs := b.cache.GetRandomIDs(count)
+	scores := make([]int, len(userIDs))
+	for i := range scores {
+		scores[i] = rand.Intn(maxScore-minScore+1) + minScore
 	}
 
-	if count > len(userIDs) {
-		count = len(userIDs)
+	if dryRun {
+		return b.previewScores(start, userIDs, scores), nil
 	}
+	return b.applyScores(ctx, start, userIDs, scores, progress)
+}
 
-	rand.Shuffle(len(userIDs), func(i, j int) {
-		userIDs[i], userIDs[j] = userIDs[j], userIDs[i]
-	})
-	userIDs = userIDs[:count]
+// BulkUpdateToValue sets count random users to targetScore. With dryRun
+// nothing is written and the affected users are returned instead.
+// A non-nil progress follows the writes.
+func (b *Board) BulkUpdateToValue(ctx context.Context, count, targetScore int, dryRun bool, progress BulkProgress) (*models.BulkUpdateResult, error) {
+	if targetScore < minScore || targetScore > maxScore {
+		return nil, &ValidationError{"Score must be between 100 and 5000"}
+	}
 
-	updated := 0
-	for _, id := range userIDs {
-		objID, _ := primitive.ObjectIDFromHex(id)
+	start := time.Now()
 
-		_, err := database.Collection("users").UpdateOne(
-			ctx,
-			bson.M{"_id": objID},
-			bson.M{"$set": bson.M{"score": targetScore}},
-		)
-		if err == nil {
-			entry, _ := cache.Global.Get(id)
-			cache.Global.Set(id, cache.Entry{Username: entry.Username, Score: targetScore})
-			updated++
+	userIDs := b.cache.GetRandomIDs(count)
+	scores := make([]int, len(userIDs))
+	for i := range scores {
+		scores[i] = targetScore
+	}
+
+	if dryRun {
+		return b.previewScores(start, userIDs, scores), nil
+	}
+	return b.applyScores(ctx, start, userIDs, scores, progress)
+}
+
+// applyScores writes the scores, rebuilds the snapshot and reports throughput
+// measured from start.
+func (b *Board) applyScores(ctx context.Context, start time.Time, userIDs []string, scores []int, progress BulkProgress) (*models.BulkUpdateResult, error) {
+	updated, err := b.writeScores(ctx, userIDs, scores, progress)
+	if err != nil {
+		if updated > 0 {
+			b.ForceRebuild()
 		}
+		return nil, err
 	}
 
-	ForceRebuild()
+	b.ForceRebuild()
 	duration := time.Since(start)
 
 	return &models.BulkUpdateResult{
@@ -284,71 +1215,220 @@ func BulkUpdateToValue(ctx context.Context, count, targetScore int) (*models.Bul
 	}, nil
 }
 
-func GetStats() map[string]interface{} {
-	stats.mu.RLock()
-	defer stats.mu.RUnlock()
+// previewScores reports what applyScores would change, touching neither
+// MongoDB nor the cache. Ranks are the users' current ones.
+func (b *Board) previewScores(start time.Time, userIDs []string, scores []int) *models.BulkUpdateResult {
+	preview := make([]models.BulkUpdatePreview, 0, len(userIDs))
+	for i, id := range userIDs {
+		entry, ok := b.cache.Get(id)
+		if !ok {
+			continue
+		}
+		preview = append(preview, models.BulkUpdatePreview{
+			UserID:    id,
+			Username:  entry.Username,
+			Rank:      b.snapshot.GetRank(id),
+			Rating:    entry.Score,
+			NewRating: scores[i],
+		})
+	}
+
+	return &models.BulkUpdateResult{
+		Updated:    len(preview),
+		DurationMs: time.Since(start).Milliseconds(),
+		DryRun:     true,
+		Preview:    preview,
+	}
+}
+
+// writeScores sets userIDs[i] to scores[i] using unordered BulkWrite batches
+// and updates the cache for every write that succeeded. Like UpdateScore, it
+// drops the users' score components. It does not rebuild.
+// progress, if not nil, is called after each batch.
+func (b *Board) writeScores(ctx context.Context, userIDs []string, scores []int, progress BulkProgress) (int, error) {
+	now := time.Now()
+	updated := 0
+
+	for i := 0; i < len(userIDs); i += bulkWriteBatchSize {
+		end := i + bulkWriteBatchSize
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+
+		writes := make([]mongo.WriteModel, 0, end-i)
+		for j := i; j < end; j++ {
+			objID, _ := primitive.ObjectIDFromHex(userIDs[j])
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": objID}).
+				SetUpdate(bson.M{
+					"$set":   bson.M{"score": scores[j], "updatedAt": now},
+					"$unset": bson.M{"components": ""},
+				}))
+		}
+
+		failed := make(map[int]bool)
+		err := withRetry(ctx, func(ctx context.Context) error {
+			_, err := database.Collection("users").BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+			return err
+		})
+		if err != nil {
+			bwe, ok := err.(mongo.BulkWriteException)
+			if !ok {
+				return updated, err
+			}
+			for _, we := range bwe.WriteErrors {
+				failed[we.Index] = true
+			}
+		}
+
+		for j := i; j < end; j++ {
+			if failed[j-i] {
+				continue
+			}
+			entry, ok := b.cache.Get(userIDs[j])
+			if !ok {
+				continue
+			}
+			entry.Score, entry.UpdatedAt = scores[j], now
+			b.cache.Set(userIDs[j], entry)
+			updated++
+		}
+		if progress != nil {
+			progress(updated, len(userIDs))
+		}
+	}
+	return updated, nil
+}
+
+func (b *Board) GetStats() map[string]interface{} {
+	b.stats.mu.RLock()
+	defer b.stats.mu.RUnlock()
 
-	return map[string]interface{}{
-		"totalUsers":           cache.Global.Size(),
-		"pendingUpdates":       pendingUpdates,
-		"totalUpdates":         stats.TotalUpdates,
-		"rebuildsTriggered":    stats.RebuildsTriggered,
-		"avgUpdatesPerRebuild": stats.AvgUpdatesPerRebuild,
+	stats := map[string]interface{}{
+		"board":                b.ID,
+		"totalUsers":           b.cache.Size(),
+		"snapshotSize":         b.snapshot.Size(),
+		"generation":           b.snapshot.Generation(),
+		"pendingUpdates":       b.pendingUpdates.Load(),
+		"totalUpdates":         b.stats.TotalUpdates,
+		"rebuildsTriggered":    b.stats.RebuildsTriggered,
+		"avgUpdatesPerRebuild": b.stats.AvgUpdatesPerRebuild(),
+		"lastRebuild":          nil,
+		"snapshotAgeMs":        nil,
+		"lastReconciliation":   nil,
+	}
+	// A snapshot that keeps ageing while updates are pending means the
+	// debounce isn't firing
+	if !b.stats.LastRebuild.IsZero() {
+		stats["lastRebuild"] = b.stats.LastRebuild
+		stats["snapshotAgeMs"] = time.Since(b.stats.LastRebuild).Milliseconds()
+	}
+	if r := b.stats.LastReconcile; r != nil {
+		last := *r
+		stats["lastReconciliation"] = &last
 	}
+	return stats
 }
 
-func scheduleRebuild() {
-	rebuildMu.Lock()
-	defer rebuildMu.Unlock()
+// GetSummary returns the board's headline score figures.
+func (b *Board) GetSummary() *models.ScoreSummary {
+	sum := b.snapshot.Summary()
+	return &models.ScoreSummary{
+		TotalUsers:      sum.Count,
+		Highest:         sum.Highest,
+		Lowest:          sum.Lowest,
+		MostCommon:      sum.MostCommon,
+		MostCommonCount: sum.MostCommonCount,
+		TiedAtTop:       sum.TiedAtTop,
+	}
+}
 
-	pendingUpdates++
-	stats.mu.Lock()
-	stats.TotalUpdates++
-	stats.mu.Unlock()
+// GetDistribution returns score statistics and a histogram for the board.
+func (b *Board) GetDistribution(bucketSize int) *models.ScoreDistribution {
+	d := b.snapshot.Distribution(bucketSize)
 
-	if time.Since(lastRebuild) >= MaxRebuildDelayMS*time.Millisecond && pendingUpdates > 0 {
-		executeRebuild()
-		return
+	buckets := make([]models.ScoreBucket, len(d.Buckets))
+	for i, bucket := range d.Buckets {
+		buckets[i] = models.ScoreBucket{Min: bucket.Min, Max: bucket.Max, Count: bucket.Count}
 	}
 
-	if rebuildTimer != nil {
-		rebuildTimer.Stop()
+	return &models.ScoreDistribution{
+		TotalUsers: d.Count,
+		BucketSize: bucketSize,
+		Min:        d.Min,
+		Max:        d.Max,
+		Mean:       d.Mean,
+		Median:     d.Median,
+		P50:        d.Median,
+		P90:        d.P90,
+		P99:        d.P99,
+		Buckets:    buckets,
 	}
-	rebuildTimer = time.AfterFunc(RebuildDelayMS*time.Millisecond, func() {
-		rebuildMu.Lock()
-		defer rebuildMu.Unlock()
-		executeRebuild()
-	})
 }
 
-func executeRebuild() {
-	count := pendingUpdates
-	pendingUpdates = 0
-	lastRebuild = time.Now()
+// GetScoreCounts counts the users per bucketSize-point bucket from `from`
+// through `to`.
+func (b *Board) GetScoreCounts(from, to, bucketSize int) *models.ScoreCounts {
+	counts := b.snapshot.CountByScore(from, to, bucketSize)
 
-	stats.mu.Lock()
-	stats.RebuildsTriggered++
-	if stats.RebuildsTriggered > 0 {
-		stats.AvgUpdatesPerRebuild = float64(stats.TotalUpdates) / float64(stats.RebuildsTriggered)
+	buckets := make([]models.ScoreBucket, len(counts))
+	total := 0
+	for i, bucket := range counts {
+		buckets[i] = models.ScoreBucket{Min: bucket.Min, Max: bucket.Max, Count: bucket.Count}
+		total += bucket.Count
 	}
-	stats.mu.Unlock()
 
-	engine.Global.Rebuild(cache.Global.GetAllWithIDs())
-	log.Printf("🔄 Snapshot rebuilt (batched %d updates)", count)
+	return &models.ScoreCounts{
+		From:       from,
+		To:         to,
+		BucketSize: bucketSize,
+		Total:      total,
+		Buckets:    buckets,
+	}
 }
 
-func ForceRebuild() {
-	rebuildMu.Lock()
-	defer rebuildMu.Unlock()
+// duplicateKeyCode is the MongoDB error code for unique index violations.
+const duplicateKeyCode = 11000
 
-	if rebuildTimer != nil {
-		rebuildTimer.Stop()
+// writeFailures maps each write an unordered InsertMany or BulkWrite of
+// count writes failed to make, by index, to its write error; every other
+// write was made. When err doesn't say which writes failed, such as a
+// network error or an unacknowledged write concern, all of them are listed
+// with err's message.
+func writeFailures(err error, count int) map[int]mongo.WriteError {
+	if err == nil {
+		return nil
+	}
+	failed := make(map[int]mongo.WriteError)
+	if bwe, ok := err.(mongo.BulkWriteException); ok && bwe.WriteConcernError == nil {
+		for _, we := range bwe.WriteErrors {
+			failed[we.Index] = we.WriteError
+		}
+		return failed
 	}
-	pendingUpdates = 0
-	lastRebuild = time.Now()
-	engine.Global.Rebuild(cache.Global.GetAllWithIDs())
+	for i := 0; i < count; i++ {
+		failed[i] = mongo.WriteError{Index: i, Message: err.Error()}
+	}
+	return failed
+}
+
+// dbContext bounds a single MongoDB operation by the configured timeout.
+// Cancelling the parent, such as a client disconnecting, still cancels it.
+func dbContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, dbTimeout)
 }
 
+// IsTimeout reports whether err is a database operation running out of time.
+func IsTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err)
+}
+
+// Valid score range, inclusive.
+const (
+	minScore = 100
+	maxScore = 5000
+)
+
 type ValidationError struct {
 	Message string
 }
@@ -356,3 +1436,70 @@ type ValidationError struct {
 func (e *ValidationError) Error() string {
 	return e.Message
 }
+
+// ValidationErrors lists every invalid item of a batch, in request order,
+// so a client can fix them all in one go.
+type ValidationErrors []models.BatchCreateItem
+
+func (e ValidationErrors) Error() string {
+	return fmt.Sprintf("%d users in the batch are invalid", len(e))
+}
+
+// validateUsername rejects names outside the configured length range, with
+// leading or trailing whitespace, or containing control or invisible
+// formatting characters (such as zero-width joiners used for spoofing).
+func validateUsername(username string) error {
+	if username == "" {
+		return &ValidationError{"username is required"}
+	}
+	if n := utf8.RuneCountInString(username); n < usernameMinLength || n > usernameMaxLength {
+		return &ValidationError{fmt.Sprintf("username must be %d to %d characters", usernameMinLength, usernameMaxLength)}
+	}
+	if strings.TrimSpace(username) != username {
+		return &ValidationError{"username must not start or end with whitespace"}
+	}
+	for _, r := range username {
+		if r == utf8.RuneError || unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return &ValidationError{"username contains invalid characters"}
+		}
+	}
+	return nil
+}
+
+// normalizeCountry upper-cases an ISO 3166-1 alpha-2 country code. An empty
+// code is allowed and means the user has no country.
+func normalizeCountry(country string) (string, error) {
+	if country == "" {
+		return "", nil
+	}
+	country = strings.ToUpper(country)
+	if len(country) != 2 || country[0] < 'A' || country[0] > 'Z' || country[1] < 'A' || country[1] > 'Z' {
+		return "", &ValidationError{"country must be a two-letter ISO 3166-1 code"}
+	}
+	return country, nil
+}
+
+// ErrUsernameTaken is returned when a username collides with an existing user.
+var ErrUsernameTaken = &ValidationError{"username already taken"}
+
+// ErrInvalidUserID is returned when a user ID is not a valid ObjectID.
+var ErrInvalidUserID = &ValidationError{"invalid user id"}
+
+// ErrUserNotFound is returned when no user on the board has the given ID.
+var ErrUserNotFound = errors.New("user not found")
+
+func parseUserID(userID string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return objID, ErrInvalidUserID
+	}
+	return objID, nil
+}
+
+// notFound translates a missing document into ErrUserNotFound.
+func notFound(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return ErrUserNotFound
+	}
+	return err
+}
@@ -0,0 +1,536 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"matiks-leaderboard/database"
+	"matiks-leaderboard/database/dbtest"
+	"matiks-leaderboard/engine"
+	"matiks-leaderboard/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testUser is a stored user with a fresh ID.
+func testUser(username string, score int) models.User {
+	return models.User{ID: primitive.NewObjectID(), Username: username, Score: score}
+}
+
+// loadUsers installs an in-memory database holding users, loads it as at
+// startup and returns the default board and its users collection.
+func loadUsers(t testing.TB, users ...models.User) (*Board, *dbtest.Collection) {
+	t.Helper()
+	db := dbtest.Install(t)
+	coll := db.Collection("users")
+	for _, u := range users {
+		coll.Insert(u)
+	}
+	if err := Initialize(context.Background()); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	return DefaultBoard(), coll
+}
+
+type rankRow struct {
+	username string
+	rank     int
+	tied     int
+}
+
+func checkRanks(t *testing.T, got []models.LeaderboardEntry, want []rankRow) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i].Username != w.username || got[i].Rank != w.rank || got[i].TiedCount != w.tied {
+			t.Errorf("entry %d = %s rank %d tied %d, want %s rank %d tied %d",
+				i, got[i].Username, got[i].Rank, got[i].TiedCount, w.username, w.rank, w.tied)
+		}
+	}
+}
+
+func TestGetLeaderboardRanksAndTies(t *testing.T) {
+	b, _ := loadUsers(t,
+		testUser("dave", 100),
+		testUser("alice", 300),
+		testUser("bob", 200),
+		testUser("carol", 200),
+	)
+
+	page := b.GetLeaderboard(1, 10, engine.RankStandard)
+	if page.TotalUsers != 4 || page.TotalPages != 1 {
+		t.Fatalf("totals = %d users, %d pages; want 4, 1", page.TotalUsers, page.TotalPages)
+	}
+	checkRanks(t, page.Entries, []rankRow{
+		{"alice", 1, 1},
+		{"bob", 2, 2},
+		{"carol", 2, 2},
+		{"dave", 4, 1},
+	})
+
+	dense := b.GetLeaderboard(1, 10, engine.RankDense)
+	checkRanks(t, dense.Entries, []rankRow{
+		{"alice", 1, 1},
+		{"bob", 2, 2},
+		{"carol", 2, 2},
+		{"dave", 3, 1},
+	})
+
+	second := b.GetLeaderboard(2, 2, engine.RankStandard)
+	checkRanks(t, second.Entries, []rankRow{{"carol", 2, 2}, {"dave", 4, 1}})
+	if second.HasNext || !second.HasPrev {
+		t.Errorf("page 2 of 2: hasNext %v hasPrev %v", second.HasNext, second.HasPrev)
+	}
+}
+
+func TestCreateUserIsStoredAndRanked(t *testing.T) {
+	b, coll := loadUsers(t, testUser("alice", 300))
+
+	created, err := b.CreateUser(context.Background(), "bob", 400, "in", nil)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if created.Country != "IN" {
+		t.Errorf("country = %q, want IN", created.Country)
+	}
+
+	stored := coll.Docs(bson.M{"username": "bob"})
+	if len(stored) != 1 {
+		t.Fatalf("stored %d documents for bob, want 1", len(stored))
+	}
+	if id := stored[0]["_id"].(primitive.ObjectID).Hex(); id != created.UserID {
+		t.Errorf("stored _id %s, response userId %s", id, created.UserID)
+	}
+
+	b.ForceRebuild()
+	checkRanks(t, b.GetLeaderboard(1, 10, engine.RankStandard).Entries, []rankRow{
+		{"bob", 1, 1},
+		{"alice", 2, 1},
+	})
+}
+
+func TestCreateUserRejectsDuplicateUsername(t *testing.T) {
+	b, coll := loadUsers(t)
+	ctx := context.Background()
+
+	if _, err := b.CreateUser(ctx, "alice", 300, "", nil); err != nil {
+		t.Fatalf("first CreateUser: %v", err)
+	}
+	if _, err := b.CreateUser(ctx, "alice", 400, "", nil); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("second CreateUser: err = %v, want ErrUsernameTaken", err)
+	}
+
+	// The unique index catches a duplicate the cache hasn't seen yet
+	coll.Insert(testUser("bob", 200))
+	if _, err := b.CreateUser(ctx, "bob", 400, "", nil); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("CreateUser of an uncached name: err = %v, want ErrUsernameTaken", err)
+	}
+	if n := len(coll.Docs(bson.M{"username": "alice"})); n != 1 {
+		t.Errorf("stored %d documents for alice, want 1", n)
+	}
+	if got := b.GetUserByUsername("alice"); got == nil || got.Rating != 300 {
+		t.Errorf("alice after the duplicate = %+v, want rating 300", got)
+	}
+}
+
+func TestCreateUserWithoutScoreGetsDefault(t *testing.T) {
+	t.Cleanup(LoadScoreConfig)
+	b, coll := loadUsers(t)
+	ctx := context.Background()
+
+	for i, tc := range []struct {
+		env  string
+		want int
+	}{
+		{"", DefaultNewUserScore},
+		{"250", 250},
+		// Out of the score range, so the default stands
+		{strconv.Itoa(maxScore + 1), DefaultNewUserScore},
+	} {
+		t.Setenv("DEFAULT_SCORE", tc.env)
+		LoadScoreConfig()
+
+		username := "user" + strconv.Itoa(i)
+		created, err := b.CreateUser(ctx, username, 0, "", nil)
+		if err != nil {
+			t.Fatalf("DEFAULT_SCORE=%q: CreateUser: %v", tc.env, err)
+		}
+		if created.Rating != tc.want {
+			t.Errorf("DEFAULT_SCORE=%q: rating = %d, want %d", tc.env, created.Rating, tc.want)
+		}
+		stored := coll.Docs(bson.M{"username": username})
+		if len(stored) != 1 || stored[0]["score"] != int32(tc.want) {
+			t.Errorf("DEFAULT_SCORE=%q: stored %v, want score %d", tc.env, stored, tc.want)
+		}
+	}
+}
+
+func TestCreateUsersBatchKeepsUsersAroundADuplicate(t *testing.T) {
+	b, coll := loadUsers(t)
+	// Stored but not cached, so only the unique index rejects it mid-batch
+	coll.Insert(testUser("bob", 200))
+
+	results, err := b.CreateUsersBatch(context.Background(), []models.NewUser{
+		{Username: "alice", Score: 300},
+		{Username: "bob", Score: 400},
+		{Username: "carol", Score: 500},
+	}, true)
+	if err != nil {
+		t.Fatalf("CreateUsersBatch: %v", err)
+	}
+
+	for i, want := range []string{"", ErrUsernameTaken.Message, ""} {
+		if got := results[i]; got.Error != want || got.Success != (want == "") {
+			t.Errorf("result %d = %+v, want error %q", i, got, want)
+		}
+	}
+	if n := coll.Len(); n != 3 {
+		t.Errorf("stored %d users, want alice, carol and the original bob", n)
+	}
+	if stored := coll.Docs(bson.M{"username": "bob"}); len(stored) != 1 || stored[0]["score"] != int32(200) {
+		t.Errorf("bob stored as %v, want the original score 200", stored)
+	}
+
+	b.ForceRebuild()
+	checkRanks(t, b.GetLeaderboard(1, 10, engine.RankStandard).Entries, []rankRow{
+		{"carol", 1, 1},
+		{"alice", 2, 1},
+	})
+}
+
+func TestSeedBatchSkipsADuplicate(t *testing.T) {
+	_, coll := loadUsers(t, testUser("bob", 200))
+
+	written, err := insertSeedBatch(database.Collection("users"), []interface{}{
+		testUser("alice", 300),
+		testUser("bob", 400),
+		testUser("carol", 500),
+	}, 1)
+	if err != nil {
+		t.Fatalf("insertSeedBatch: %v", err)
+	}
+	if written != 2 {
+		t.Errorf("written = %d, want 2", written)
+	}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if n := len(coll.Docs(bson.M{"username": name})); n != 1 {
+			t.Errorf("stored %d documents for %s, want 1", n, name)
+		}
+	}
+}
+
+func TestUpdateScoreMovesUser(t *testing.T) {
+	carol := testUser("carol", 100)
+	b, coll := loadUsers(t, testUser("alice", 300), testUser("bob", 200), carol)
+
+	resp, err := b.UpdateScore(context.Background(), carol.ID.Hex(), 200)
+	if err != nil {
+		t.Fatalf("UpdateScore: %v", err)
+	}
+	if !resp.Changed || resp.PreviousRank != 3 || resp.Rank != 2 || resp.RankDelta != 1 {
+		t.Errorf("response changed %v, rank %d -> %d (delta %d); want true, 3 -> 2 (1)",
+			resp.Changed, resp.PreviousRank, resp.Rank, resp.RankDelta)
+	}
+	var stored models.User
+	if err := coll.FindOne(context.Background(), bson.M{"_id": carol.ID}).Decode(&stored); err != nil || stored.Score != 200 {
+		t.Errorf("stored carol = %+v (%v), want score 200", stored, err)
+	}
+
+	b.ForceRebuild()
+	checkRanks(t, b.GetLeaderboard(1, 10, engine.RankStandard).Entries, []rankRow{
+		{"alice", 1, 1},
+		{"bob", 2, 2},
+		{"carol", 2, 2},
+	})
+}
+
+func TestUnchangedScoreSkipsWriteAndRebuild(t *testing.T) {
+	// Long delays so a scheduled rebuild stays pending
+	setDebounce(t, time.Hour, time.Hour)
+	alice := testUser("alice", 300)
+	b, coll := loadUsers(t, alice, testUser("bob", 400))
+	id := alice.ID.Hex()
+
+	var calls []string
+	coll.OnCall = func(method string) { calls = append(calls, method) }
+	generation := b.snapshot.Generation()
+
+	resp, err := b.UpdateScore(context.Background(), id, 300)
+	if err != nil {
+		t.Fatalf("UpdateScore: %v", err)
+	}
+	if resp.Changed || resp.Rating != 300 || resp.PreviousRank != 2 || resp.Rank != 2 {
+		t.Errorf("no-op update = %+v, want unchanged at rank 2", resp)
+	}
+	if len(calls) != 0 {
+		t.Errorf("no-op update called the database: %v", calls)
+	}
+	if pending := b.pendingUpdates.Load(); pending != 0 {
+		t.Errorf("no-op update left %d updates pending, want 0", pending)
+	}
+	if got := b.snapshot.Generation(); got != generation {
+		t.Errorf("no-op update rebuilt the snapshot")
+	}
+
+	// A real change still writes and schedules a rebuild
+	if resp, err = b.UpdateScore(context.Background(), id, 500); err != nil {
+		t.Fatalf("UpdateScore: %v", err)
+	}
+	if !resp.Changed || len(calls) == 0 || b.pendingUpdates.Load() != 1 {
+		t.Errorf("changed update = %+v with calls %v and %d pending, want a write and 1 pending",
+			resp, calls, b.pendingUpdates.Load())
+	}
+}
+
+func TestUpdateScoreRejectsBadInput(t *testing.T) {
+	b, _ := loadUsers(t, testUser("alice", 300))
+
+	var verr *ValidationError
+	if _, err := b.UpdateScore(context.Background(), primitive.NewObjectID().Hex(), 99); !errors.As(err, &verr) {
+		t.Errorf("out-of-range score: err = %v, want a ValidationError", err)
+	}
+	if _, err := b.UpdateScore(context.Background(), primitive.NewObjectID().Hex(), 500); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("missing user: err = %v, want ErrUserNotFound", err)
+	}
+	if _, err := b.UpdateScore(context.Background(), "not-an-id", 500); !errors.Is(err, ErrInvalidUserID) {
+		t.Errorf("malformed ID: err = %v, want ErrInvalidUserID", err)
+	}
+}
+
+func TestExportReadsOneSnapshot(t *testing.T) {
+	users := make([]models.User, 5)
+	for i := range users {
+		users[i] = testUser("user"+strconv.Itoa(i), 500-i*10)
+	}
+	b, _ := loadUsers(t, users...)
+
+	export := b.Export()
+	first, more := export.Next(2)
+	if !more {
+		t.Fatal("export ended after 2 of 5 entries")
+	}
+
+	// The last user jumps to first place between chunks
+	if _, err := b.UpdateScore(context.Background(), users[4].ID.Hex(), 1000); err != nil {
+		t.Fatalf("UpdateScore: %v", err)
+	}
+	b.ForceRebuild()
+
+	var got []models.LeaderboardEntry
+	got = append(got, first...)
+	for more {
+		var chunk []models.LeaderboardEntry
+		chunk, more = export.Next(2)
+		got = append(got, chunk...)
+	}
+	want := make([]rankRow, len(users))
+	for i, u := range users {
+		want[i] = rankRow{u.Username, i + 1, 1}
+	}
+	checkRanks(t, got, want)
+}
+
+func TestInitializeSkipsOrRejectsMalformedUsers(t *testing.T) {
+	defer func(n int) { initMaxBadDocs = n }(initMaxBadDocs)
+	initMaxBadDocs = 1
+
+	b, coll := loadUsers(t, testUser("alice", 300), testUser("bob", 200))
+
+	// A score stored as a string can't decode into cachedUser
+	coll.Insert(bson.M{"_id": primitive.NewObjectID(), "username": "broken1", "score": "high"})
+	coll.Insert(testUser("carol", 100))
+	if err := Initialize(context.Background()); err != nil {
+		t.Fatalf("Initialize with one bad document: %v", err)
+	}
+	if got := b.cache.Size(); got != 3 {
+		t.Fatalf("loaded %d users, want 3", got)
+	}
+
+	// Over the limit the load fails, leaving the caches and boards as they were
+	coll.Insert(bson.M{"_id": primitive.NewObjectID(), "username": "broken2", "score": "low"})
+	coll.Insert(models.User{ID: primitive.NewObjectID(), Username: "dave", Score: 50, Board: "malformed-new"})
+	if err := Initialize(context.Background()); err == nil {
+		t.Fatal("Initialize succeeded with more than INIT_MAX_BAD_DOCS bad documents")
+	}
+	if got := b.cache.Size(); got != 3 {
+		t.Errorf("failed load left %d users, want the 3 loaded before", got)
+	}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if _, _, ok := b.cache.GetByUsername(name); !ok {
+			t.Errorf("failed load dropped %s", name)
+		}
+	}
+	if _, ok := GetBoard("malformed-new"); ok {
+		t.Error("failed load registered a board it found in MongoDB")
+	}
+}
+
+func TestDeleteByMaxScoreDropsExactlyTheDeletedUsers(t *testing.T) {
+	users := []models.User{testUser("alice", 300), testUser("bob", 900), testUser("carol", 200)}
+	b, coll := loadUsers(t, users...)
+	ctx := context.Background()
+
+	// The cache is stale for bob and carol: MongoDB holds the truth
+	for _, change := range []struct {
+		user  models.User
+		score int
+	}{{users[1], 150}, {users[2], 800}} {
+		if _, err := coll.UpdateMany(ctx, bson.M{"_id": change.user.ID}, bson.M{"$set": bson.M{"score": change.score}}); err != nil {
+			t.Fatalf("UpdateMany: %v", err)
+		}
+	}
+
+	deleted, err := b.DeleteByMaxScore(ctx, 500)
+	if err != nil {
+		t.Fatalf("DeleteByMaxScore: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("deleted %d users, want 2", deleted)
+	}
+	for _, u := range users {
+		_, cached := b.cache.Get(u.ID.Hex())
+		stored := len(coll.Docs(bson.M{"_id": u.ID})) == 1
+		if cached != stored {
+			t.Errorf("%s: cached %v but stored %v", u.Username, cached, stored)
+		}
+	}
+	if _, ok := b.cache.Get(users[2].ID.Hex()); !ok {
+		t.Error("carol was dropped from the cache although MongoDB kept her")
+	}
+}
+
+// benchRoundTrip stands in for the network latency of one MongoDB call.
+const benchRoundTrip = 100 * time.Microsecond
+
+// benchScoreWrites loads n users and returns their IDs and new scores, with
+// every collection call delayed by benchRoundTrip.
+func benchScoreWrites(b *testing.B, n int) (*Board, []string, []int) {
+	b.Helper()
+	users := make([]models.User, n)
+	for i := range users {
+		users[i] = testUser("user"+strconv.Itoa(i), 100+i%4900)
+	}
+	board, coll := loadUsers(b, users...)
+	coll.OnCall = func(string) { time.Sleep(benchRoundTrip) }
+
+	ids := make([]string, n)
+	scores := make([]int, n)
+	for i, u := range users {
+		ids[i] = u.ID.Hex()
+		scores[i] = 5000 - i%4900
+	}
+	return board, ids, scores
+}
+
+func BenchmarkWriteScores(b *testing.B) {
+	board, ids, scores := benchScoreWrites(b, 500)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := board.writeScores(ctx, ids, scores, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWriteScoresPerDocument is the loop writeScores replaced: one
+// update, and one round trip, per user.
+func BenchmarkWriteScoresPerDocument(b *testing.B) {
+	board, ids, scores := benchScoreWrites(b, 500)
+	ctx := context.Background()
+	users := database.Collection("users")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		now := time.Now()
+		for j, id := range ids {
+			objID, _ := primitive.ObjectIDFromHex(id)
+			if _, err := users.UpdateMany(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"score": scores[j], "updatedAt": now}}); err != nil {
+				b.Fatal(err)
+			}
+			entry, _ := board.cache.Get(id)
+			entry.Score, entry.UpdatedAt = scores[j], now
+			board.cache.Set(id, entry)
+		}
+	}
+}
+
+func TestNewLeaderboardResponsePagination(t *testing.T) {
+	for _, tc := range []struct {
+		name                     string
+		entries, total, page     int
+		wantPages                int
+		wantHasNext, wantHasPrev bool
+	}{
+		{"page 0", 0, 25, 0, 3, false, false},
+		{"first page", 10, 25, 1, 3, true, false},
+		{"middle page", 10, 25, 2, 3, true, true},
+		{"last partial page", 5, 25, 3, 3, false, true},
+		{"beyond the total", 0, 25, 4, 3, false, true},
+		{"empty board", 0, 0, 1, 0, false, false},
+	} {
+		var entries []models.LeaderboardEntry
+		if tc.entries > 0 {
+			entries = make([]models.LeaderboardEntry, tc.entries)
+		}
+		got := newLeaderboardResponse(entries, tc.total, tc.page, 10)
+		if got.Entries == nil || got.Count != tc.entries || got.TotalUsers != tc.total || got.Page != tc.page ||
+			got.TotalPages != tc.wantPages || got.HasNext != tc.wantHasNext || got.HasPrev != tc.wantHasPrev {
+			t.Errorf("%s: got count %d of %d, page %d of %d, hasNext %v, hasPrev %v (entries nil: %v); want count %d, page %d of %d, hasNext %v, hasPrev %v",
+				tc.name, got.Count, got.TotalUsers, got.Page, got.TotalPages, got.HasNext, got.HasPrev, got.Entries == nil,
+				tc.entries, tc.page, tc.wantPages, tc.wantHasNext, tc.wantHasPrev)
+		}
+	}
+}
+
+func TestGetLeaderboardLastPages(t *testing.T) {
+	users := make([]models.User, 25)
+	for i := range users {
+		users[i] = testUser("user"+strconv.Itoa(i), 1000+i)
+	}
+	b, _ := loadUsers(t, users...)
+
+	for page, want := range map[int]int{3: 5, 4: 0} {
+		got := b.GetLeaderboard(page, 10, engine.RankStandard)
+		if got.Count != want || len(got.Entries) != want || got.TotalUsers != 25 || got.TotalPages != 3 {
+			t.Errorf("page %d: %d entries of %d users over %d pages, want %d of 25 over 3", page, got.Count, got.TotalUsers, got.TotalPages, want)
+		}
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	for _, tc := range []struct {
+		name, username string
+		valid          bool
+	}{
+		{"plain", "alice", true},
+		{"inner space", "alice smith", true},
+		{"multibyte at the limit", strings.Repeat("é", DefaultUsernameMaxLength), true},
+		{"empty", "", false},
+		{"oversized", strings.Repeat("a", DefaultUsernameMaxLength+1), false},
+		{"multibyte oversized", strings.Repeat("é", DefaultUsernameMaxLength+1), false},
+		{"whitespace only", "   ", false},
+		{"tab only", "\t", false},
+		{"leading space", " alice", false},
+		{"trailing newline", "alice\n", false},
+		{"control character", "ali\x07ce", false},
+		{"null byte", "ali\x00ce", false},
+		{"zero-width space", "ali\u200bce", false},
+		{"invalid UTF-8", "ali\xffce", false},
+	} {
+		err := validateUsername(tc.username)
+		var verr *ValidationError
+		if tc.valid && err != nil {
+			t.Errorf("%s: %v, want valid", tc.name, err)
+		}
+		if !tc.valid && !errors.As(err, &verr) {
+			t.Errorf("%s: err = %v, want a ValidationError", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+// Package services contains the maintenance-mode switch.
+package services
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// maintenance freezes writes while set. It lives in memory only, so a
+// restart always comes back writable.
+var maintenance atomic.Bool
+
+// SetMaintenance turns maintenance mode on or off.
+func SetMaintenance(enabled bool) {
+	if maintenance.Swap(enabled) != enabled {
+		slog.Info("maintenance mode changed", "enabled", enabled)
+	}
+}
+
+// InMaintenance reports whether writes are frozen for maintenance.
+func InMaintenance() bool {
+	return maintenance.Load()
+}
@@ -0,0 +1,36 @@
+// Package services contains startup data migrations.
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"matiks-leaderboard/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// backfillTimestamps sets createdAt/updatedAt to now on users created before
+// timestamps were tracked. Safe to run on every startup.
+func backfillTimestamps(ctx context.Context) error {
+	now := time.Now()
+	collection := database.Collection("users")
+
+	for _, field := range []string{"createdAt", "updatedAt"} {
+		opCtx, cancel := dbContext(ctx)
+		result, err := collection.UpdateMany(
+			opCtx,
+			bson.M{field: bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{field: now}},
+		)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount > 0 {
+			log.Printf("🕒 Backfilled %s on %d users", field, result.ModifiedCount)
+		}
+	}
+	return nil
+}
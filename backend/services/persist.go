@@ -0,0 +1,146 @@
+// Package services persists board caches to a local file for fast restarts.
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"matiks-leaderboard/cache"
+)
+
+// snapshotFileVersion is bumped whenever the file layout changes. Files with
+// any other version are ignored rather than half-loaded.
+const snapshotFileVersion = 1
+
+type snapshotFile struct {
+	Version int                            `json:"version"`
+	SavedAt time.Time                      `json:"savedAt"`
+	Boards  map[string][]snapshotFileEntry `json:"boards"`
+}
+
+type snapshotFileEntry struct {
+	UserID    string    `json:"id"`
+	Username  string    `json:"u"`
+	Score     int       `json:"s"`
+	Country   string    `json:"k,omitempty"`
+	Seq       uint64    `json:"q,omitempty"`
+	CreatedAt time.Time `json:"c"`
+	UpdatedAt time.Time `json:"t"`
+}
+
+// dbLoaded is set once Initialize has loaded every board from MongoDB.
+// Until then the caches may hold stale data restored from SNAPSHOT_FILE.
+var dbLoaded atomic.Bool
+
+// Loaded reports whether the caches reflect MongoDB rather than a restored
+// snapshot file.
+func Loaded() bool {
+	return dbLoaded.Load()
+}
+
+// SaveSnapshotFile writes every board's cache to path. The file is written
+// beside path and renamed into place, so a crash never leaves a torn file.
+func SaveSnapshotFile(path string) error {
+	file := snapshotFile{
+		Version: snapshotFileVersion,
+		SavedAt: time.Now(),
+		Boards:  make(map[string][]snapshotFileEntry),
+	}
+	users := 0
+	for _, b := range Boards() {
+		entries := make([]snapshotFileEntry, 0, b.cache.Size())
+		err := b.cache.Range(func(id string, e cache.Entry) {
+			entries = append(entries, snapshotFileEntry{
+				UserID:    id,
+				Username:  e.Username,
+				Score:     e.Score,
+				Country:   e.Country,
+				Seq:       e.Seq,
+				CreatedAt: e.CreatedAt,
+				UpdatedAt: e.UpdatedAt,
+			})
+		})
+		if err != nil {
+			return err
+		}
+		file.Boards[b.ID] = entries
+		users += len(entries)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := json.NewEncoder(tmp).Encode(file); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	slog.Info("snapshot file saved", "path", path, "boards", len(file.Boards), "users", users)
+	return nil
+}
+
+// LoadSnapshotFile restores board caches from path and builds their
+// snapshots, so reads can be served before MongoDB is reachable. Returns the
+// number of users restored; a missing file restores nothing and is not an
+// error. Must be called before Initialize, which replaces the data.
+func LoadSnapshotFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var file snapshotFile
+	if err := json.NewDecoder(f).Decode(&file); err != nil {
+		return 0, fmt.Errorf("decoding snapshot file: %w", err)
+	}
+	if file.Version != snapshotFileVersion {
+		return 0, fmt.Errorf("snapshot file version %d, expected %d", file.Version, snapshotFileVersion)
+	}
+
+	users := 0
+	for id, entries := range file.Boards {
+		if id != DefaultBoardID && !boardIDPattern.MatchString(id) {
+			slog.Warn("skipping invalid board in snapshot file", "board", id)
+			continue
+		}
+		b := ensureBoard(id)
+		for _, e := range entries {
+			b.cache.Set(e.UserID, cache.Entry{
+				Username:  e.Username,
+				Score:     e.Score,
+				Country:   e.Country,
+				Seq:       e.Seq,
+				CreatedAt: e.CreatedAt,
+				UpdatedAt: e.UpdatedAt,
+			})
+		}
+		b.ForceRebuild()
+		users += len(entries)
+	}
+
+	slog.Info("snapshot file restored",
+		"path", path,
+		"users", users,
+		"saved_at", file.SavedAt,
+		"age", time.Since(file.SavedAt).Round(time.Second).String(),
+	)
+	return users, nil
+}
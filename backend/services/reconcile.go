@@ -0,0 +1,182 @@
+// Package services contains the cache/database reconciliation job.
+package services
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"matiks-leaderboard/database"
+	"matiks-leaderboard/env"
+	"matiks-leaderboard/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxLoggedDriftIDs caps how many drifted user IDs one log line lists.
+const maxLoggedDriftIDs = 20
+
+// StartReconcile launches the drift check if RECONCILE_ENABLED=true. Every
+// RECONCILE_INTERVAL it compares each board's cache with MongoDB and reloads
+// everything when more than RECONCILE_THRESHOLD users differ. Checks are
+// skipped in maintenance mode.
+func StartReconcile(ctx context.Context) {
+	if os.Getenv("RECONCILE_ENABLED") != "true" {
+		return
+	}
+
+	interval := env.PositiveDuration("RECONCILE_INTERVAL", 5*time.Minute)
+	threshold := env.PositiveInt("RECONCILE_THRESHOLD", 0)
+	slog.Info("cache reconciliation enabled", "interval", interval.String(), "threshold", threshold)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if InMaintenance() {
+					slog.Info("maintenance mode, skipping cache reconciliation")
+					continue
+				}
+				reconcile(ctx, threshold)
+			}
+		}
+	}()
+}
+
+// reconcile checks every board and reloads from MongoDB if any drifted past
+// threshold.
+func reconcile(ctx context.Context, threshold int) {
+	drifted := false
+	for _, b := range Boards() {
+		if b.checkDrift(ctx, threshold) {
+			drifted = true
+		}
+	}
+	if !drifted {
+		return
+	}
+
+	result, err := Reload(ctx)
+	if err != nil {
+		slog.Error("reconciliation reload failed", "error", err)
+		return
+	}
+	slog.Info("reconciliation reloaded caches",
+		"users_before", result.UsersBefore,
+		"users_after", result.UsersAfter,
+		"duration_ms", result.DurationMs,
+	)
+	for _, b := range Boards() {
+		b.stats.mu.Lock()
+		if b.stats.LastReconcile != nil && b.stats.LastReconcile.Drift > threshold {
+			b.stats.LastReconcile.Repaired = true
+		}
+		b.stats.mu.Unlock()
+	}
+}
+
+// checkDrift compares the board's cache with MongoDB, records the outcome in
+// its stats and reports whether more than threshold users differ. The cheap
+// count comparison runs first; IDs are only fetched when the counts disagree,
+// and a difference that vanishes by then is treated as an in-flight write.
+func (b *Board) checkDrift(ctx context.Context, threshold int) bool {
+	countCtx, cancel := dbContext(ctx)
+	dbCount, err := database.Collection("users").CountDocuments(countCtx, b.filter(activeUsers))
+	cancel()
+	if err != nil {
+		slog.Warn("reconciliation count failed", "board", b.ID, "error", err)
+		return false
+	}
+
+	status := &models.Reconciliation{
+		At:         time.Now(),
+		CacheUsers: b.cache.Size(),
+		DBUsers:    int(dbCount),
+	}
+	defer func() {
+		b.stats.mu.Lock()
+		b.stats.LastReconcile = status
+		b.stats.mu.Unlock()
+	}()
+
+	if status.CacheUsers == status.DBUsers {
+		return false
+	}
+
+	missing, extra, err := b.driftedIDs(ctx)
+	if err != nil {
+		slog.Warn("reconciliation ID scan failed", "board", b.ID, "error", err)
+		return false
+	}
+	status.Drift = len(missing) + len(extra)
+	if status.Drift == 0 {
+		return false
+	}
+
+	slog.Warn("cache drifted from MongoDB",
+		"board", b.ID,
+		"cache_users", status.CacheUsers,
+		"db_users", status.DBUsers,
+		"missing_from_cache", len(missing),
+		"missing_from_db", len(extra),
+		"missing_from_cache_ids", firstIDs(missing),
+		"missing_from_db_ids", firstIDs(extra),
+	)
+	return status.Drift > threshold
+}
+
+// driftedIDs returns the user IDs stored in MongoDB but absent from the
+// cache, and those cached but absent from MongoDB, both sorted.
+func (b *Board) driftedIDs(ctx context.Context) (missing, extra []string, err error) {
+	ctx, cancel := dbContext(ctx)
+	defer cancel()
+
+	cursor, err := database.Collection("users").Find(ctx, b.filter(activeUsers), options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cursor.Close(ctx)
+
+	cached := b.cache.GetAllWithIDs()
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, nil, err
+		}
+		id := doc.ID.Hex()
+		if _, ok := cached[id]; ok {
+			delete(cached, id)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	for id := range cached {
+		extra = append(extra, id)
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	return missing, extra, nil
+}
+
+// firstIDs trims ids to what a single log line should carry.
+func firstIDs(ids []string) []string {
+	if len(ids) > maxLoggedDriftIDs {
+		return ids[:maxLoggedDriftIDs]
+	}
+	return ids
+}
@@ -0,0 +1,59 @@
+// Package services retries idempotent MongoDB writes on transient failures.
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	DefaultDBRetryAttempts  = 3
+	DefaultDBRetryBackoffMS = 200
+)
+
+var (
+	dbRetryAttempts = DefaultDBRetryAttempts
+	dbRetryBackoff  = DefaultDBRetryBackoffMS * time.Millisecond
+)
+
+// withRetry runs op, giving each attempt its own dbContext, and retries
+// transient network failures with a linearly growing backoff like the seed's.
+// Only wrap idempotent writes: an attempt that fails in transit may still
+// have been applied, so inserts and increments must not be retried.
+func withRetry(ctx context.Context, op func(ctx context.Context) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		opCtx, cancel := dbContext(ctx)
+		err = op(opCtx)
+		cancel()
+
+		if err == nil || !isTransient(err) || attempt >= dbRetryAttempts {
+			return err
+		}
+
+		slog.Warn("retrying database operation",
+			"attempt", attempt,
+			"max_attempts", dbRetryAttempts,
+			"error", err,
+		)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt) * dbRetryBackoff):
+		}
+	}
+}
+
+// isTransient reports whether err is a network blip worth retrying, as
+// opposed to a timeout or a rejected write.
+func isTransient(err error) bool {
+	if mongo.IsNetworkError(err) {
+		return true
+	}
+	var se mongo.ServerError
+	return errors.As(err, &se) && se.HasErrorLabel("RetryableWriteError")
+}
@@ -0,0 +1,195 @@
+// Package services contains season archival and rollover.
+package services
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"time"
+
+	"matiks-leaderboard/database"
+	"matiks-leaderboard/env"
+	"matiks-leaderboard/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	seasonsCollection = "seasons"
+	seasonBatchSize   = 1000
+)
+
+var seasonLabelPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// ErrSeasonExists is returned when rolling over into an already archived label.
+var ErrSeasonExists = &ValidationError{"season label already exists"}
+
+// initSeasons creates the index used to page through archived standings.
+func initSeasons(ctx context.Context) {
+	ctx, cancel := dbContext(ctx)
+	defer cancel()
+	err := database.EnsureIndex(ctx, seasonsCollection, mongo.IndexModel{
+		Keys: bson.D{{Key: "board", Value: 1}, {Key: "season", Value: 1}, {Key: "rank", Value: 1}},
+	})
+	if err != nil {
+		log.Printf("⚠️ Failed to create seasons index: %v", err)
+	}
+}
+
+// DefaultSeasonBaseline is the score users are reset to when no baseline is given.
+// Overridden by SEASON_BASELINE_SCORE.
+func DefaultSeasonBaseline() int {
+	return env.PositiveInt("SEASON_BASELINE_SCORE", 100)
+}
+
+// RolloverSeason archives the board's current standings under label, then
+// resets every user's score to baseline, dropping any score components, and
+// rebuilds. Readers keep seeing the old snapshot until the rebuilt one is
+// swapped in. A rollover that fails deletes what it archived, so it can be
+// retried under the same label.
+func (b *Board) RolloverSeason(ctx context.Context, label string, baseline int) (*models.RolloverResult, error) {
+	if !seasonLabelPattern.MatchString(label) {
+		return nil, &ValidationError{"season label must be 1-64 letters, digits, '.', '_' or '-'"}
+	}
+	if baseline < minScore || baseline > maxScore {
+		return nil, &ValidationError{"Score must be between 100 and 5000"}
+	}
+
+	b.rolloverMu.Lock()
+	defer b.rolloverMu.Unlock()
+
+	start := time.Now()
+	collection := database.Collection(seasonsCollection)
+
+	countCtx, cancel := dbContext(ctx)
+	exists, err := collection.CountDocuments(countCtx, b.filter(bson.M{"season": label}), options.Count().SetLimit(1))
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	if exists > 0 {
+		return nil, ErrSeasonExists
+	}
+
+	entries := b.topEntries(b.snapshot.Size())
+	archivedAt := time.Now()
+	for i := 0; i < len(entries); i += seasonBatchSize {
+		end := i + seasonBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		docs := make([]interface{}, 0, end-i)
+		for _, e := range entries[i:end] {
+			docs = append(docs, models.SeasonEntry{
+				Board:      b.storedBoard(),
+				Season:     label,
+				Rank:       e.Rank,
+				UserID:     e.UserID,
+				Username:   e.Username,
+				Score:      e.Score,
+				ArchivedAt: archivedAt,
+			})
+		}
+		insertCtx, cancel := dbContext(ctx)
+		_, err := collection.InsertMany(insertCtx, docs)
+		cancel()
+		if err != nil {
+			b.discardSeason(label)
+			return nil, err
+		}
+	}
+
+	var result *mongo.UpdateResult
+	err = withRetry(ctx, func(ctx context.Context) error {
+		result, err = database.Collection("users").UpdateMany(
+			ctx,
+			b.filter(nil),
+			bson.M{
+				"$set":   bson.M{"score": baseline},
+				"$unset": bson.M{"components": ""},
+			},
+		)
+		return err
+	})
+	if err != nil {
+		b.discardSeason(label)
+		return nil, err
+	}
+
+	for id, e := range b.cache.GetAllWithIDs() {
+		e.Score = baseline
+		b.cache.Set(id, e)
+	}
+	b.ForceRebuild()
+
+	return &models.RolloverResult{
+		Season:     label,
+		Archived:   len(entries),
+		Reset:      int(result.ModifiedCount),
+		Baseline:   baseline,
+		DurationMs: time.Since(start).Milliseconds(),
+	}, nil
+}
+
+// discardSeason deletes whatever part of label a failed rollover archived,
+// so the rollover can be retried under the same label. It runs detached
+// from the request, which may be what failed.
+func (b *Board) discardSeason(label string) {
+	err := withRetry(context.Background(), func(ctx context.Context) error {
+		_, err := database.Collection(seasonsCollection).DeleteMany(ctx, b.filter(bson.M{"season": label}))
+		return err
+	})
+	if err != nil {
+		log.Printf("⚠️ Failed to discard partial archive of season %q on board %q: %v", label, b.ID, err)
+	}
+}
+
+// GetSeasonLeaderboard returns a page of an archived season's final standings.
+// Returns nil if the season does not exist for this board.
+func (b *Board) GetSeasonLeaderboard(ctx context.Context, label string, page, limit int) (*models.LeaderboardResponse, error) {
+	collection := database.Collection(seasonsCollection)
+	filter := b.filter(bson.M{"season": label})
+	ctx, cancel := dbContext(ctx)
+	defer cancel()
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if total == 0 {
+		return nil, nil
+	}
+
+	cursor, err := collection.Find(
+		ctx,
+		filter,
+		options.Find().
+			SetSort(bson.D{{Key: "rank", Value: 1}, {Key: "username", Value: 1}}).
+			SetSkip(int64((page-1)*limit)).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var archived []models.SeasonEntry
+	if err := cursor.All(ctx, &archived); err != nil {
+		return nil, err
+	}
+
+	entries := make([]models.LeaderboardEntry, len(archived))
+	for i, e := range archived {
+		entries[i] = models.LeaderboardEntry{
+			UserID:   e.UserID,
+			Username: e.Username,
+			Rating:   e.Score,
+			Rank:     e.Rank,
+		}
+	}
+
+	return newLeaderboardResponse(entries, int(total), page, limit), nil
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"matiks-leaderboard/database"
+	"matiks-leaderboard/database/dbtest"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFailedRolloverLeavesNoArchive(t *testing.T) {
+	b, users := loadUsers(t,
+		testUser("alice", 400),
+		testUser("bob", 300),
+		testUser("carol", 200),
+		testUser("dave", 100),
+	)
+	seasons := database.Collection(seasonsCollection).(*dbtest.Collection)
+
+	// The archive stops at carol, after alice and bob were written
+	seasons.FailWrite = func(doc bson.M) bool { return doc["username"] == "carol" }
+	if _, err := b.RolloverSeason(context.Background(), "s1", 150); err == nil {
+		t.Fatal("RolloverSeason succeeded with a failing archive write")
+	}
+	if n := seasons.Len(); n != 0 {
+		t.Errorf("failed rollover left %d archived entries", n)
+	}
+	if docs := users.Docs(bson.M{"score": 150}); len(docs) != 0 {
+		t.Errorf("failed rollover reset %d users", len(docs))
+	}
+
+	seasons.FailWrite = nil
+	result, err := b.RolloverSeason(context.Background(), "s1", 150)
+	if err != nil {
+		t.Fatalf("retried RolloverSeason: %v", err)
+	}
+	if result.Archived != 4 || seasons.Len() != 4 {
+		t.Errorf("retry archived %d, stored %d; want 4", result.Archived, seasons.Len())
+	}
+}
@@ -6,13 +6,17 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"matiks-leaderboard/database"
+	"matiks-leaderboard/env"
 	"matiks-leaderboard/models"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Username prefixes for variety
@@ -41,8 +45,11 @@ var specialNames = []string{
 // SeedDatabase creates 11,000 users with proper rating distribution.
 func SeedDatabase(ctx context.Context) (int, error) {
 	collection := database.Collection("users")
+	defaultUsers := DefaultBoard().filter(nil)
 
-	count, err := collection.CountDocuments(ctx, bson.M{})
+	countCtx, cancel := dbContext(ctx)
+	count, err := collection.CountDocuments(countCtx, defaultUsers)
+	cancel()
 	if err != nil {
 		return 0, err
 	}
@@ -52,19 +59,131 @@ func SeedDatabase(ctx context.Context) (int, error) {
 		return 0, nil
 	}
 
-	// Drop existing data to ensure clean seeding
+	// Remove existing default-board users to ensure clean seeding.
+	// Other boards are left untouched.
 	if count > 0 {
 		log.Printf("🗑️ Dropping existing %d users for clean reseed...", count)
-		if err := collection.Drop(ctx); err != nil {
-			return 0, fmt.Errorf("failed to drop collection: %w", err)
+		deleteCtx, cancel := dbContext(ctx)
+		_, err := collection.DeleteMany(deleteCtx, defaultUsers)
+		cancel()
+		if err != nil {
+			return 0, fmt.Errorf("failed to drop existing users: %w", err)
 		}
 	}
 
 	log.Println("🌱 Seeding 11,000 users with varied names...")
 
+	users := generateSeedUsers(seedRNG())
+
+	// Insert in batches with retry logic
+	batchSize, batchDelay := seedBatchConfig()
+	totalBatches := (len(users) + batchSize - 1) / batchSize
+	inserted := 0
+
+	for i := 0; i < len(users); i += batchSize {
+		end := i + batchSize
+		if end > len(users) {
+			end = len(users)
+		}
+
+		batchNum := (i / batchSize) + 1
+		written, err := insertSeedBatch(collection, users[i:end], batchNum)
+		inserted += written
+		if err != nil {
+			return 0, err
+		}
+		log.Printf("   Inserted batch %d/%d (%d users)", batchNum, totalBatches, written)
+
+		if batchDelay > 0 {
+			time.Sleep(batchDelay)
+		}
+	}
+
+	// Re-initialize the leaderboard cache with a fresh context
+	initCtx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	if err := Initialize(initCtx); err != nil {
+		return 0, fmt.Errorf("failed to initialize after seeding: %w", err)
+	}
+
+	log.Printf("✅ Successfully seeded %d users with varied names", inserted)
+	return inserted, nil
+}
+
+// seedBatchRetries is how many times a seed batch is attempted.
+const seedBatchRetries = 3
+
+// insertSeedBatch inserts batch unordered, so one bad document doesn't stop
+// the rest, and retries only the documents that failed. A duplicate key is
+// never retried: the username is taken and the user is skipped, unless a
+// retry collides on _id, which means an earlier attempt did write it.
+// Returns how many of the batch are in the database.
+func insertSeedBatch(collection database.Collections, batch []interface{}, batchNum int) (int, error) {
+	written := 0
+	pending := batch
+	var lastErr error
+	for attempt := 1; attempt <= seedBatchRetries && len(pending) > 0; attempt++ {
+		batchCtx, cancel := dbContext(context.Background())
+		_, err := collection.InsertMany(batchCtx, pending, options.InsertMany().SetOrdered(false))
+		cancel()
+
+		failed := writeFailures(err, len(pending))
+		var retry []interface{}
+		for i, doc := range pending {
+			we, ok := failed[i]
+			switch {
+			case !ok:
+				written++
+			case we.Code == duplicateKeyCode && attempt > 1 && strings.Contains(we.Message, "_id_"):
+				written++
+			case we.Code == duplicateKeyCode:
+				log.Printf("   ⚠️ Skipping %q in batch %d: %s", doc.(models.User).Username, batchNum, we.Message)
+			default:
+				retry = append(retry, doc)
+				lastErr = err
+			}
+		}
+		if len(retry) > 0 {
+			log.Printf("   ⚠️ Batch %d: %d users failed (attempt %d/%d): %v", batchNum, len(retry), attempt, seedBatchRetries, lastErr)
+			if attempt < seedBatchRetries {
+				time.Sleep(time.Duration(2*attempt) * time.Second)
+			}
+		}
+		pending = retry
+	}
+
+	if len(pending) > 0 {
+		return written, fmt.Errorf("failed to insert %d users of batch %d after %d attempts: %w", len(pending), batchNum, seedBatchRetries, lastErr)
+	}
+	return written, nil
+}
+
+// seedBatchConfig reads SEED_BATCH_SIZE (default 200) and
+// SEED_BATCH_DELAY_MS, the pause between batches (default 100; 0 disables it).
+func seedBatchConfig() (int, time.Duration) {
+	delay := 100 * time.Millisecond
+	if v, err := strconv.Atoi(os.Getenv("SEED_BATCH_DELAY_MS")); err == nil && v >= 0 {
+		delay = time.Duration(v) * time.Millisecond
+	}
+	return env.PositiveInt("SEED_BATCH_SIZE", 200), delay
+}
+
+// seedRNG returns the generator for seed data. Setting SEED_RNG to an integer
+// fixes its seed, so every run generates the same usernames and scores;
+// otherwise each run differs.
+func seedRNG() *rand.Rand {
+	if v, err := strconv.ParseInt(os.Getenv("SEED_RNG"), 10, 64); err == nil {
+		log.Printf("   Using fixed seed %d", v)
+		return rand.New(rand.NewSource(v))
+	}
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
+
+// generateSeedUsers builds the 11,000 seed users, drawing names from rng.
+func generateSeedUsers(rng *rand.Rand) []interface{} {
 	var users []interface{}
 	usedNames := make(map[string]bool)
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 
 	// Helper to generate unique username
 	generateUniqueName := func(rating, index int) string {
@@ -108,6 +227,7 @@ func SeedDatabase(ctx context.Context) (int, error) {
 				ID:       primitive.NewObjectID(),
 				Username: specialName,
 				Score:    rating,
+				Active:   true,
 			})
 			usedNames[specialName] = true
 		}
@@ -137,59 +257,12 @@ func SeedDatabase(ctx context.Context) (int, error) {
 				ID:       primitive.NewObjectID(),
 				Username: username,
 				Score:    rating,
+				Active:   true,
 			})
 			userIndex++
 		}
 	}
 
 	log.Printf("   Generated %d total users", len(users))
-
-	// Insert in batches with retry logic
-	batchSize := 200
-	maxRetries := 3
-
-	for i := 0; i < len(users); i += batchSize {
-		end := i + batchSize
-		if end > len(users) {
-			end = len(users)
-		}
-
-		batch := users[i:end]
-		batchNum := (i / batchSize) + 1
-		totalBatches := (len(users) + batchSize - 1) / batchSize
-
-		var lastErr error
-		for retry := 0; retry < maxRetries; retry++ {
-			batchCtx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-			_, err := collection.InsertMany(batchCtx, batch)
-			cancel()
-
-			if err == nil {
-				log.Printf("   Inserted batch %d/%d (%d users)", batchNum, totalBatches, len(batch))
-				lastErr = nil
-				break
-			}
-
-			lastErr = err
-			log.Printf("   ⚠️ Batch %d failed (attempt %d/%d): %v", batchNum, retry+1, maxRetries, err)
-			time.Sleep(time.Duration(2*(retry+1)) * time.Second)
-		}
-
-		if lastErr != nil {
-			return 0, fmt.Errorf("failed to insert batch %d after %d retries: %w", batchNum, maxRetries, lastErr)
-		}
-
-		time.Sleep(100 * time.Millisecond)
-	}
-
-	// Re-initialize the leaderboard cache with a fresh context
-	initCtx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
-	defer cancel()
-
-	if err := Initialize(initCtx); err != nil {
-		return 0, fmt.Errorf("failed to initialize after seeding: %w", err)
-	}
-
-	log.Printf("✅ Successfully seeded %d users with varied names", len(users))
-	return len(users), nil
+	return users
 }
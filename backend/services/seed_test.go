@@ -0,0 +1,35 @@
+package services
+
+import (
+	"testing"
+
+	"matiks-leaderboard/models"
+)
+
+func TestFixedSeedGeneratesSameUsers(t *testing.T) {
+	t.Setenv("SEED_RNG", "42")
+	first, second := generateSeedUsers(seedRNG()), generateSeedUsers(seedRNG())
+
+	if len(first) != 11000 || len(second) != len(first) {
+		t.Fatalf("generated %d and %d users, want 11000 each", len(first), len(second))
+	}
+	for i := range first {
+		a, b := first[i].(models.User), second[i].(models.User)
+		if a.Username != b.Username || a.Score != b.Score {
+			t.Fatalf("user %d differs between runs: %s/%d then %s/%d", i, a.Username, a.Score, b.Username, b.Score)
+		}
+	}
+
+	t.Setenv("SEED_RNG", "43")
+	other := generateSeedUsers(seedRNG())
+	same := true
+	for i := range first {
+		if first[i].(models.User).Username != other[i].(models.User).Username {
+			same = false
+			break
+		}
+	}
+	if same {
+		t.Error("seeds 42 and 43 generated the same usernames")
+	}
+}
@@ -0,0 +1,107 @@
+// Package services contains the percentile tier bands.
+package services
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"matiks-leaderboard/models"
+)
+
+// TierBand names the users whose percentile is at least MinPercentile,
+// up to the next band.
+type TierBand struct {
+	Name          string  `json:"name"`
+	MinPercentile float64 `json:"minPercentile"`
+}
+
+// DefaultTierBands are used unless TIER_BANDS or TIER_BANDS_FILE is set.
+var DefaultTierBands = []TierBand{
+	{Name: "Diamond", MinPercentile: 95},
+	{Name: "Platinum", MinPercentile: 80},
+	{Name: "Gold", MinPercentile: 60},
+	{Name: "Silver", MinPercentile: 30},
+	{Name: "Bronze", MinPercentile: 0},
+}
+
+// tierBands is ordered from the highest MinPercentile down.
+var tierBands = DefaultTierBands
+
+// LoadTierConfig reads the tier bands as a JSON array of
+// {"name": ..., "minPercentile": ...} objects, from TIER_BANDS or else the
+// file named by TIER_BANDS_FILE. Malformed bands fall back to the defaults.
+// Bands needn't reach down to 0; users below the lowest get no tier.
+func LoadTierConfig() {
+	raw := []byte(os.Getenv("TIER_BANDS"))
+	source := "TIER_BANDS"
+	if len(raw) == 0 {
+		path := os.Getenv("TIER_BANDS_FILE")
+		if path == "" {
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn("cannot read TIER_BANDS_FILE, using default tiers", "path", path, "error", err)
+			return
+		}
+		raw, source = data, "TIER_BANDS_FILE"
+	}
+
+	var bands []TierBand
+	if err := json.Unmarshal(raw, &bands); err != nil || len(bands) == 0 {
+		slog.Warn("invalid tier bands, using defaults", "source", source, "error", err)
+		return
+	}
+	for _, band := range bands {
+		if band.Name == "" || band.MinPercentile < 0 || band.MinPercentile > 100 {
+			slog.Warn("tier bands need a name and a minPercentile from 0 to 100, using defaults", "source", source)
+			return
+		}
+	}
+	sort.SliceStable(bands, func(i, j int) bool {
+		return bands[i].MinPercentile > bands[j].MinPercentile
+	})
+	tierBands = bands
+	slog.Info("tier bands configured", "source", source, "tiers", len(bands))
+}
+
+// tierForPercentile returns the band a percentile falls in, or "" if it is
+// below every band.
+func tierForPercentile(percentile float64) string {
+	for _, band := range tierBands {
+		if percentile >= band.MinPercentile {
+			return band.Name
+		}
+	}
+	return ""
+}
+
+// GetUserTier places a user in the tier bands by percentile, or returns nil
+// if the user isn't ranked.
+func (b *Board) GetUserTier(userID string) *models.UserTier {
+	entry, ok := b.cache.Get(userID)
+	if !ok {
+		return nil
+	}
+	rank := b.snapshot.GetRank(userID)
+	total := b.snapshot.Size()
+	if rank == 0 || total == 0 {
+		return nil
+	}
+
+	percentile := b.snapshot.Percentile(entry.Score)
+	// Rounded up, so only a true top-1% user can read "Top 1%"
+	top := max(1, int(math.Ceil(float64(rank)*100/float64(total))))
+	return &models.UserTier{
+		UserID:     userID,
+		Rank:       rank,
+		TotalUsers: total,
+		Percentile: percentile,
+		TopPercent: "Top " + strconv.Itoa(top) + "%",
+		Tier:       tierForPercentile(percentile),
+	}
+}
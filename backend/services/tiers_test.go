@@ -0,0 +1,33 @@
+package services
+
+import (
+	"strconv"
+	"testing"
+
+	"matiks-leaderboard/models"
+)
+
+func TestPreviewRankTierMatchesUserTier(t *testing.T) {
+	users := make([]models.User, 10)
+	for i := range users {
+		users[i] = testUser("user"+strconv.Itoa(i), 200+i*100)
+	}
+	b, _ := loadUsers(t, users...)
+
+	for _, u := range users {
+		preview, err := b.PreviewRank(u.Score, 0)
+		if err != nil {
+			t.Fatalf("PreviewRank(%d): %v", u.Score, err)
+		}
+		tier := b.GetUserTier(u.ID.Hex())
+		if preview.Tier != tier.Tier || preview.Percentile != tier.Percentile {
+			t.Errorf("score %d: preview tier %q at %.0f%%, user tier %q at %.0f%%",
+				u.Score, preview.Tier, preview.Percentile, tier.Tier, tier.Percentile)
+		}
+	}
+
+	// 1200 tops this board, however low it is in absolute terms
+	if preview, _ := b.PreviewRank(1200, 0); preview.Tier != "Diamond" {
+		t.Errorf("board-leading score previews as %q, want Diamond", preview.Tier)
+	}
+}
@@ -0,0 +1,69 @@
+// Package services caches the serialized top of each board's leaderboard.
+package services
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"matiks-leaderboard/engine"
+)
+
+// topCache holds the first entries of one snapshot generation, already
+// encoded as comma-separated JSON objects. ends[i] is where entry i ends in
+// rows, so the top n is rows[:ends[n-1]] without any further copying.
+type topCache struct {
+	generation uint64
+	rows       []byte
+	ends       []int
+}
+
+// warmTopCache encodes the top of the freshly rebuilt snapshot. Called after
+// every rebuild; a cache from an older generation is never served.
+func (b *Board) warmTopCache() {
+	if topCacheSize == 0 {
+		return
+	}
+	entries, generation := b.snapshot.ViewTopGeneration(topCacheSize)
+	leaderboard := toLeaderboardEntries(entries, engine.RankStandard, 0)
+
+	tc := &topCache{generation: generation, ends: make([]int, 0, len(leaderboard))}
+	for i := range leaderboard {
+		row, err := json.Marshal(&leaderboard[i])
+		if err != nil {
+			// Leave the previous cache in place; its stale generation keeps
+			// it from being served
+			slog.Error("failed to encode top cache", "board", b.ID, "error", err)
+			return
+		}
+		if i > 0 {
+			tc.rows = append(tc.rows, ',')
+		}
+		tc.rows = append(tc.rows, row...)
+		tc.ends = append(tc.ends, len(tc.rows))
+	}
+	b.top.Store(tc)
+}
+
+// CachedTopN returns the top n entries as comma-separated JSON objects,
+// without the enclosing brackets, and how many there are. ok is false when
+// the cache can't answer: it is disabled, n reaches past what it holds, or
+// the snapshot has been rebuilt since it was encoded. rows is shared and must
+// not be modified.
+func (b *Board) CachedTopN(n int) (rows []byte, count int, ok bool) {
+	if topCacheSize == 0 {
+		return nil, 0, false
+	}
+	tc := b.top.Load()
+	if tc == nil || tc.generation != b.snapshot.Generation() {
+		return nil, 0, false
+	}
+	// A cache shorter than its size holds the whole board
+	if n > topCacheSize && len(tc.ends) == topCacheSize {
+		return nil, 0, false
+	}
+	count = min(n, len(tc.ends))
+	if count == 0 {
+		return nil, 0, true
+	}
+	return tc.rows[:tc.ends[count-1]], count, true
+}
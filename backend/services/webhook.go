@@ -0,0 +1,194 @@
+// Package services notifies a webhook when users cross rank thresholds.
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"matiks-leaderboard/env"
+	"matiks-leaderboard/models"
+)
+
+const (
+	DefaultWebhookTimeout  = 5 * time.Second
+	DefaultWebhookAttempts = 3
+	DefaultWebhookBackoff  = time.Second
+	// webhookQueueSize bounds the deliveries waiting to be sent. When the
+	// endpoint falls this far behind, newer deliveries are dropped.
+	webhookQueueSize = 100
+)
+
+// DefaultWebhookThresholds are watched unless WEBHOOK_THRESHOLDS is set.
+var DefaultWebhookThresholds = []int{10}
+
+var (
+	webhookURL        string
+	webhookThresholds = DefaultWebhookThresholds
+	webhookAttempts   = DefaultWebhookAttempts
+	webhookBackoff    = DefaultWebhookBackoff
+	webhookClient     = &http.Client{Timeout: DefaultWebhookTimeout}
+	webhookQueue      chan models.RankWebhook
+)
+
+// LoadWebhookConfig reads WEBHOOK_URL and, when it is set, starts the
+// sender. WEBHOOK_THRESHOLDS lists the top-N cut-offs to watch (default 10),
+// WEBHOOK_TIMEOUT bounds each attempt and WEBHOOK_ATTEMPTS caps the tries,
+// with a linearly growing WEBHOOK_BACKOFF between them.
+func LoadWebhookConfig() {
+	webhookURL = os.Getenv("WEBHOOK_URL")
+	if webhookURL == "" {
+		return
+	}
+
+	if raw := os.Getenv("WEBHOOK_THRESHOLDS"); raw != "" {
+		thresholds, err := parseThresholds(raw)
+		if err != nil {
+			slog.Warn("invalid WEBHOOK_THRESHOLDS, using defaults", "error", err)
+		} else {
+			webhookThresholds = thresholds
+		}
+	}
+	webhookClient = &http.Client{Timeout: env.PositiveDuration("WEBHOOK_TIMEOUT", DefaultWebhookTimeout)}
+	webhookAttempts = env.PositiveInt("WEBHOOK_ATTEMPTS", DefaultWebhookAttempts)
+	webhookBackoff = env.PositiveDuration("WEBHOOK_BACKOFF", DefaultWebhookBackoff)
+
+	webhookQueue = make(chan models.RankWebhook, webhookQueueSize)
+	go sendWebhooks(webhookQueue)
+	slog.Info("rank webhook configured",
+		"thresholds", webhookThresholds,
+		"timeout", webhookClient.Timeout.String(),
+		"attempts", webhookAttempts,
+	)
+}
+
+// parseThresholds reads a comma-separated list of positive ranks, sorted
+// and without duplicates.
+func parseThresholds(raw string) ([]int, error) {
+	seen := make(map[int]bool)
+	var thresholds []int
+	for _, part := range strings.Split(raw, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%q is not a positive rank", part)
+		}
+		if !seen[n] {
+			seen[n] = true
+			thresholds = append(thresholds, n)
+		}
+	}
+	sort.Ints(thresholds)
+	return thresholds, nil
+}
+
+// notifyCrossings queues a webhook for the users the last rebuild moved
+// across a threshold. It never blocks the rebuild: if the queue is full the
+// delivery is dropped and logged.
+func (b *Board) notifyCrossings() {
+	if webhookQueue == nil {
+		return
+	}
+	crossings := b.snapshot.Crossings(webhookThresholds)
+	if len(crossings) == 0 {
+		return
+	}
+
+	events := make([]models.RankCrossingEvent, len(crossings))
+	for i, c := range crossings {
+		event := models.RankCrossingEvent{
+			Type:      "left",
+			Threshold: c.Threshold,
+			UserID:    c.UserID,
+			OldRank:   c.OldRank,
+			NewRank:   c.NewRank,
+		}
+		if c.Entered {
+			event.Type = "entered"
+		}
+		if entry, ok := b.cache.Get(c.UserID); ok {
+			event.Username = entry.Username
+		}
+		events[i] = event
+	}
+
+	payload := models.RankWebhook{
+		Board:      b.ID,
+		Generation: b.snapshot.Generation(),
+		Timestamp:  time.Now(),
+		Events:     events,
+	}
+	select {
+	case webhookQueue <- payload:
+	default:
+		slog.Warn("rank webhook queue full, dropping delivery", "board", b.ID, "events", len(events))
+	}
+}
+
+// sendWebhooks delivers queued webhooks one at a time, in rebuild order.
+func sendWebhooks(queue <-chan models.RankWebhook) {
+	for payload := range queue {
+		if err := deliverWebhook(payload); err != nil {
+			slog.Error("rank webhook failed",
+				"board", payload.Board,
+				"generation", payload.Generation,
+				"events", len(payload.Events),
+				"error", err,
+			)
+		}
+	}
+}
+
+// deliverWebhook POSTs payload, retrying network errors, 429s and 5xx
+// responses. Other responses are the receiver rejecting it and aren't
+// retried.
+func deliverWebhook(payload models.RankWebhook) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, err := postWebhook(body)
+		if err == nil || !retry || attempt >= webhookAttempts {
+			return err
+		}
+		slog.Warn("retrying rank webhook",
+			"attempt", attempt,
+			"max_attempts", webhookAttempts,
+			"error", err,
+		)
+		time.Sleep(time.Duration(attempt) * webhookBackoff)
+	}
+}
+
+// postWebhook makes one attempt and reports whether a failure is worth
+// retrying.
+func postWebhook(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
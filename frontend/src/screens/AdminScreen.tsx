@@ -109,7 +109,7 @@ export const AdminScreen: React.FC = () => {
                     )
                 );
             } else {
-                setMessage({ type: 'error', text: response.error || 'Update failed' });
+                setMessage({ type: 'error', text: response.error?.message || 'Update failed' });
             }
         } catch (err: any) {
             setMessage({ type: 'error', text: err.message || 'Update failed' });
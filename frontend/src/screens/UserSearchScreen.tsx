@@ -41,7 +41,7 @@ export const UserSearchScreen: React.FC = () => {
           setSearchResults(response.data.users);
         }
       } else {
-        setError(response.error || 'Failed to search users');
+        setError(response.error?.message || 'Failed to search users');
       }
     } catch (err: any) {
       setError(err.message || 'Failed to search users');
@@ -43,7 +43,7 @@ class ApiService {
     if (error.response) {
       // Server responded with error status
       const data = error.response.data as any;
-      return new Error(data?.error || 'Server error occurred');
+      return new Error(data?.error?.message || 'Server error occurred');
     } else if (error.request) {
       // Request made but no response
       return new Error('Network error - please check your connection');
@@ -3,6 +3,12 @@ export interface LeaderboardEntry {
   username: string;
   rating: number;
   userId: string;
+  // Users sharing this rating, and the rank as shown to players ("T-5" when tied)
+  tiedCount?: number;
+  displayRank?: string;
+  // ISO 3166-1 alpha-2 code; globalRank is set on ?country= leaderboards
+  country?: string;
+  globalRank?: number;
 }
 
 export interface LeaderboardResponse {
@@ -22,10 +28,16 @@ export interface UserSearchResponse {
     username: string;
     rating: number;
   };
-  error?: string;
+  error?: ApiErrorBody;
+}
+
+// Every failed response carries a stable code and a human-readable message.
+export interface ApiErrorBody {
+  code: string;
+  message: string;
 }
 
 export interface ApiError {
   success: false;
-  error: string;
+  error: ApiErrorBody;
 }